@@ -0,0 +1,72 @@
+package commands
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/cheebz/go-pub/pkg/cache"
+	"github.com/cheebz/go-pub/pkg/config"
+	"github.com/cheebz/go-pub/pkg/media"
+	"github.com/cheebz/go-pub/pkg/repositories"
+	"github.com/cheebz/go-pub/pkg/streams"
+)
+
+func PurgeFiles() error {
+	purgeCmd := flag.NewFlagSet(os.Args[1], flag.ExitOnError)
+
+	var dryRun bool
+	purgeCmd.BoolVar(&dryRun, "dry-run", false, "Report what would be deleted without deleting anything")
+	var grace int
+	purgeCmd.IntVar(&grace, "grace", -1, "Hours an unused file is kept (defaults to FILE_PURGE_GRACE_HOURS)")
+
+	purgeCmd.Usage = func() {
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintf(os.Stderr, "Usage: %s %s [flags]\n", os.Args[0], os.Args[1])
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintln(os.Stderr, "Delete stored files nothing refers to, and file records whose files are missing")
+		fmt.Fprintln(os.Stderr, "")
+		fmt.Fprintf(os.Stderr, "Flags:\n")
+		purgeCmd.PrintDefaults()
+		fmt.Fprintln(os.Stderr, "")
+	}
+
+	purgeCmd.Parse(os.Args[2:])
+
+	// Get configuration
+	ENV := os.Getenv("ENV")
+	conf, err := config.ReadConfig(ENV)
+	if err != nil {
+		return err
+	}
+	if grace < 0 {
+		grace = conf.FilePurgeGrace
+	}
+
+	// create cache layer
+	cache := cache.NewRedisCache(conf)
+	// create stream broker
+	broker := streams.NewPubSubBroker(conf)
+	// create repository
+	repo := repositories.NewPSQLRepository(conf, cache, broker)
+	defer repo.Close()
+	// create media storage
+	storage, err := media.NewStorage(conf)
+	if err != nil {
+		return err
+	}
+
+	purge, err := repo.PurgeUnusedFiles(storage, time.Duration(grace)*time.Hour, dryRun)
+	if err != nil {
+		return err
+	}
+	verb := "Deleted"
+	if dryRun {
+		verb = "Would delete"
+	}
+	fmt.Printf("%s %d unused files (%d bytes)\n", verb, purge.Files, purge.Bytes)
+	fmt.Printf("%s %d records of missing files\n", verb, purge.Rows)
+	fmt.Printf("Kept %d unused files newer than %d hours\n", purge.Kept, grace)
+	return nil
+}
@@ -13,11 +13,13 @@ import (
 	"github.com/cheebz/go-pub/pkg/config"
 	"github.com/cheebz/go-pub/pkg/handlers"
 	"github.com/cheebz/go-pub/pkg/logging"
+	"github.com/cheebz/go-pub/pkg/media"
 	"github.com/cheebz/go-pub/pkg/middleware"
 	"github.com/cheebz/go-pub/pkg/repositories"
 	"github.com/cheebz/go-pub/pkg/resources"
 	"github.com/cheebz/go-pub/pkg/responses"
 	"github.com/cheebz/go-pub/pkg/services"
+	"github.com/cheebz/go-pub/pkg/streams"
 	"github.com/cheebz/go-pub/pkg/workers"
 )
 
@@ -47,24 +49,33 @@ func Serve() error {
 	if err != nil {
 		log.Println("failed to flush cache:", err)
 	}
+	// create stream broker
+	broker := streams.NewPubSubBroker(conf)
 	// create repository
-	repo := repositories.NewPSQLRepository(conf, cache)
+	repo := repositories.NewPSQLRepository(conf, cache, broker)
 	defer repo.Close()
+	// create media storage
+	storage, err := media.NewStorage(conf)
+	if err != nil {
+		return err
+	}
 	// create file worker
-	fileWorker := workers.NewFileWorker(conf, repo)
+	fileWorker := workers.NewFileWorker(conf, repo, storage)
 	go fileWorker.Start()
 	// create federator
 	federator := activitypub.NewFederator(conf, repo)
+	// create webfinger client
+	webfinger := activitypub.NewWebFingerClient(conf, cache)
+	// create resource generator
+	resource := resources.NewActivityPubResource(conf)
 	// create service
-	service := services.NewActivityPubService(conf, repo, federator)
+	service := services.NewActivityPubService(conf, repo, federator, webfinger, storage, resource, broker)
 	// create response writer
 	response := responses.NewActivityPubResponse(conf.Debug)
 	// create middleware helper
 	middle := middleware.NewActivityPubMiddleware(conf.Client, conf.Auth, response)
-	// create resource generator
-	resource := resources.NewActivityPubResource(conf)
 	// create handler (TODO: Make an options struct??)
-	handler := handlers.NewMuxHandler(conf, middle, service, resource, response)
+	handler := handlers.NewMuxHandler(conf, middle, service, storage, resource, response)
 	if conf.AllowedOrigins != "" {
 		handler.AllowCORS(strings.Split(conf.AllowedOrigins, ","))
 	}
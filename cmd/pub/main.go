@@ -20,7 +20,8 @@ func main() {
 		fmt.Fprintf(os.Stderr, "Usage: %s <command> [flags]\n", os.Args[0])
 		fmt.Fprintln(os.Stderr, "")
 		fmt.Fprintf(os.Stderr, "Commands:\n")
-		fmt.Fprintf(os.Stderr, "  serve    Start the server\n")
+		fmt.Fprintf(os.Stderr, "  serve          Start the server\n")
+		fmt.Fprintf(os.Stderr, "  purge-files    Delete unused stored files (-dry-run to only report them)\n")
 		fmt.Fprintln(os.Stderr, "")
 		fmt.Fprintf(os.Stderr, "Flags:\n")
 		rootCmd.PrintDefaults()
@@ -38,6 +39,10 @@ func main() {
 	switch command {
 	case "serve":
 		log.Fatal(commands.Serve())
+	case "purge-files":
+		if err := commands.PurgeFiles(); err != nil {
+			log.Fatal(err)
+		}
 	default:
 		log.Fatalf("Unknown command: %s\n", command)
 	}
@@ -15,6 +15,18 @@ BEGIN
 		CONSTRAINT users_pkey PRIMARY KEY (id)
 	);
 
+	ALTER TABLE public.users ADD COLUMN IF NOT EXISTS suspended bool NOT NULL DEFAULT false;
+	ALTER TABLE public.users ADD COLUMN IF NOT EXISTS deleted timestamptz NULL;
+	ALTER TABLE public.users ADD COLUMN IF NOT EXISTS also_known_as text[] NOT NULL DEFAULT '{}';
+	ALTER TABLE public.users ADD COLUMN IF NOT EXISTS moved_to text NOT NULL DEFAULT '';
+	ALTER TABLE public.users ADD COLUMN IF NOT EXISTS display_name text NOT NULL DEFAULT '';
+	ALTER TABLE public.users ADD COLUMN IF NOT EXISTS summary text NOT NULL DEFAULT '';
+	ALTER TABLE public.users ADD COLUMN IF NOT EXISTS icon text NOT NULL DEFAULT '';
+	ALTER TABLE public.users ADD COLUMN IF NOT EXISTS icon_media_type text NOT NULL DEFAULT '';
+	ALTER TABLE public.users ADD COLUMN IF NOT EXISTS image text NOT NULL DEFAULT '';
+	ALTER TABLE public.users ADD COLUMN IF NOT EXISTS image_media_type text NOT NULL DEFAULT '';
+	ALTER TABLE public.users ADD COLUMN IF NOT EXISTS actor_type text NOT NULL DEFAULT 'Person';
+
 	-- public.objects definition
 
 	CREATE TABLE IF NOT EXISTS public.objects (
@@ -28,6 +40,15 @@ BEGIN
 		CONSTRAINT objects_pkey PRIMARY KEY (id)
 	);
 
+	ALTER TABLE public.objects ADD COLUMN IF NOT EXISTS tag jsonb NULL;
+	ALTER TABLE public.objects ADD COLUMN IF NOT EXISTS attachment jsonb NULL;
+	ALTER TABLE public.objects ADD COLUMN IF NOT EXISTS public bool NOT NULL DEFAULT false;
+	ALTER TABLE public.objects ADD COLUMN IF NOT EXISTS published timestamptz NOT NULL DEFAULT now();
+	ALTER TABLE public.objects ADD COLUMN IF NOT EXISTS search tsvector GENERATED ALWAYS AS (
+		to_tsvector('simple', coalesce("name", '') || ' ' || regexp_replace(coalesce("content", ''), '<[^>]*>', ' ', 'g'))
+	) STORED;
+	CREATE INDEX IF NOT EXISTS objects_search_idx ON public.objects USING GIN (search);
+
 	-- public.object_files definition
 
 	CREATE TABLE IF NOT EXISTS public.object_files (
@@ -42,6 +63,15 @@ BEGIN
 		CONSTRAINT object_files_pkey PRIMARY KEY (id)
 	);
 
+	ALTER TABLE public.object_files ADD COLUMN IF NOT EXISTS width int4 NOT NULL DEFAULT 0;
+	ALTER TABLE public.object_files ADD COLUMN IF NOT EXISTS height int4 NOT NULL DEFAULT 0;
+	ALTER TABLE public.object_files ADD COLUMN IF NOT EXISTS blurhash text NOT NULL DEFAULT '';
+	ALTER TABLE public.object_files ADD COLUMN IF NOT EXISTS preview text NOT NULL DEFAULT '';
+	ALTER TABLE public.object_files ADD COLUMN IF NOT EXISTS preview_media_type text NOT NULL DEFAULT '';
+	ALTER TABLE public.object_files ADD COLUMN IF NOT EXISTS duration text NOT NULL DEFAULT '';
+	ALTER TABLE public.object_files ADD COLUMN IF NOT EXISTS waveform text NOT NULL DEFAULT '';
+	ALTER TABLE public.object_files ADD COLUMN IF NOT EXISTS sha256 text NOT NULL DEFAULT '';
+
 	ALTER TABLE public.object_files DROP CONSTRAINT IF EXISTS object_files_object_id_fk;
 	ALTER TABLE public.object_files ADD CONSTRAINT object_files_object_id_fk FOREIGN KEY (object_id) REFERENCES public.objects(id);
 
@@ -71,6 +101,137 @@ BEGIN
 	ALTER TABLE public.activities_to DROP CONSTRAINT IF EXISTS activities_to_activity_id_fk;
 	ALTER TABLE public.activities_to ADD CONSTRAINT activities_to_activity_id_fk FOREIGN KEY (activity_id) REFERENCES public.activities(id);
 
+	-- public.user_fields definition
+
+	CREATE TABLE IF NOT EXISTS public.user_fields (
+		id serial NOT NULL,
+		user_id int4 NOT NULL,
+		"position" int4 NOT NULL,
+		"name" text NOT NULL,
+		value text NOT NULL,
+		verified timestamptz NULL,
+		CONSTRAINT user_fields_pkey PRIMARY KEY (id)
+	);
+
+	ALTER TABLE public.user_fields DROP CONSTRAINT IF EXISTS user_fields_user_id_fk;
+	ALTER TABLE public.user_fields ADD CONSTRAINT user_fields_user_id_fk FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
+
+	-- public.domain_blocks definition
+
+	CREATE TABLE IF NOT EXISTS public.domain_blocks (
+		id serial NOT NULL,
+		"domain" text NOT NULL,
+		created timestamptz NOT NULL,
+		CONSTRAINT domain_blocks_pkey PRIMARY KEY (id),
+		CONSTRAINT domain_blocks_domain_key UNIQUE ("domain")
+	);
+
+	-- public.notifications definition
+
+	CREATE TABLE IF NOT EXISTS public.notifications (
+		id serial NOT NULL,
+		user_id int4 NOT NULL,
+		"type" text NOT NULL,
+		actor text NOT NULL,
+		activity text NOT NULL,
+		"object" text NOT NULL DEFAULT '',
+		created timestamptz NOT NULL DEFAULT now(),
+		"read" timestamptz NULL,
+		CONSTRAINT notifications_pkey PRIMARY KEY (id),
+		CONSTRAINT notifications_user_id_type_activity_key UNIQUE (user_id, "type", activity)
+	);
+
+	ALTER TABLE public.notifications DROP CONSTRAINT IF EXISTS notifications_user_id_fk;
+	ALTER TABLE public.notifications ADD CONSTRAINT notifications_user_id_fk FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
+
+	-- public.hashtags definition
+
+	CREATE TABLE IF NOT EXISTS public.hashtags (
+		id serial NOT NULL,
+		"name" text NOT NULL,
+		CONSTRAINT hashtags_pkey PRIMARY KEY (id),
+		CONSTRAINT hashtags_name_key UNIQUE ("name")
+	);
+
+	-- public.object_hashtags definition
+
+	CREATE TABLE IF NOT EXISTS public.object_hashtags (
+		object_id int4 NOT NULL,
+		hashtag_id int4 NOT NULL,
+		CONSTRAINT object_hashtags_pkey PRIMARY KEY (object_id, hashtag_id)
+	);
+
+	ALTER TABLE public.object_hashtags DROP CONSTRAINT IF EXISTS object_hashtags_object_id_fk;
+	ALTER TABLE public.object_hashtags ADD CONSTRAINT object_hashtags_object_id_fk FOREIGN KEY (object_id) REFERENCES public.objects(id) ON DELETE CASCADE;
+	ALTER TABLE public.object_hashtags DROP CONSTRAINT IF EXISTS object_hashtags_hashtag_id_fk;
+	ALTER TABLE public.object_hashtags ADD CONSTRAINT object_hashtags_hashtag_id_fk FOREIGN KEY (hashtag_id) REFERENCES public.hashtags(id) ON DELETE CASCADE;
+	CREATE INDEX IF NOT EXISTS object_hashtags_hashtag_id_idx ON public.object_hashtags (hashtag_id, object_id);
+
+	-- public.followed_hashtags definition
+
+	CREATE TABLE IF NOT EXISTS public.followed_hashtags (
+		user_id int4 NOT NULL,
+		hashtag_id int4 NOT NULL,
+		created timestamptz NOT NULL DEFAULT now(),
+		CONSTRAINT followed_hashtags_pkey PRIMARY KEY (user_id, hashtag_id)
+	);
+
+	ALTER TABLE public.followed_hashtags DROP CONSTRAINT IF EXISTS followed_hashtags_user_id_fk;
+	ALTER TABLE public.followed_hashtags ADD CONSTRAINT followed_hashtags_user_id_fk FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
+	ALTER TABLE public.followed_hashtags DROP CONSTRAINT IF EXISTS followed_hashtags_hashtag_id_fk;
+	ALTER TABLE public.followed_hashtags ADD CONSTRAINT followed_hashtags_hashtag_id_fk FOREIGN KEY (hashtag_id) REFERENCES public.hashtags(id) ON DELETE CASCADE;
+
+	-- public.proxied_media definition
+
+	CREATE TABLE IF NOT EXISTS public.proxied_media (
+		id serial NOT NULL,
+		url text NOT NULL,
+		"name" text NOT NULL,
+		media_type text NOT NULL,
+		"size" int8 NOT NULL,
+		created timestamptz NOT NULL DEFAULT now(),
+		CONSTRAINT proxied_media_pkey PRIMARY KEY (id),
+		CONSTRAINT proxied_media_url_key UNIQUE (url)
+	);
+
+	-- public.pending_media definition
+
+	CREATE TABLE IF NOT EXISTS public.pending_media (
+		id serial NOT NULL,
+		uuid text NOT NULL,
+		user_id int4 NOT NULL,
+		attachment jsonb NOT NULL,
+		title text NOT NULL DEFAULT '',
+		artist text NOT NULL DEFAULT '',
+		created timestamptz NOT NULL DEFAULT now(),
+		expires timestamptz NOT NULL,
+		CONSTRAINT pending_media_pkey PRIMARY KEY (id),
+		CONSTRAINT pending_media_uuid_key UNIQUE (uuid)
+	);
+
+	ALTER TABLE public.pending_media DROP CONSTRAINT IF EXISTS pending_media_user_id_fk;
+	ALTER TABLE public.pending_media ADD CONSTRAINT pending_media_user_id_fk FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
+
+	-- public.chunked_uploads definition
+
+	CREATE TABLE IF NOT EXISTS public.chunked_uploads (
+		id serial NOT NULL,
+		uuid text NOT NULL,
+		user_id int4 NOT NULL,
+		"name" text NOT NULL DEFAULT '',
+		media_type text NOT NULL DEFAULT '',
+		length int8 NOT NULL,
+		"offset" int8 NOT NULL DEFAULT 0,
+		parts text[] NOT NULL DEFAULT '{}',
+		created timestamptz NOT NULL DEFAULT now(),
+		expires timestamptz NOT NULL,
+		CONSTRAINT chunked_uploads_pkey PRIMARY KEY (id),
+		CONSTRAINT chunked_uploads_uuid_key UNIQUE (uuid)
+	);
+
+	ALTER TABLE public.chunked_uploads DROP CONSTRAINT IF EXISTS chunked_uploads_user_id_fk;
+	ALTER TABLE public.chunked_uploads ADD CONSTRAINT chunked_uploads_user_id_fk FOREIGN KEY (user_id) REFERENCES public.users(id) ON DELETE CASCADE;
+
 END
 $$
 
@@ -2,12 +2,14 @@ package activitypub
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"net/url"
 	"strings"
 
 	"github.com/cheebz/arb"
+	"github.com/cheebz/go-pub/pkg/models"
 )
 
 var Accept = "application/activity+json"
@@ -28,6 +30,11 @@ var ContentTypeHeaders = http.Header{
 
 var UploadContentType = "multipart/form-data"
 
+var Public = "https://www.w3.org/ns/activitystreams#Public"
+
+// ErrNotFound is returned when a remote server has no such document
+var ErrNotFound = errors.New("not found")
+
 func CheckContentType(headers http.Header) error {
 	h := headers.Values("Content-Type")
 	for _, v := range h {
@@ -150,6 +157,32 @@ func Find(iri string, headers http.Header) (arb.Arb, error) {
 	return arb, nil
 }
 
+// FindWithClient fetches a document with client, failing with ErrNotFound when the server
+// answers 404 or 410 and with an error for any other unsuccessful status
+func FindWithClient(client *http.Client, iri string, headers http.Header) (arb.Arb, error) {
+	req, err := http.NewRequest("GET", iri, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, l := range headers {
+		for _, v := range l {
+			req.Header.Add(k, v)
+		}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return nil, fmt.Errorf("%s: %w", iri, ErrNotFound)
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("received status code %d from %s", resp.StatusCode, iri)
+	}
+	return arb.Read(resp.Body)
+}
+
 func FindProp(a arb.Arb, prop string, headers http.Header) (arb.Arb, error) {
 	iri, err := a.GetURL(prop)
 	if err != nil {
@@ -311,6 +344,53 @@ func GetRecipients(a arb.Arb, prop string) ([]*url.URL, error) {
 	return urls, nil
 }
 
+// IsAddressedTo checks if iri is a recipient of an activity or its object
+func IsAddressedTo(a arb.Arb, iri string) bool {
+	targets := []arb.Arb{a}
+	if object, err := a.GetArb("object"); err == nil {
+		targets = append(targets, object)
+	}
+	for _, target := range targets {
+		for _, prop := range Audiences {
+			if recipient, err := target.GetString(prop); err == nil && recipient == iri {
+				return true
+			}
+			recipients, err := target.GetArray(prop)
+			if err != nil {
+				continue
+			}
+			for _, recipient := range recipients {
+				if s, ok := recipient.(string); ok && s == iri {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// IsPublic checks if an activity or its object is addressed to the public collection
+func IsPublic(a arb.Arb) bool {
+	return IsAddressedTo(a, Public) || IsAddressedTo(a, "as:Public") || IsAddressedTo(a, "Public")
+}
+
+// IsAlsoKnownAs checks if an actor lists iri in its alsoKnownAs property
+func IsAlsoKnownAs(actor arb.Arb, iri string) bool {
+	if aka, err := actor.GetString("alsoKnownAs"); err == nil {
+		return aka == iri
+	}
+	akas, err := actor.GetArray("alsoKnownAs")
+	if err != nil {
+		return false
+	}
+	for _, aka := range akas {
+		if s, ok := aka.(string); ok && s == iri {
+			return true
+		}
+	}
+	return false
+}
+
 func FetchPublicKeyString(keyId string) (string, error) {
 	client := http.DefaultClient
 	req, err := http.NewRequest("GET", keyId, nil)
@@ -337,3 +417,134 @@ func FetchPublicKeyString(keyId string) (string, error) {
 	}
 	return publicKeyString, nil
 }
+
+// IsMentioned checks if an object carries a Mention tag pointing at iri
+func IsMentioned(object arb.Arb, iri string) bool {
+	for _, tag := range getTags(object) {
+		if tag["type"] == "Mention" && tag["href"] == iri {
+			return true
+		}
+	}
+	return false
+}
+
+// GetHashtags returns the lower case names (without #) of an object's Hashtag tags
+func GetHashtags(object arb.Arb) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, tag := range getTags(object) {
+		name, ok := tag["name"].(string)
+		if tag["type"] != "Hashtag" || !ok {
+			continue
+		}
+		name = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(name), "#"))
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// GetAttachments reads the media attached to a remote object, taking the first http(s) link of each
+func GetAttachments(object arb.Arb) []models.Attachment {
+	var attachments []models.Attachment
+	for _, item := range getArbs(object, "attachment") {
+		attachment := models.Attachment{}
+		attachment.Type, _ = item["type"].(string)
+		attachment.MediaType, _ = item["mediaType"].(string)
+		attachment.Name, _ = item["name"].(string)
+		attachment.Blurhash, _ = item["blurhash"].(string)
+		if width, ok := item["width"].(float64); ok {
+			attachment.Width = int(width)
+		}
+		if height, ok := item["height"].(float64); ok {
+			attachment.Height = int(height)
+		}
+		for _, link := range getLinks(item["url"]) {
+			u, err := url.Parse(link["href"])
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+				continue
+			}
+			attachment.Url = link["href"]
+			if attachment.MediaType == "" {
+				attachment.MediaType = link["mediaType"]
+			}
+			break
+		}
+		if attachment.Url == "" {
+			continue
+		}
+		if attachment.Type == "" {
+			attachment.Type = "Document"
+		}
+		attachments = append(attachments, attachment)
+	}
+	return attachments
+}
+
+// getLinks reads a url property, which may be a string, a Link or an array of either
+func getLinks(prop interface{}) []map[string]string {
+	var links []map[string]string
+	items, ok := prop.([]interface{})
+	if !ok {
+		items = []interface{}{prop}
+	}
+	for _, item := range items {
+		switch v := item.(type) {
+		case string:
+			links = append(links, map[string]string{"href": v})
+		case map[string]interface{}:
+			href, _ := v["href"].(string)
+			mediaType, _ := v["mediaType"].(string)
+			links = append(links, map[string]string{"href": href, "mediaType": mediaType})
+		}
+	}
+	return links
+}
+
+func getTags(object arb.Arb) []map[string]interface{} {
+	return getArbs(object, "tag")
+}
+
+// getArbs reads a property holding an object or an array of objects
+func getArbs(object arb.Arb, prop string) []map[string]interface{} {
+	items, err := object.GetArray(prop)
+	if err != nil {
+		if item, err := object.GetArb(prop); err == nil {
+			items = []interface{}{map[string]interface{}(item)}
+		}
+	}
+	var result []map[string]interface{}
+	for _, item := range items {
+		switch v := item.(type) {
+		case map[string]interface{}:
+			result = append(result, v)
+		case arb.Arb:
+			result = append(result, v)
+		}
+	}
+	return result
+}
+
+// AddRecipient appends iri to an audience property unless it is already there
+func AddRecipient(a arb.Arb, prop string, iri string) {
+	var recipients []interface{}
+	switch v := a[prop].(type) {
+	case []interface{}:
+		recipients = v
+	case []string:
+		for _, s := range v {
+			recipients = append(recipients, s)
+		}
+	case string:
+		recipients = []interface{}{v}
+	}
+	for _, recipient := range recipients {
+		if s, ok := recipient.(string); ok && s == iri {
+			a[prop] = recipients
+			return
+		}
+	}
+	a[prop] = append(recipients, iri)
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"sync/atomic"
 
 	"github.com/cheebz/go-pub/pkg/config"
 	"github.com/cheebz/go-pub/pkg/models"
@@ -15,19 +16,42 @@ import (
 )
 
 type Federator struct {
-	conf config.Configuration
-	repo repositories.Repository
+	conf  config.Configuration
+	repo  repositories.Repository
+	stats *models.FederationStats
 }
 
 func NewFederator(_conf config.Configuration, _repo repositories.Repository) Federator {
 	return Federator{
-		conf: _conf,
-		repo: _repo,
+		conf:  _conf,
+		repo:  _repo,
+		stats: &models.FederationStats{},
+	}
+}
+
+// Stats returns a snapshot of the delivery counters
+func (f *Federator) Stats() models.FederationStats {
+	return models.FederationStats{
+		InFlight:  atomic.LoadInt64(&f.stats.InFlight),
+		Delivered: atomic.LoadInt64(&f.stats.Delivered),
+		Failed:    atomic.LoadInt64(&f.stats.Failed),
+		Blocked:   atomic.LoadInt64(&f.stats.Blocked),
+		Halted:    atomic.LoadInt64(&f.stats.Halted),
 	}
 }
 
 func (f *Federator) Federate(fed models.Federation) {
 	log.Println(fmt.Sprintf("Federating to %s", fed.Recipient))
+	if recipientURL, err := url.Parse(fed.Recipient); err == nil && f.repo.IsDomainBlocked(recipientURL.Hostname()) {
+		log.Println(fmt.Sprintf("%s is on a blocked domain", fed.Recipient))
+		atomic.AddInt64(&f.stats.Blocked, 1)
+		return
+	}
+	if user, err := f.repo.QueryUserByName(fed.Name); err == nil && user.Suspended {
+		log.Println(fmt.Sprintf("%s is suspended, halting delivery", fed.Name))
+		atomic.AddInt64(&f.stats.Halted, 1)
+		return
+	}
 	recipient, err := Find(fed.Recipient, AcceptHeaders)
 	if err != nil {
 		log.Println(err)
@@ -41,7 +65,7 @@ func (f *Federator) Federate(fed models.Federation) {
 	log.Println(fmt.Sprintf("%s is of type %s", fed.Recipient, recipientType))
 
 	switch recipientType {
-	case "Person", "Service":
+	case "Person", "Service", "Group", "Application", "Organization":
 		activityIRI, err := GetIRI(fed.Activity)
 		if err != nil {
 			log.Println(err)
@@ -111,9 +135,12 @@ func (f *Federator) Federate(fed models.Federation) {
 }
 
 func (f *Federator) post(fed models.Federation, inbox string) {
+	atomic.AddInt64(&f.stats.InFlight, 1)
+	defer atomic.AddInt64(&f.stats.InFlight, -1)
 	req, err := http.NewRequest("POST", inbox, bytes.NewBuffer(fed.Activity.ToBytes()))
 	if err != nil {
 		log.Println(err)
+		atomic.AddInt64(&f.stats.Failed, 1)
 		return
 	}
 	req.Header.Add("Content-Type", ContentType)
@@ -122,6 +149,7 @@ func (f *Federator) post(fed models.Federation, inbox string) {
 	err = sigs.SignRequest(req, fed.Activity.ToBytes(), f.conf.RSAPrivateKey, keyID)
 	if err != nil {
 		log.Println(err)
+		atomic.AddInt64(&f.stats.Failed, 1)
 		return
 	}
 
@@ -131,9 +159,15 @@ func (f *Federator) post(fed models.Federation, inbox string) {
 	response, err := client.Do(req)
 	if err != nil {
 		log.Println(err)
+		atomic.AddInt64(&f.stats.Failed, 1)
 		return
 	}
 	defer response.Body.Close()
+	if response.StatusCode >= 200 && response.StatusCode < 300 {
+		atomic.AddInt64(&f.stats.Delivered, 1)
+	} else {
+		atomic.AddInt64(&f.stats.Failed, 1)
+	}
 
 	log.Println(fmt.Sprintf("%s code: %s", req.URL.Hostname()+req.URL.RequestURI(), response.Status))
 	body, err := ioutil.ReadAll(response.Body)
@@ -0,0 +1,130 @@
+package activitypub
+
+import (
+	"fmt"
+	"html"
+	"regexp"
+	"strings"
+)
+
+var (
+	mentionRegexp = regexp.MustCompile(`(^|[^[:alnum:]_/@.])@([[:alnum:]_]+)(?:@([[:alnum:]-]+(?:\.[[:alnum:]-]+)+(?::[0-9]+)?|localhost(?::[0-9]+)?))?`)
+	hashtagRegexp = regexp.MustCompile(`(^|[^\p{L}\p{N}_&/#])#([\p{L}\p{N}_]*[\p{L}_][\p{L}\p{N}_]*)`)
+	markupRegexp  = regexp.MustCompile(`<[^>]*>`)
+	tagNameRegexp = regexp.MustCompile(`^[\p{L}\p{N}_]*[\p{L}_][\p{L}\p{N}_]*$`)
+)
+
+// Handle is an @user or @user@host mention
+type Handle struct {
+	User string
+	Host string
+}
+
+func (h Handle) String() string {
+	if h.Host == "" {
+		return "@" + h.User
+	}
+	return fmt.Sprintf("@%s@%s", h.User, h.Host)
+}
+
+// ParseHandle reads user@host, @user@host or acct:user@host
+func ParseHandle(s string) (Handle, error) {
+	s = strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(s), "acct:"), "@")
+	parts := strings.Split(s, "@")
+	if len(parts) > 2 || parts[0] == "" || (len(parts) == 2 && parts[1] == "") {
+		return Handle{}, fmt.Errorf("invalid handle %s", s)
+	}
+	handle := Handle{User: parts[0]}
+	if len(parts) == 2 {
+		handle.Host = strings.ToLower(parts[1])
+	}
+	return handle, nil
+}
+
+// FindMentions returns the distinct handles mentioned in content, ignoring markup and existing links
+func FindMentions(content string) []Handle {
+	var handles []Handle
+	seen := make(map[Handle]bool)
+	eachText(content, func(text string) string {
+		for _, m := range mentionRegexp.FindAllStringSubmatch(text, -1) {
+			handle := Handle{User: m[2], Host: strings.ToLower(m[3])}
+			if !seen[handle] {
+				seen[handle] = true
+				handles = append(handles, handle)
+			}
+		}
+		return text
+	})
+	return handles
+}
+
+// FindHashtags returns the distinct hashtags (without #) in content, ignoring markup and existing links
+func FindHashtags(content string) []string {
+	var tags []string
+	seen := make(map[string]bool)
+	eachText(content, func(text string) string {
+		for _, m := range hashtagRegexp.FindAllStringSubmatch(text, -1) {
+			if !seen[strings.ToLower(m[2])] {
+				seen[strings.ToLower(m[2])] = true
+				tags = append(tags, m[2])
+			}
+		}
+		return text
+	})
+	return tags
+}
+
+// IsHashtagName checks if name (without #) is a valid hashtag
+func IsHashtagName(name string) bool {
+	return tagNameRegexp.MatchString(name)
+}
+
+// LinkTags links the mentions and hashtags (keyed in lower case) found in content to their hrefs
+func LinkTags(content string, mentions map[Handle]string, hashtags map[string]string) string {
+	return eachText(content, func(text string) string {
+		text = mentionRegexp.ReplaceAllStringFunc(text, func(match string) string {
+			m := mentionRegexp.FindStringSubmatch(match)
+			href, ok := mentions[Handle{User: m[2], Host: strings.ToLower(m[3])}]
+			if !ok {
+				return match
+			}
+			return fmt.Sprintf(`%s<span class="h-card"><a href="%s" class="u-url mention">@<span>%s</span></a></span>`, m[1], html.EscapeString(href), m[2])
+		})
+		return hashtagRegexp.ReplaceAllStringFunc(text, func(match string) string {
+			m := hashtagRegexp.FindStringSubmatch(match)
+			href, ok := hashtags[strings.ToLower(m[2])]
+			if !ok {
+				return match
+			}
+			return fmt.Sprintf(`%s<a href="%s" class="mention hashtag" rel="tag">#<span>%s</span></a>`, m[1], html.EscapeString(href), m[2])
+		})
+	})
+}
+
+// eachText rewrites the text between markup, leaving the content of existing links alone
+func eachText(content string, fn func(text string) string) string {
+	var b strings.Builder
+	inLink := false
+	last := 0
+	for _, loc := range markupRegexp.FindAllStringIndex(content, -1) {
+		if !inLink {
+			b.WriteString(fn(content[last:loc[0]]))
+		} else {
+			b.WriteString(content[last:loc[0]])
+		}
+		tag := strings.ToLower(content[loc[0]:loc[1]])
+		if strings.HasPrefix(tag, "<a ") || tag == "<a>" {
+			inLink = true
+		} else if strings.HasPrefix(tag, "</a") {
+			inLink = false
+		}
+		b.WriteString(content[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	if !inLink {
+		b.WriteString(fn(content[last:]))
+	} else {
+		b.WriteString(content[last:])
+	}
+	return b.String()
+}
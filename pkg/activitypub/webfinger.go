@@ -0,0 +1,155 @@
+package activitypub
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cheebz/go-pub/pkg/cache"
+	"github.com/cheebz/go-pub/pkg/config"
+	"github.com/cheebz/go-pub/pkg/media"
+	"github.com/cheebz/go-pub/pkg/models"
+)
+
+// WebFingerClient resolves acct: handles to actor IRIs
+type WebFingerClient struct {
+	conf   config.Configuration
+	cache  cache.Cache
+	client *http.Client
+}
+
+// hostMeta is an XRD or JRD host-meta document (see: https://datatracker.ietf.org/doc/html/rfc6415)
+type hostMeta struct {
+	Links []struct {
+		Rel      string `xml:"rel,attr" json:"rel"`
+		Template string `xml:"template,attr" json:"template"`
+	} `xml:"Link" json:"links"`
+}
+
+func NewWebFingerClient(_conf config.Configuration, _cache cache.Cache) WebFingerClient {
+	return WebFingerClient{
+		conf:   _conf,
+		cache:  _cache,
+		client: newWebFingerHTTPClient(),
+	}
+}
+
+// newWebFingerHTTPClient only connects to public addresses, since handles come from users
+func newWebFingerHTTPClient() *http.Client {
+	client := media.NewProxyClient()
+	client.Timeout = 10 * time.Second
+	return client
+}
+
+// Lookup resolves a remote handle to its actor IRI, trying the host's well-known WebFinger
+// endpoint first and then the LRDD template advertised in its host-meta
+func (c *WebFingerClient) Lookup(handle Handle) (string, error) {
+	if handle.Host == "" {
+		return "", errors.New("handle has no host")
+	}
+	resource := fmt.Sprintf("acct:%s@%s", handle.User, handle.Host)
+	var iri string
+	_, err := c.cache.Get(fmt.Sprintf("webfinger-%s", resource), &iri)
+	if err == nil && iri != "" {
+		return iri, nil
+	}
+	log.Println(fmt.Sprintf("no cached %s", fmt.Sprintf("webfinger-%s", resource)))
+
+	webfinger, err := c.get(fmt.Sprintf("https://%s/.well-known/webfinger?resource=%s", handle.Host, url.QueryEscape(resource)))
+	if err != nil {
+		template, templateErr := c.lrddTemplate(handle.Host)
+		if templateErr != nil {
+			return "", err
+		}
+		webfinger, err = c.get(strings.Replace(template, "{uri}", url.QueryEscape(resource), -1))
+		if err != nil {
+			return "", err
+		}
+	}
+	for _, link := range webfinger.Links {
+		if link.Rel == "self" && (link.Type == Accept || IsActivityPubMediaType(link.Type)) {
+			iri = link.Href
+			break
+		}
+	}
+	if iri == "" {
+		return "", fmt.Errorf("no actor for %s: %w", handle, ErrNotFound)
+	}
+
+	err = c.cache.Set(fmt.Sprintf("webfinger-%s", resource), iri)
+	if err != nil {
+		log.Println(fmt.Sprintf("error setting cache %s", fmt.Sprintf("webfinger-%s", resource)))
+	}
+	return iri, nil
+}
+
+func (c *WebFingerClient) get(endpoint string) (models.WebFinger, error) {
+	var webfinger models.WebFinger
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return webfinger, err
+	}
+	req.Header.Set("Accept", "application/jrd+json, application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return webfinger, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		return webfinger, fmt.Errorf("%s: %w", endpoint, ErrNotFound)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return webfinger, fmt.Errorf("received status code %d from %s", resp.StatusCode, endpoint)
+	}
+	err = json.NewDecoder(resp.Body).Decode(&webfinger)
+	return webfinger, err
+}
+
+// lrddTemplate reads the WebFinger location a host advertises in its host-meta
+func (c *WebFingerClient) lrddTemplate(host string) (string, error) {
+	endpoint := fmt.Sprintf("https://%s/.well-known/host-meta", host)
+	req, err := http.NewRequest("GET", endpoint, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/xrd+xml, application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("received status code %d from %s", resp.StatusCode, endpoint)
+	}
+	var meta hostMeta
+	if strings.Contains(resp.Header.Get("Content-Type"), "json") {
+		err = json.NewDecoder(resp.Body).Decode(&meta)
+	} else {
+		err = xml.NewDecoder(resp.Body).Decode(&meta)
+	}
+	if err != nil {
+		return "", err
+	}
+	for _, link := range meta.Links {
+		if link.Rel == "lrdd" && strings.Contains(link.Template, "{uri}") {
+			return link.Template, nil
+		}
+	}
+	return "", fmt.Errorf("no lrdd template in %s", endpoint)
+}
+
+// IsActivityPubMediaType checks for either of the ActivityPub media types
+func IsActivityPubMediaType(mediaType string) bool {
+	for _, item := range AcceptHeaders["Accept"] {
+		if mediaType == item {
+			return true
+		}
+	}
+	return false
+}
@@ -55,7 +55,13 @@ func (c *RedisCache) Get(key string, pointer interface{}) (interface{}, error) {
 }
 
 func (c *RedisCache) Del(patterns ...string) error {
+	// plain keys are deleted together, only patterns need a KEYS lookup
+	var exact []string
 	for _, pattern := range patterns {
+		if !strings.ContainsAny(pattern, "*?[") {
+			exact = append(exact, pattern)
+			continue
+		}
 		keys, err := c.client.Keys(pattern).Result()
 		if err != nil {
 			return err
@@ -67,6 +73,12 @@ func (c *RedisCache) Del(patterns ...string) error {
 			}
 		}
 	}
+	if len(exact) > 0 {
+		_, err := c.client.Del(exact...).Result()
+		if err != nil {
+			return err
+		}
+	}
 	log.Println(fmt.Sprintf("deleted cached %s", strings.Join(patterns, " ")))
 	return nil
 }
@@ -12,43 +12,78 @@ import (
 
 var (
 	defaults = map[string]interface{}{
-		"DEBUG":                 true,
-		"PORT":                  80,
-		"LOG_FILE":              "",
-		"SERVER_NAME":           "localhost",
-		"PROTOCOL":              "http",
-		"AUTH":                  "http://localhost:8080/auth/",
-		"CLIENT":                "http://localhost:3000",
-		"ENDPOINT_USERS":        "users",
-		"ENDPOINT_ACTIVITIES":   "activities",
-		"ENDPOINT_OBJECTS":      "objects",
-		"ENDPOINT_FEED":         "feed",
-		"ENDPOINT_INBOX":        "inbox",
-		"ENDPOINT_OUTBOX":       "outbox",
-		"ENDPOINT_FOLLOWING":    "following",
-		"ENDPOINT_FOLLOWERS":    "followers",
-		"ENDPOINT_LIKED":        "liked",
-		"ENDPOINT_UPLOAD_MEDIA": "uploadMedia",
-		"ENDPOINT_UPLOADS":      "uploads",
-		"ENDPOINT_LINKS":        "links",
-		"ENDPOINT_CHECK":        "check",
-		"UPLOAD_DIR":            "./uploads/",
-		"SSL_CERT":              "",
-		"SSL_KEY":               "",
-		"DB_HOST":               "host",
-		"DB_PORT":               5432,
-		"DB_NAME":               "database",
-		"DB_USER":               "user",
-		"DB_PASSWORD":           "password",
-		"RSA_PUBLIC_KEY":        "public.pem",
-		"RSA_PRIVATE_KEY":       "private.pem",
-		"REDIS_HOST":            "localhost",
-		"REDIS_PORT":            6379,
-		"REDIS_PASSWORD":        "",
-		"REDIS_DB":              0,
-		"REDIS_EXP_SECONDS":     3600,
-		"ALLOWED_ORIGINS":       "",
-		"PAGE_LENGTH":           10,
+		"DEBUG":                  true,
+		"PORT":                   80,
+		"LOG_FILE":               "",
+		"SERVER_NAME":            "localhost",
+		"PROTOCOL":               "http",
+		"AUTH":                   "http://localhost:8080/auth/",
+		"CLIENT":                 "http://localhost:3000",
+		"ENDPOINT_USERS":         "users",
+		"ENDPOINT_ACTIVITIES":    "activities",
+		"ENDPOINT_OBJECTS":       "objects",
+		"ENDPOINT_FEED":          "feed",
+		"ENDPOINT_INBOX":         "inbox",
+		"ENDPOINT_OUTBOX":        "outbox",
+		"ENDPOINT_FOLLOWING":     "following",
+		"ENDPOINT_FOLLOWERS":     "followers",
+		"ENDPOINT_LIKED":         "liked",
+		"ENDPOINT_UPLOAD_MEDIA":  "uploadMedia",
+		"ENDPOINT_UPLOADS":       "uploads",
+		"ENDPOINT_LINKS":         "links",
+		"ENDPOINT_CHECK":         "check",
+		"ENDPOINT_ADMIN":         "admin",
+		"ENDPOINT_ALIASES":       "aliases",
+		"ENDPOINT_PROFILE":       "profile",
+		"ENDPOINT_FIELDS":        "fields",
+		"ENDPOINT_STREAM":        "stream",
+		"ENDPOINT_WEBSOCKET":     "websocket",
+		"ENDPOINT_NOTIFICATIONS": "notifications",
+		"ENDPOINT_TAGS":          "tags",
+		"ENDPOINT_FOLLOWED_TAGS": "followed_tags",
+		"ENDPOINT_SEARCH":        "search",
+		"ENDPOINT_RESOLVE":       "resolve",
+		"ENDPOINT_PROXY":         "proxy",
+		"ENDPOINT_MEDIA":         "media",
+		"UPLOAD_DIR":             "./uploads/",
+		"MEDIA_TYPES":            "image/png:10,image/jpeg:10,image/gif:10,image/webp:10,video/mp4:40,video/webm:40,audio/mpeg:15,audio/ogg:15,audio/flac:40,audio/wav:40,audio/mp4:15,audio/aac:15,application/pdf:15",
+		"MAX_ATTACHMENTS":        4,
+		"STORAGE":                "local",
+		"MEDIA_URL":              "",
+		"S3_ENDPOINT":            "https://s3.amazonaws.com",
+		"S3_REGION":              "us-east-1",
+		"S3_BUCKET":              "",
+		"S3_ACCESS_KEY":          "",
+		"S3_SECRET_KEY":          "",
+		"S3_PATH_STYLE":          true,
+		"S3_PRESIGN":             false,
+		"S3_PRESIGN_SECONDS":     3600,
+		"MEDIA_PROXY":            true,
+		"FILE_PURGE_GRACE_HOURS": 24,
+		"MEDIA_TTL_HOURS":        24,
+		"SSL_CERT":               "",
+		"SSL_KEY":                "",
+		"DB_HOST":                "host",
+		"DB_PORT":                5432,
+		"DB_NAME":                "database",
+		"DB_USER":                "user",
+		"DB_PASSWORD":            "password",
+		"RSA_PUBLIC_KEY":         "public.pem",
+		"RSA_PRIVATE_KEY":        "private.pem",
+		"REDIS_HOST":             "localhost",
+		"REDIS_PORT":             6379,
+		"REDIS_PASSWORD":         "",
+		"REDIS_DB":               0,
+		"REDIS_EXP_SECONDS":      3600,
+		"ALLOWED_ORIGINS":        "",
+		"PAGE_LENGTH":            10,
+		"MAX_PAGE_LENGTH":        50,
+		"EMBED_FIRST_PAGE":       false,
+		"ADMINS":                 "",
+		"ADMIN_CLAIM":            "admin",
+		"STREAM_FAN_OUT":         false,
+		"STREAM_BUFFER":          32,
+		"STREAM_HEARTBEAT":       30,
 	}
 	configPaths = []string{
 		".",
@@ -59,41 +94,67 @@ var (
 
 // Configuration struct
 type Configuration struct {
-	Debug          bool        `mapstructure:"DEBUG"`
-	Port           int         `mapstructure:"PORT"`
-	LogFile        string      `mapstructure:"LOG_FILE"`
-	Protocol       string      `mapstructure:"PROTOCOL"`
-	ServerName     string      `mapstructure:"SERVER_NAME"`
-	Auth           string      `mapstructure:"AUTH"`
-	Client         string      `mapstructure:"CLIENT"`
-	Endpoints      Endpoints   `mapstructure:",squash"`
-	UploadDir      string      `mapstructure:"UPLOAD_DIR"`
-	SSLCert        string      `mapstructure:"SSL_CERT"`
-	SSLKey         string      `mapstructure:"SSL_KEY"`
-	Db             DataSource  `mapstructure:",squash"`
-	JWTKey         string      `mapstructure:"JWT_KEY"`
-	RSAPublicKey   string      `mapstructure:"RSA_PUBLIC_KEY"`
-	RSAPrivateKey  string      `mapstructure:"RSA_PRIVATE_KEY"`
-	Redis          RedisConfig `mapstructure:",squash"`
-	AllowedOrigins string      `mapstructure:"ALLOWED_ORIGINS"`
-	PageLength     int         `mapstructure:"PAGE_LENGTH"`
+	Debug           bool        `mapstructure:"DEBUG"`
+	Port            int         `mapstructure:"PORT"`
+	LogFile         string      `mapstructure:"LOG_FILE"`
+	Protocol        string      `mapstructure:"PROTOCOL"`
+	ServerName      string      `mapstructure:"SERVER_NAME"`
+	Auth            string      `mapstructure:"AUTH"`
+	Client          string      `mapstructure:"CLIENT"`
+	Endpoints       Endpoints   `mapstructure:",squash"`
+	UploadDir       string      `mapstructure:"UPLOAD_DIR"`
+	MediaTypes      string      `mapstructure:"MEDIA_TYPES"`
+	MaxAttachments  int         `mapstructure:"MAX_ATTACHMENTS"`
+	Storage         Storage     `mapstructure:",squash"`
+	MediaProxy      bool        `mapstructure:"MEDIA_PROXY"`
+	FilePurgeGrace  int         `mapstructure:"FILE_PURGE_GRACE_HOURS"`
+	MediaTTL        int         `mapstructure:"MEDIA_TTL_HOURS"`
+	SSLCert         string      `mapstructure:"SSL_CERT"`
+	SSLKey          string      `mapstructure:"SSL_KEY"`
+	Db              DataSource  `mapstructure:",squash"`
+	JWTKey          string      `mapstructure:"JWT_KEY"`
+	RSAPublicKey    string      `mapstructure:"RSA_PUBLIC_KEY"`
+	RSAPrivateKey   string      `mapstructure:"RSA_PRIVATE_KEY"`
+	Redis           RedisConfig `mapstructure:",squash"`
+	AllowedOrigins  string      `mapstructure:"ALLOWED_ORIGINS"`
+	PageLength      int         `mapstructure:"PAGE_LENGTH"`
+	MaxPageLength   int         `mapstructure:"MAX_PAGE_LENGTH"`
+	EmbedFirstPage  bool        `mapstructure:"EMBED_FIRST_PAGE"`
+	Admins          string      `mapstructure:"ADMINS"`
+	AdminClaim      string      `mapstructure:"ADMIN_CLAIM"`
+	StreamFanOut    bool        `mapstructure:"STREAM_FAN_OUT"`
+	StreamBuffer    int         `mapstructure:"STREAM_BUFFER"`
+	StreamHeartbeat int         `mapstructure:"STREAM_HEARTBEAT"`
 }
 
 // DataSource struct
 type Endpoints struct {
-	Users       string `mapstructure:"ENDPOINT_USERS"`
-	Activities  string `mapstructure:"ENDPOINT_ACTIVITIES"`
-	Objects     string `mapstructure:"ENDPOINT_OBJECTS"`
-	Feed        string `mapstructure:"ENDPOINT_FEED"`
-	Inbox       string `mapstructure:"ENDPOINT_INBOX"`
-	Outbox      string `mapstructure:"ENDPOINT_OUTBOX"`
-	Following   string `mapstructure:"ENDPOINT_FOLLOWING"`
-	Followers   string `mapstructure:"ENDPOINT_FOLLOWERS"`
-	Liked       string `mapstructure:"ENDPOINT_LIKED"`
-	UploadMedia string `mapstructure:"ENDPOINT_UPLOAD_MEDIA"`
-	Uploads     string `mapstructure:"ENDPOINT_UPLOADS"`
-	Links       string `mapstructure:"ENDPOINT_LINKS"`
-	Check       string `mapstructure:"ENDPOINT_CHECK"`
+	Users         string `mapstructure:"ENDPOINT_USERS"`
+	Activities    string `mapstructure:"ENDPOINT_ACTIVITIES"`
+	Objects       string `mapstructure:"ENDPOINT_OBJECTS"`
+	Feed          string `mapstructure:"ENDPOINT_FEED"`
+	Inbox         string `mapstructure:"ENDPOINT_INBOX"`
+	Outbox        string `mapstructure:"ENDPOINT_OUTBOX"`
+	Following     string `mapstructure:"ENDPOINT_FOLLOWING"`
+	Followers     string `mapstructure:"ENDPOINT_FOLLOWERS"`
+	Liked         string `mapstructure:"ENDPOINT_LIKED"`
+	UploadMedia   string `mapstructure:"ENDPOINT_UPLOAD_MEDIA"`
+	Uploads       string `mapstructure:"ENDPOINT_UPLOADS"`
+	Links         string `mapstructure:"ENDPOINT_LINKS"`
+	Check         string `mapstructure:"ENDPOINT_CHECK"`
+	Admin         string `mapstructure:"ENDPOINT_ADMIN"`
+	Aliases       string `mapstructure:"ENDPOINT_ALIASES"`
+	Profile       string `mapstructure:"ENDPOINT_PROFILE"`
+	Fields        string `mapstructure:"ENDPOINT_FIELDS"`
+	Stream        string `mapstructure:"ENDPOINT_STREAM"`
+	WebSocket     string `mapstructure:"ENDPOINT_WEBSOCKET"`
+	Notifications string `mapstructure:"ENDPOINT_NOTIFICATIONS"`
+	Tags          string `mapstructure:"ENDPOINT_TAGS"`
+	FollowedTags  string `mapstructure:"ENDPOINT_FOLLOWED_TAGS"`
+	Search        string `mapstructure:"ENDPOINT_SEARCH"`
+	Resolve       string `mapstructure:"ENDPOINT_RESOLVE"`
+	Proxy         string `mapstructure:"ENDPOINT_PROXY"`
+	Media         string `mapstructure:"ENDPOINT_MEDIA"`
 }
 
 // DataSource struct
@@ -105,6 +166,20 @@ type DataSource struct {
 	Password string `mapstructure:"DB_PASSWORD"`
 }
 
+// Storage struct (where uploads are kept and how they are served)
+type Storage struct {
+	Backend          string `mapstructure:"STORAGE"`
+	MediaURL         string `mapstructure:"MEDIA_URL"`
+	S3Endpoint       string `mapstructure:"S3_ENDPOINT"`
+	S3Region         string `mapstructure:"S3_REGION"`
+	S3Bucket         string `mapstructure:"S3_BUCKET"`
+	S3AccessKey      string `mapstructure:"S3_ACCESS_KEY"`
+	S3SecretKey      string `mapstructure:"S3_SECRET_KEY"`
+	S3PathStyle      bool   `mapstructure:"S3_PATH_STYLE"`
+	S3Presign        bool   `mapstructure:"S3_PRESIGN"`
+	S3PresignSeconds int    `mapstructure:"S3_PRESIGN_SECONDS"`
+}
+
 type RedisConfig struct {
 	Host            string `mapstructure:"REDIS_HOST"`
 	Port            int    `mapstructure:"REDIS_PORT"`
@@ -19,6 +19,37 @@ type Handler interface {
 	GetObject(w http.ResponseWriter, r *http.Request)
 	PostInbox(w http.ResponseWriter, r *http.Request)
 	PostOutbox(w http.ResponseWriter, r *http.Request)
+	GetStream(w http.ResponseWriter, r *http.Request)
+	GetWebSocket(w http.ResponseWriter, r *http.Request)
+	GetNotifications(w http.ResponseWriter, r *http.Request)
+	MarkNotificationsRead(w http.ResponseWriter, r *http.Request)
+	Resolve(w http.ResponseWriter, r *http.Request)
+	Search(w http.ResponseWriter, r *http.Request)
+	GetHashtag(w http.ResponseWriter, r *http.Request)
+	GetFollowedHashtags(w http.ResponseWriter, r *http.Request)
+	FollowHashtag(w http.ResponseWriter, r *http.Request)
+	UnfollowHashtag(w http.ResponseWriter, r *http.Request)
 	UploadMedia(w http.ResponseWriter, r *http.Request)
+	UploadPendingMedia(w http.ResponseWriter, r *http.Request)
+	CreateChunkedUpload(w http.ResponseWriter, r *http.Request)
+	GetChunkedUpload(w http.ResponseWriter, r *http.Request)
+	WriteChunk(w http.ResponseWriter, r *http.Request)
+	DeleteChunkedUpload(w http.ResponseWriter, r *http.Request)
+	GetUpload(w http.ResponseWriter, r *http.Request)
+	ProxyMedia(w http.ResponseWriter, r *http.Request)
+	SetAlsoKnownAs(w http.ResponseWriter, r *http.Request)
+	UpdateProfile(w http.ResponseWriter, r *http.Request)
+	SetProfileFields(w http.ResponseWriter, r *http.Request)
+	GetUsers(w http.ResponseWriter, r *http.Request)
+	CreateActor(w http.ResponseWriter, r *http.Request)
+	SetActorType(w http.ResponseWriter, r *http.Request)
+	SuspendUser(w http.ResponseWriter, r *http.Request)
+	UnsuspendUser(w http.ResponseWriter, r *http.Request)
+	ToggleUserDiscoverable(w http.ResponseWriter, r *http.Request)
+	DeleteUser(w http.ResponseWriter, r *http.Request)
+	GetFederationStats(w http.ResponseWriter, r *http.Request)
+	GetDomainBlocks(w http.ResponseWriter, r *http.Request)
+	BlockDomain(w http.ResponseWriter, r *http.Request)
+	UnblockDomain(w http.ResponseWriter, r *http.Request)
 	SinkHandler(w http.ResponseWriter, r *http.Request)
 }
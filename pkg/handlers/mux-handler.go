@@ -2,15 +2,22 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
+	"net/url"
+	"os"
 	"runtime/pprof"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/cheebz/go-pub/pkg/activitypub"
 	"github.com/cheebz/go-pub/pkg/config"
 	"github.com/cheebz/go-pub/pkg/media"
 	"github.com/cheebz/go-pub/pkg/middleware"
+	"github.com/cheebz/go-pub/pkg/models"
 	"github.com/cheebz/go-pub/pkg/resources"
 	"github.com/cheebz/go-pub/pkg/responses"
 	"github.com/cheebz/go-pub/pkg/services"
@@ -23,20 +30,30 @@ type MuxHandler struct {
 	conf       config.Configuration
 	middleware middleware.Middleware
 	service    services.Service
+	storage    media.Storage
 	resource   resources.Resource
 	response   responses.Response
 	router     *mux.Router
 }
 
 var (
-	nameParam = "name"
+	nameParam   = "name"
+	domainParam = "domain"
+	tagParam    = "tag"
+	fileParam   = "file"
+	sigParam    = "signature"
+	uploadParam = "upload"
 )
 
-func NewMuxHandler(_config config.Configuration, _middleware middleware.Middleware, _service services.Service, _resource resources.Resource, _response responses.Response) Handler {
+// maxReplayPages caps how many pages of missed stream items are replayed on reconnect
+const maxReplayPages = 10
+
+func NewMuxHandler(_config config.Configuration, _middleware middleware.Middleware, _service services.Service, _storage media.Storage, _resource resources.Resource, _response responses.Response) Handler {
 	h := &MuxHandler{
 		conf:       _config,
 		middleware: _middleware,
 		service:    _service,
+		storage:    _storage,
 		resource:   _resource,
 		response:   _response,
 		router:     mux.NewRouter(),
@@ -63,6 +80,7 @@ func (h *MuxHandler) setupRoutes() {
 	// TODO: These should have some level of auth since some activities/objects are private
 	get.HandleFunc(fmt.Sprintf("/%s/{id}", h.conf.Endpoints.Activities), h.GetActivity).Methods("GET", "OPTIONS")
 	get.HandleFunc(fmt.Sprintf("/%s/{id}", h.conf.Endpoints.Objects), h.GetObject).Methods("GET", "OPTIONS")
+	get.HandleFunc(fmt.Sprintf("/%s/{%s}", h.conf.Endpoints.Tags, tagParam), h.GetHashtag).Methods("GET", "OPTIONS")
 
 	post := h.router.NewRoute().Subrouter() // -> public POST requests
 	post.Use(h.middleware.ContentTypeMiddleware, userMiddleware)
@@ -79,17 +97,71 @@ func (h *MuxHandler) setupRoutes() {
 	aPost.Use(jwtUsernameMiddleware)
 	aPost.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}/%s", h.conf.Endpoints.Users, nameParam, h.conf.Endpoints.Outbox), h.PostOutbox).Methods("POST", "OPTIONS")
 
+	pPost := h.router.NewRoute().Subrouter() // -> authenticated profile POST
+	pPost.Use(jwtUsernameMiddleware)
+	pPost.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}/%s", h.conf.Endpoints.Users, nameParam, h.conf.Endpoints.Aliases), h.SetAlsoKnownAs).Methods("POST", "OPTIONS")
+	pPost.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}/%s", h.conf.Endpoints.Users, nameParam, h.conf.Endpoints.Profile), h.UpdateProfile).Methods("POST", "OPTIONS")
+	pPost.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}/%s", h.conf.Endpoints.Users, nameParam, h.conf.Endpoints.Fields), h.SetProfileFields).Methods("POST", "OPTIONS")
+	pPost.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}/%s/read", h.conf.Endpoints.Users, nameParam, h.conf.Endpoints.Notifications), h.MarkNotificationsRead).Methods("POST", "OPTIONS")
+	pPost.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}/%s", h.conf.Endpoints.Users, nameParam, h.conf.Endpoints.FollowedTags), h.FollowHashtag).Methods("POST", "OPTIONS")
+
+	aDelete := h.router.NewRoute().Subrouter() // -> authenticated DELETE requests
+	aDelete.Use(jwtUsernameMiddleware)
+	aDelete.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}", h.conf.Endpoints.Users, nameParam), h.DeleteUser).Methods("DELETE", "OPTIONS")
+	aDelete.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}/%s/{%s}", h.conf.Endpoints.Users, nameParam, h.conf.Endpoints.FollowedTags, tagParam), h.UnfollowHashtag).Methods("DELETE", "OPTIONS")
+
 	uPost := h.router.NewRoute().Subrouter() // -> authenticated uploads POST
 	uPost.Use(jwtUsernameMiddleware)
 	uPost.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}/%s", h.conf.Endpoints.Users, nameParam, h.conf.Endpoints.UploadMedia), h.UploadMedia).Methods("POST", "OPTIONS")
+	uPost.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}/%s", h.conf.Endpoints.Users, nameParam, h.conf.Endpoints.Media), h.UploadPendingMedia).Methods("POST", "OPTIONS")
+	uPost.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}/%s/uploads", h.conf.Endpoints.Users, nameParam, h.conf.Endpoints.Media), h.CreateChunkedUpload).Methods("POST", "OPTIONS")
+
+	cUpload := h.router.NewRoute().Subrouter() // -> authenticated chunked uploads
+	cUpload.Use(jwtUsernameMiddleware)
+	cUpload.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}/%s/uploads/{%s}", h.conf.Endpoints.Users, nameParam, h.conf.Endpoints.Media, uploadParam), h.GetChunkedUpload).Methods("GET", "HEAD", "OPTIONS")
+	cUpload.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}/%s/uploads/{%s}", h.conf.Endpoints.Users, nameParam, h.conf.Endpoints.Media, uploadParam), h.WriteChunk).Methods("PATCH", "OPTIONS")
+	cUpload.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}/%s/uploads/{%s}", h.conf.Endpoints.Users, nameParam, h.conf.Endpoints.Media, uploadParam), h.DeleteChunkedUpload).Methods("DELETE", "OPTIONS")
 
 	uGet := h.router.NewRoute().Subrouter() // -> authenticated uploads GET
-	uGet.PathPrefix(fmt.Sprintf("/%s/", h.conf.Endpoints.Uploads)).Handler(http.StripPrefix(fmt.Sprintf("/%s/", h.conf.Endpoints.Uploads), http.FileServer(http.Dir(h.conf.UploadDir))))
+	uGet.HandleFunc(fmt.Sprintf("/%s/{%s}", h.conf.Endpoints.Uploads, fileParam), h.GetUpload).Methods("GET", "HEAD", "OPTIONS")
+	uGet.HandleFunc(fmt.Sprintf("/%s/{%s:[[:xdigit:]]+}", h.conf.Endpoints.Proxy, sigParam), h.ProxyMedia).Methods("GET", "HEAD", "OPTIONS")
+
+	sGet := h.router.NewRoute().Subrouter() // -> authenticated streaming GET
+	sGet.Use(jwtUsernameMiddleware)
+	sGet.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}/%s", h.conf.Endpoints.Users, nameParam, h.conf.Endpoints.Stream), h.GetStream).Methods("GET", "OPTIONS")
+	sGet.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}/%s", h.conf.Endpoints.Users, nameParam, h.conf.Endpoints.WebSocket), h.GetWebSocket).Methods("GET")
+
+	nGet := h.router.NewRoute().Subrouter() // -> authenticated notifications GET
+	nGet.Use(jwtUsernameMiddleware)
+	nGet.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}/%s", h.conf.Endpoints.Users, nameParam, h.conf.Endpoints.Notifications), h.GetNotifications).Methods("GET", "OPTIONS")
+
+	qGet := h.router.NewRoute().Subrouter() // -> authenticated search and lookup GET
+	qGet.Use(jwtUsernameMiddleware)
+	qGet.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}/%s", h.conf.Endpoints.Users, nameParam, h.conf.Endpoints.Search), h.Search).Methods("GET", "OPTIONS")
+	qGet.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}/%s", h.conf.Endpoints.Users, nameParam, h.conf.Endpoints.Resolve), h.Resolve).Methods("GET", "OPTIONS")
+
+	tGet := h.router.NewRoute().Subrouter() // -> authenticated followed hashtags GET
+	tGet.Use(jwtUsernameMiddleware)
+	tGet.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}/%s", h.conf.Endpoints.Users, nameParam, h.conf.Endpoints.FollowedTags), h.GetFollowedHashtags).Methods("GET", "OPTIONS")
 
 	cGet := h.router.NewRoute().Subrouter() // -> authenticated checks GET
 	uPost.Use(jwtUsernameMiddleware)
 	cGet.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}/%s", h.conf.Endpoints.Users, nameParam, h.conf.Endpoints.Check), h.CheckActivity).Methods("GET", "OPTIONS")
 
+	admin := h.router.PathPrefix(fmt.Sprintf("/%s", h.conf.Endpoints.Admin)).Subrouter() // -> admin requests
+	admin.Use(h.middleware.CreateAdminMiddleware(strings.Split(h.conf.Admins, ","), h.conf.AdminClaim))
+	admin.HandleFunc(fmt.Sprintf("/%s", h.conf.Endpoints.Users), h.GetUsers).Methods("GET", "OPTIONS")
+	admin.HandleFunc(fmt.Sprintf("/%s", h.conf.Endpoints.Users), h.CreateActor).Methods("POST", "OPTIONS")
+	admin.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}", h.conf.Endpoints.Users, nameParam), h.DeleteUser).Methods("DELETE", "OPTIONS")
+	admin.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}/suspend", h.conf.Endpoints.Users, nameParam), h.SuspendUser).Methods("POST", "OPTIONS")
+	admin.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}/unsuspend", h.conf.Endpoints.Users, nameParam), h.UnsuspendUser).Methods("POST", "OPTIONS")
+	admin.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}/discoverable", h.conf.Endpoints.Users, nameParam), h.ToggleUserDiscoverable).Methods("POST", "OPTIONS")
+	admin.HandleFunc(fmt.Sprintf("/%s/{%s:[[:alnum:]]+}/type", h.conf.Endpoints.Users, nameParam), h.SetActorType).Methods("POST", "OPTIONS")
+	admin.HandleFunc("/federation", h.GetFederationStats).Methods("GET", "OPTIONS")
+	admin.HandleFunc("/domainBlocks", h.GetDomainBlocks).Methods("GET", "OPTIONS")
+	admin.HandleFunc("/domainBlocks", h.BlockDomain).Methods("POST", "OPTIONS")
+	admin.HandleFunc(fmt.Sprintf("/domainBlocks/{%s}", domainParam), h.UnblockDomain).Methods("DELETE", "OPTIONS")
+
 	mon := h.router.NewRoute().Subrouter() // -> monitoring
 	mon.HandleFunc("/monitoring/goroutines", h.GetGoroutines).Methods("GET", "OPTIONS")
 
@@ -134,78 +206,91 @@ func (h *MuxHandler) GetWebFinger(w http.ResponseWriter, r *http.Request) {
 
 func (h *MuxHandler) GetUser(w http.ResponseWriter, r *http.Request) {
 	name := mux.Vars(r)[nameParam]
-	user, err := h.service.GetUserByName(name)
+	user, err := h.service.GetProfileByName(name)
+	if errors.Is(err, services.ErrUserDeleted) {
+		tombstone := h.resource.GenerateTombstone(user, *user.Deleted)
+		// served with 200 so remote servers can still fetch the key that signed the Delete
+		w.Header().Set("Content-Type", activitypub.ContentType)
+		json.NewEncoder(w).Encode(tombstone)
+		return
+	}
 	if err != nil {
 		h.response.NotFound(w, err)
 		return
 	}
-	actor := h.resource.GenerateActor(user.Name)
+	actor := h.resource.GenerateActor(user)
 	w.Header().Set("Content-Type", activitypub.ContentType)
 	json.NewEncoder(w).Encode(actor)
 }
 
 func (h *MuxHandler) GetFeed(w http.ResponseWriter, r *http.Request) {
 	name := mux.Vars(r)[nameParam]
-	page := r.FormValue("page")
-	if page == "" {
+	page, err := h.parsePage(r)
+	if err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	if page == nil {
 		totalItems, err := h.service.GetFeedTotalItemsByUserName(name)
 		if err != nil {
 			h.response.InternalServerError(w, err)
 			return
 		}
 		feed := h.resource.GenerateOrderedCollection(name, h.conf.Endpoints.Feed, totalItems)
+		if h.conf.EmbedFirstPage && totalItems > 0 {
+			first, ids, err := h.service.GetFeedByUserName(name, models.Page{Keyset: true})
+			if err != nil {
+				h.response.InternalServerError(w, err)
+				return
+			}
+			feed.First = h.resource.GenerateOrderedCollectionPage(name, h.conf.Endpoints.Feed, activityItems(first), ids, models.Page{Keyset: true})
+		}
 		w.Header().Set("Content-Type", activitypub.ContentType)
 		json.NewEncoder(w).Encode(feed)
 		return
 	}
-	pageNum, err := strconv.Atoi(page)
-	if err != nil {
-		h.response.BadRequest(w, err)
-		return
-	}
-	activities, err := h.service.GetFeedByUserName(name, pageNum)
+	activities, ids, err := h.service.GetFeedByUserName(name, *page)
 	if err != nil {
 		h.response.InternalServerError(w, err)
 		return
 	}
-	orderedItems := make([]interface{}, len(activities))
-	for i, activity := range activities {
-		orderedItems[i] = activity
-	}
-	feedPage := h.resource.GenerateOrderedCollectionPage(name, h.conf.Endpoints.Feed, orderedItems, pageNum)
+	feedPage := h.resource.GenerateOrderedCollectionPage(name, h.conf.Endpoints.Feed, activityItems(activities), ids, *page)
 	w.Header().Set("Content-Type", activitypub.ContentType)
 	json.NewEncoder(w).Encode(feedPage)
 }
 
 func (h *MuxHandler) GetInbox(w http.ResponseWriter, r *http.Request) {
 	name := mux.Vars(r)[nameParam]
-	page := r.FormValue("page")
-	if page == "" {
+	page, err := h.parsePage(r)
+	if err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	if page == nil {
 		totalItems, err := h.service.GetInboxTotalItemsByUserName(name)
 		if err != nil {
 			h.response.InternalServerError(w, err)
 			return
 		}
 		inbox := h.resource.GenerateOrderedCollection(name, h.conf.Endpoints.Inbox, totalItems)
+		if h.conf.EmbedFirstPage && totalItems > 0 {
+			first, ids, err := h.service.GetInboxByUserName(name, models.Page{Keyset: true})
+			if err != nil {
+				h.response.InternalServerError(w, err)
+				return
+			}
+			inbox.First = h.resource.GenerateOrderedCollectionPage(name, h.conf.Endpoints.Inbox, activityItems(first), ids, models.Page{Keyset: true})
+		}
 		w.Header().Set("Content-Type", activitypub.ContentType)
 		json.NewEncoder(w).Encode(inbox)
 		return
 	}
-	pageNum, err := strconv.Atoi(page)
-	if err != nil {
-		h.response.BadRequest(w, err)
-		return
-	}
-	activities, err := h.service.GetInboxByUserName(name, pageNum)
+	activities, ids, err := h.service.GetInboxByUserName(name, *page)
 	if err != nil {
 		h.response.InternalServerError(w, err)
 		return
 	}
-	orderedItems := make([]interface{}, len(activities))
-	for i, activity := range activities {
-		orderedItems[i] = activity
-	}
-	inboxPage := h.resource.GenerateOrderedCollectionPage(name, h.conf.Endpoints.Inbox, orderedItems, pageNum)
+	inboxPage := h.resource.GenerateOrderedCollectionPage(name, h.conf.Endpoints.Inbox, activityItems(activities), ids, *page)
 	w.Header().Set("Content-Type", activitypub.ContentType)
 	json.NewEncoder(w).Encode(inboxPage)
 }
@@ -217,33 +302,36 @@ func (h *MuxHandler) GetOutbox(w http.ResponseWriter, r *http.Request) {
 		h.response.NotFound(w, err)
 		return
 	}
-	page := r.FormValue("page")
-	if page == "" {
+	page, err := h.parsePage(r)
+	if err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	if page == nil {
 		totalItems, err := h.service.GetOutboxTotalItemsByUserName(user.Name)
 		if err != nil {
 			h.response.InternalServerError(w, err)
 			return
 		}
 		outbox := h.resource.GenerateOrderedCollection(user.Name, h.conf.Endpoints.Outbox, totalItems)
+		if h.conf.EmbedFirstPage && totalItems > 0 {
+			first, ids, err := h.service.GetOutboxByUserName(user.Name, models.Page{Keyset: true})
+			if err != nil {
+				h.response.InternalServerError(w, err)
+				return
+			}
+			outbox.First = h.resource.GenerateOrderedCollectionPage(user.Name, h.conf.Endpoints.Outbox, activityItems(first), ids, models.Page{Keyset: true})
+		}
 		w.Header().Set("Content-Type", activitypub.ContentType)
 		json.NewEncoder(w).Encode(outbox)
 		return
 	}
-	pageNum, err := strconv.Atoi(page)
-	if err != nil {
-		h.response.BadRequest(w, err)
-		return
-	}
-	activities, err := h.service.GetOutboxByUserName(user.Name, pageNum)
+	activities, ids, err := h.service.GetOutboxByUserName(user.Name, *page)
 	if err != nil {
 		h.response.InternalServerError(w, err)
 		return
 	}
-	orderedItems := make([]interface{}, len(activities))
-	for i, activity := range activities {
-		orderedItems[i] = activity
-	}
-	outboxPage := h.resource.GenerateOrderedCollectionPage(name, h.conf.Endpoints.Outbox, orderedItems, pageNum)
+	outboxPage := h.resource.GenerateOrderedCollectionPage(name, h.conf.Endpoints.Outbox, activityItems(activities), ids, *page)
 	w.Header().Set("Content-Type", activitypub.ContentType)
 	json.NewEncoder(w).Encode(outboxPage)
 }
@@ -255,33 +343,36 @@ func (h *MuxHandler) GetFollowing(w http.ResponseWriter, r *http.Request) {
 		h.response.NotFound(w, err)
 		return
 	}
-	page := r.FormValue("page")
-	if page == "" {
+	page, err := h.parsePage(r)
+	if err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	if page == nil {
 		totalItems, err := h.service.GetFollowingTotalItemsByUserName(user.Name)
 		if err != nil {
 			h.response.InternalServerError(w, err)
 			return
 		}
 		following := h.resource.GenerateOrderedCollection(user.Name, h.conf.Endpoints.Following, totalItems)
+		if h.conf.EmbedFirstPage && totalItems > 0 {
+			first, ids, err := h.service.GetFollowingByUserName(user.Name, models.Page{Keyset: true})
+			if err != nil {
+				h.response.InternalServerError(w, err)
+				return
+			}
+			following.First = h.resource.GenerateOrderedCollectionPage(user.Name, h.conf.Endpoints.Following, iriItems(first), ids, models.Page{Keyset: true})
+		}
 		w.Header().Set("Content-Type", activitypub.ContentType)
 		json.NewEncoder(w).Encode(following)
 		return
 	}
-	pageNum, err := strconv.Atoi(page)
-	if err != nil {
-		h.response.BadRequest(w, err)
-		return
-	}
-	following, err := h.service.GetFollowingByUserName(user.Name, pageNum)
+	following, ids, err := h.service.GetFollowingByUserName(user.Name, *page)
 	if err != nil {
 		h.response.InternalServerError(w, err)
 		return
 	}
-	orderedItems := make([]interface{}, len(following))
-	for i, actor := range following {
-		orderedItems[i] = actor
-	}
-	followingPage := h.resource.GenerateOrderedCollectionPage(user.Name, h.conf.Endpoints.Following, orderedItems, pageNum)
+	followingPage := h.resource.GenerateOrderedCollectionPage(user.Name, h.conf.Endpoints.Following, iriItems(following), ids, *page)
 	w.Header().Set("Content-Type", activitypub.ContentType)
 	json.NewEncoder(w).Encode(followingPage)
 }
@@ -293,33 +384,36 @@ func (h *MuxHandler) GetFollowers(w http.ResponseWriter, r *http.Request) {
 		h.response.NotFound(w, err)
 		return
 	}
-	page := r.FormValue("page")
-	if page == "" {
+	page, err := h.parsePage(r)
+	if err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	if page == nil {
 		totalItems, err := h.service.GetFollowersTotalItemsByUserName(user.Name)
 		if err != nil {
 			h.response.InternalServerError(w, err)
 			return
 		}
 		followers := h.resource.GenerateOrderedCollection(user.Name, h.conf.Endpoints.Followers, totalItems)
+		if h.conf.EmbedFirstPage && totalItems > 0 {
+			first, ids, err := h.service.GetFollowersByUserName(user.Name, models.Page{Keyset: true})
+			if err != nil {
+				h.response.InternalServerError(w, err)
+				return
+			}
+			followers.First = h.resource.GenerateOrderedCollectionPage(user.Name, h.conf.Endpoints.Followers, iriItems(first), ids, models.Page{Keyset: true})
+		}
 		w.Header().Set("Content-Type", activitypub.ContentType)
 		json.NewEncoder(w).Encode(followers)
 		return
 	}
-	pageNum, err := strconv.Atoi(page)
-	if err != nil {
-		h.response.BadRequest(w, err)
-		return
-	}
-	followers, err := h.service.GetFollowersByUserName(user.Name, pageNum)
+	followers, ids, err := h.service.GetFollowersByUserName(user.Name, *page)
 	if err != nil {
 		h.response.InternalServerError(w, err)
 		return
 	}
-	orderedItems := make([]interface{}, len(followers))
-	for i, actor := range followers {
-		orderedItems[i] = actor
-	}
-	followersPage := h.resource.GenerateOrderedCollectionPage(user.Name, h.conf.Endpoints.Followers, orderedItems, pageNum)
+	followersPage := h.resource.GenerateOrderedCollectionPage(user.Name, h.conf.Endpoints.Followers, iriItems(followers), ids, *page)
 	w.Header().Set("Content-Type", activitypub.ContentType)
 	json.NewEncoder(w).Encode(followersPage)
 }
@@ -331,33 +425,36 @@ func (h *MuxHandler) GetLiked(w http.ResponseWriter, r *http.Request) {
 		h.response.NotFound(w, err)
 		return
 	}
-	page := r.FormValue("page")
-	if page == "" {
+	page, err := h.parsePage(r)
+	if err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	if page == nil {
 		totalItems, err := h.service.GetLikedTotalItemsByUserName(user.Name)
 		if err != nil {
 			h.response.InternalServerError(w, err)
 			return
 		}
 		liked := h.resource.GenerateOrderedCollection(user.Name, h.conf.Endpoints.Liked, totalItems)
+		if h.conf.EmbedFirstPage && totalItems > 0 {
+			first, ids, err := h.service.GetLikedByUserName(user.Name, models.Page{Keyset: true})
+			if err != nil {
+				h.response.InternalServerError(w, err)
+				return
+			}
+			liked.First = h.resource.GenerateOrderedCollectionPage(user.Name, h.conf.Endpoints.Liked, iriItems(first), ids, models.Page{Keyset: true})
+		}
 		w.Header().Set("Content-Type", activitypub.ContentType)
 		json.NewEncoder(w).Encode(liked)
 		return
 	}
-	pageNum, err := strconv.Atoi(page)
-	if err != nil {
-		h.response.BadRequest(w, err)
-		return
-	}
-	liked, err := h.service.GetLikedByUserName(user.Name, pageNum)
+	liked, ids, err := h.service.GetLikedByUserName(user.Name, *page)
 	if err != nil {
 		h.response.InternalServerError(w, err)
 		return
 	}
-	orderedItems := make([]interface{}, len(liked))
-	for i, activity := range liked {
-		orderedItems[i] = activity
-	}
-	likedPage := h.resource.GenerateOrderedCollectionPage(user.Name, h.conf.Endpoints.Liked, orderedItems, pageNum)
+	likedPage := h.resource.GenerateOrderedCollectionPage(user.Name, h.conf.Endpoints.Liked, iriItems(liked), ids, *page)
 	w.Header().Set("Content-Type", activitypub.ContentType)
 	json.NewEncoder(w).Encode(likedPage)
 }
@@ -446,32 +543,411 @@ func (h *MuxHandler) PostOutbox(w http.ResponseWriter, r *http.Request) {
 	activityArb.Write(w)
 }
 
-func (h *MuxHandler) UploadMedia(w http.ResponseWriter, r *http.Request) {
+// GetStream pushes activities delivered to the user's inbox as Server-Sent Events,
+// filtered to the home feed unless ?stream=inbox is requested, or the public objects
+// tagged with a hashtag for ?stream=tag:<name>
+func (h *MuxHandler) GetStream(w http.ResponseWriter, r *http.Request) {
 	name := mux.Vars(r)[nameParam]
-	err := activitypub.CheckUploadContentType(r.Header)
+	stream := r.FormValue("stream")
+	if stream == "" {
+		stream = h.conf.Endpoints.Feed
+	}
+	if !h.isStream(stream) {
+		h.response.BadRequest(w, fmt.Errorf("unknown stream %s", stream))
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.response.InternalServerError(w, errors.New("streaming is not supported"))
+		return
+	}
+	events, unsubscribe, err := h.service.Subscribe(name)
+	if err != nil {
+		h.response.NotFound(w, err)
+		return
+	}
+	if _, ok := hashtagStream(stream); ok {
+		unsubscribe()
+		events, unsubscribe = h.service.SubscribeHashtags()
+	}
+	defer unsubscribe()
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	// replay what was missed since the client's last event
+	if lastID, err := strconv.Atoi(r.Header.Get("Last-Event-ID")); err == nil && lastID > 0 {
+		missed, ids, gap, err := h.replayStream(name, stream, lastID)
+		if err != nil {
+			log.Println(fmt.Sprintf("error replaying %s stream for %s: %s", stream, name, err))
+		}
+		for i, item := range missed {
+			writeEvent(w, ids[i], stream, item)
+		}
+		if gap {
+			// too much was missed to replay, the client should refetch the collection from since
+			writeEvent(w, ids[len(ids)-1], "gap", models.StreamMessage{Type: "gap", Stream: stream, Since: ids[len(ids)-1]})
+		}
+	}
+	flusher.Flush()
+
+	var heartbeat <-chan time.Time
+	if h.conf.StreamHeartbeat > 0 {
+		ticker := time.NewTicker(time.Duration(h.conf.StreamHeartbeat) * time.Second)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-heartbeat:
+			fmt.Fprint(w, ": heartbeat\n\n")
+			flusher.Flush()
+		case event, ok := <-events:
+			if !ok {
+				return
+			}
+			payload, ok := h.streamPayload(stream, event)
+			if !ok {
+				continue
+			}
+			writeEvent(w, event.ID, stream, payload)
+			flusher.Flush()
+		}
+	}
+}
+
+func (h *MuxHandler) isStream(stream string) bool {
+	if _, ok := hashtagStream(stream); ok {
+		return true
+	}
+	return stream == h.conf.Endpoints.Feed || stream == h.conf.Endpoints.Inbox || stream == h.conf.Endpoints.Notifications
+}
+
+// hashtagStream returns the hashtag followed by a tag:<name> stream
+func hashtagStream(stream string) (string, bool) {
+	if !strings.HasPrefix(stream, "tag:") {
+		return "", false
+	}
+	tag := strings.ToLower(strings.TrimPrefix(stream, "tag:"))
+	return tag, activitypub.IsHashtagName(tag)
+}
+
+// streamPayload picks out what a published event carries for the stream, if anything
+func (h *MuxHandler) streamPayload(stream string, event models.StreamEvent) (interface{}, bool) {
+	switch stream {
+	case h.conf.Endpoints.Feed:
+		if event.Activity == nil || !event.Feed {
+			return nil, false
+		}
+		return h.service.ProxyActivity(event.Activity), true
+	case h.conf.Endpoints.Inbox:
+		if event.Activity == nil || event.FeedOnly {
+			return nil, false
+		}
+		return h.service.ProxyActivity(event.Activity), true
+	case h.conf.Endpoints.Notifications:
+		return event.Notification, event.Notification != nil
+	}
+	if tag, ok := hashtagStream(stream); ok && event.Object != nil {
+		for _, name := range event.Tags {
+			if name == tag {
+				return h.service.ProxyObject(event.Object), true
+			}
+		}
+	}
+	return nil, false
+}
+
+// replayStream returns what was published to the stream after the given id, oldest first,
+// reading at most maxReplayPages pages. gap is set when more was missed than that, and the
+// client should refetch the collection from the last id returned.
+func (h *MuxHandler) replayStream(name string, stream string, since int) (items []interface{}, ids []int, gap bool, err error) {
+	length := h.conf.MaxPageLength
+	for n := 0; ; n++ {
+		page := models.Page{Keyset: true, MinID: since, HasMinID: true, Limit: length}
+		pageItems, pageIDs, err := h.replayPage(name, stream, page)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		// min_id pages come newest first with one row past the page when there is more
+		full := len(pageItems) > length
+		if full {
+			pageItems, pageIDs = pageItems[1:], pageIDs[1:]
+		}
+		for i := len(pageItems) - 1; i >= 0; i-- {
+			items = append(items, pageItems[i])
+			ids = append(ids, pageIDs[i])
+		}
+		if !full {
+			return items, ids, false, nil
+		}
+		if n+1 >= maxReplayPages {
+			return items, ids, true, nil
+		}
+		since = ids[len(ids)-1]
+	}
+}
+
+// replayPage reads a page of the stream's collection
+func (h *MuxHandler) replayPage(name string, stream string, page models.Page) ([]interface{}, []int, error) {
+	switch stream {
+	case h.conf.Endpoints.Feed:
+		activities, ids, err := h.service.GetFeedByUserName(name, page)
+		return activityItems(activities), ids, err
+	case h.conf.Endpoints.Inbox:
+		activities, ids, err := h.service.GetInboxByUserName(name, page)
+		return activityItems(activities), ids, err
+	case h.conf.Endpoints.Notifications:
+		notifications, ids, err := h.service.GetNotificationsByUserName(name, page)
+		return notificationItems(notifications), ids, err
+	}
+	if tag, ok := hashtagStream(stream); ok {
+		objects, ids, err := h.service.GetObjectsByHashtag(tag, page)
+		return objectItems(objects), ids, err
+	}
+	return nil, nil, nil
+}
+
+func (h *MuxHandler) GetNotifications(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)[nameParam]
+	page, err := h.parsePage(r)
+	if err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	if page == nil {
+		totalItems, err := h.service.GetNotificationsTotalItemsByUserName(name)
+		if err != nil {
+			h.response.InternalServerError(w, err)
+			return
+		}
+		notifications := h.resource.GenerateOrderedCollection(name, h.conf.Endpoints.Notifications, totalItems)
+		if h.conf.EmbedFirstPage && totalItems > 0 {
+			first, ids, err := h.service.GetNotificationsByUserName(name, models.Page{Keyset: true})
+			if err != nil {
+				h.response.InternalServerError(w, err)
+				return
+			}
+			notifications.First = h.resource.GenerateOrderedCollectionPage(name, h.conf.Endpoints.Notifications, notificationItems(first), ids, models.Page{Keyset: true})
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(notifications)
+		return
+	}
+	notifications, ids, err := h.service.GetNotificationsByUserName(name, *page)
+	if err != nil {
+		h.response.InternalServerError(w, err)
+		return
+	}
+	notificationsPage := h.resource.GenerateOrderedCollectionPage(name, h.conf.Endpoints.Notifications, notificationItems(notifications), ids, *page)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(notificationsPage)
+}
+
+func (h *MuxHandler) GetHashtag(w http.ResponseWriter, r *http.Request) {
+	tag := strings.ToLower(mux.Vars(r)[tagParam])
+	if !activitypub.IsHashtagName(tag) {
+		h.response.NotFound(w, fmt.Errorf("invalid hashtag %s", tag))
+		return
+	}
+	page, err := h.parsePage(r)
+	if err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	if page == nil {
+		totalItems, err := h.service.GetHashtagTotalItems(tag)
+		if err != nil {
+			h.response.InternalServerError(w, err)
+			return
+		}
+		hashtag := h.resource.GenerateHashtagCollection(tag, totalItems)
+		if h.conf.EmbedFirstPage && totalItems > 0 {
+			first, ids, err := h.service.GetObjectsByHashtag(tag, models.Page{Keyset: true})
+			if err != nil {
+				h.response.InternalServerError(w, err)
+				return
+			}
+			hashtag.First = h.resource.GenerateHashtagCollectionPage(tag, objectItems(first), ids, models.Page{Keyset: true})
+		}
+		w.Header().Set("Content-Type", activitypub.ContentType)
+		json.NewEncoder(w).Encode(hashtag)
+		return
+	}
+	objects, ids, err := h.service.GetObjectsByHashtag(tag, *page)
+	if err != nil {
+		h.response.InternalServerError(w, err)
+		return
+	}
+	hashtagPage := h.resource.GenerateHashtagCollectionPage(tag, objectItems(objects), ids, *page)
+	w.Header().Set("Content-Type", activitypub.ContentType)
+	json.NewEncoder(w).Encode(hashtagPage)
+}
+
+func (h *MuxHandler) Search(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)[nameParam]
+	query := models.SearchQuery{
+		Query:  r.FormValue("q"),
+		Author: r.FormValue("author"),
+		Type:   r.FormValue("type"),
+	}
+	filter := url.Values{}
+	for _, param := range []string{"q", "author", "type", "since", "until"} {
+		if value := r.FormValue(param); value != "" {
+			filter.Set(param, value)
+		}
+	}
+	var err error
+	query.Since, err = parseDate(r.FormValue("since"))
+	if err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	query.Until, err = parseDate(r.FormValue("until"))
+	if err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	page, err := h.parsePage(r)
+	if err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	if page == nil {
+		page = &models.Page{Keyset: true}
+	}
+	page.Filter = filter.Encode()
+	objects, ids, err := h.service.Search(name, query, *page)
 	if err != nil {
 		h.response.BadRequest(w, err)
 		return
 	}
-	if err := r.ParseMultipartForm(32 << 20); err != nil {
+	searchPage := h.resource.GenerateOrderedCollectionPage(name, h.conf.Endpoints.Search, objectItems(objects), ids, *page)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(searchPage)
+}
+
+func (h *MuxHandler) Resolve(w http.ResponseWriter, r *http.Request) {
+	query := r.FormValue("q")
+	if query == "" {
+		h.response.BadRequest(w, errors.New("a handle or iri is required"))
+		return
+	}
+	resultArb, err := h.service.Resolve(query)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidQuery), errors.Is(err, services.ErrDomainBlocked):
+			h.response.BadRequest(w, err)
+		case errors.Is(err, services.ErrNotFound):
+			h.response.NotFound(w, err)
+		case errors.Is(err, services.ErrRemoteFetch):
+			h.response.BadGateway(w, err)
+		default:
+			h.response.InternalServerError(w, err)
+		}
+		return
+	}
+	w.Header().Set("Content-Type", activitypub.ContentType)
+	json.NewEncoder(w).Encode(resultArb)
+}
+
+func (h *MuxHandler) GetFollowedHashtags(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)[nameParam]
+	hashtags, err := h.service.GetFollowedHashtags(name)
+	if err != nil {
+		h.response.InternalServerError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(hashtags)
+}
+
+func (h *MuxHandler) FollowHashtag(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)[nameParam]
+	hashtag, err := h.service.FollowHashtag(name, r.FormValue(tagParam))
+	if err != nil {
 		h.response.BadRequest(w, err)
 		return
 	}
-	activityArb, err := activitypub.ParsePayload([]byte(r.FormValue("object")))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(hashtag)
+}
+
+func (h *MuxHandler) UnfollowHashtag(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)[nameParam]
+	err := h.service.UnfollowHashtag(name, mux.Vars(r)[tagParam])
+	if err != nil {
+		h.response.NotFound(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *MuxHandler) MarkNotificationsRead(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)[nameParam]
+	err := r.ParseForm()
 	if err != nil {
 		h.response.BadRequest(w, err)
 		return
 	}
-	file, err := media.ParseMedia(r, "file")
+	var ids []int
+	for _, value := range r.Form["id"] {
+		id, err := strconv.Atoi(value)
+		if err != nil {
+			h.response.BadRequest(w, fmt.Errorf("invalid notification id %s", value))
+			return
+		}
+		ids = append(ids, id)
+	}
+	count, err := h.service.MarkNotificationsRead(name, ids)
+	if err != nil {
+		h.response.InternalServerError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int64{"read": count})
+}
+
+func (h *MuxHandler) UploadMedia(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)[nameParam]
+	err := activitypub.CheckUploadContentType(r.Header)
 	if err != nil {
 		h.response.BadRequest(w, err)
 		return
 	}
-	activityArb, err = h.service.UploadMedia(activityArb, file, name)
+	allowed, err := media.ParseAllowList(h.conf.MediaTypes)
 	if err != nil {
 		h.response.InternalServerError(w, err)
 		return
 	}
+	files, values, err := media.ReadMultipart(r, fileParam, h.conf.MaxAttachments, allowed, h.storage)
+	if err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	activityArb, err := activitypub.ParsePayload([]byte(values.Get("object")))
+	if err != nil {
+		media.Discard(files, h.storage)
+		h.response.BadRequest(w, err)
+		return
+	}
+	activityArb, err = h.service.UploadMedia(activityArb, files, name)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrNotFound):
+			h.response.NotFound(w, err)
+		case errors.Is(err, services.ErrInvalidObject):
+			h.response.BadRequest(w, err)
+		default:
+			h.response.InternalServerError(w, err)
+		}
+		return
+	}
 	w.Header().Set("Content-Type", activitypub.ContentType)
 	iri, err := activityArb.GetString("id")
 	if err != nil {
@@ -482,6 +958,268 @@ func (h *MuxHandler) UploadMedia(w http.ResponseWriter, r *http.Request) {
 	activityArb.Write(w)
 }
 
+// UploadPendingMedia stores a single file ahead of the post it will be attached to, returning
+// the id the post's attachment refers to it by
+func (h *MuxHandler) UploadPendingMedia(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)[nameParam]
+	err := activitypub.CheckUploadContentType(r.Header)
+	if err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	allowed, err := media.ParseAllowList(h.conf.MediaTypes)
+	if err != nil {
+		h.response.InternalServerError(w, err)
+		return
+	}
+	files, _, err := media.ReadMultipart(r, fileParam, 1, allowed, h.storage)
+	if err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	pending, err := h.service.UploadPendingMedia(files[0], name)
+	if err != nil {
+		if errors.Is(err, services.ErrNotFound) {
+			h.response.NotFound(w, err)
+			return
+		}
+		h.response.InternalServerError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if href, ok := pending.Attachment["url"].(string); ok {
+		h.response.Created(w, href)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(pending)
+}
+
+// CreateChunkedUpload starts an upload of Upload-Length bytes that is sent in chunks, with
+// optional alt text in the name parameter
+func (h *MuxHandler) CreateChunkedUpload(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)[nameParam]
+	length, err := strconv.ParseInt(r.Header.Get("Upload-Length"), 10, 64)
+	if err != nil {
+		h.response.BadRequest(w, fmt.Errorf("invalid Upload-Length: %s", err))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	upload, err := h.service.CreateChunkedUpload(name, length, r.Form.Get("name"))
+	if err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	h.writeUploadHeaders(w, upload)
+	w.Header().Set("Content-Type", "application/json")
+	h.response.Created(w, fmt.Sprintf("%s://%s/%s/%s/%s/uploads/%s", h.conf.Protocol, h.conf.ServerName, h.conf.Endpoints.Users, name, h.conf.Endpoints.Media, upload.ID))
+	json.NewEncoder(w).Encode(upload)
+}
+
+// GetChunkedUpload reports how much of a chunked upload has arrived, so it can be resumed
+func (h *MuxHandler) GetChunkedUpload(w http.ResponseWriter, r *http.Request) {
+	upload, err := h.service.GetChunkedUpload(mux.Vars(r)[nameParam], mux.Vars(r)[uploadParam])
+	if err != nil {
+		h.response.NotFound(w, err)
+		return
+	}
+	h.writeUploadHeaders(w, upload)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(upload)
+}
+
+// WriteChunk appends the request body to a chunked upload at Upload-Offset. Once the upload is
+// complete it responds like UploadPendingMedia.
+func (h *MuxHandler) WriteChunk(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)[nameParam]
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		h.response.BadRequest(w, fmt.Errorf("invalid Upload-Offset: %s", err))
+		return
+	}
+	upload, pending, err := h.service.WriteChunk(name, mux.Vars(r)[uploadParam], offset, r.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrUploadNotFound):
+			h.response.NotFound(w, err)
+		case errors.Is(err, services.ErrUploadOffset):
+			h.writeUploadHeaders(w, upload)
+			h.response.Conflict(w, err)
+		default:
+			h.response.BadRequest(w, err)
+		}
+		return
+	}
+	h.writeUploadHeaders(w, upload)
+	if pending == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if href, ok := pending.Attachment["url"].(string); ok {
+		h.response.Created(w, href)
+	} else {
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(pending)
+}
+
+// DeleteChunkedUpload cancels a chunked upload
+func (h *MuxHandler) DeleteChunkedUpload(w http.ResponseWriter, r *http.Request) {
+	err := h.service.DeleteChunkedUpload(mux.Vars(r)[nameParam], mux.Vars(r)[uploadParam])
+	if err != nil {
+		h.response.NotFound(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *MuxHandler) writeUploadHeaders(w http.ResponseWriter, upload models.ChunkedUpload) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(upload.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(upload.Length, 10))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+// GetUpload serves a stored file, or redirects to where the storage backend serves it
+func (h *MuxHandler) GetUpload(w http.ResponseWriter, r *http.Request) {
+	allowed, err := media.ParseAllowList(h.conf.MediaTypes)
+	if err != nil {
+		h.response.InternalServerError(w, err)
+		return
+	}
+	name := mux.Vars(r)[fileParam]
+	h.serveFile(w, r, name, media.TypeByName(name, allowed))
+}
+
+// ProxyMedia serves remote media linked through the media proxy from storage
+func (h *MuxHandler) ProxyMedia(w http.ResponseWriter, r *http.Request) {
+	proxied, err := h.service.ProxyMedia(mux.Vars(r)[sigParam], r.URL.Query().Get("url"))
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidProxyLink) {
+			h.response.NotFound(w, err)
+			return
+		}
+		h.response.BadRequest(w, err)
+		return
+	}
+	h.serveFile(w, r, proxied.Name, proxied.MediaType)
+}
+
+// serveFile serves a stored file as mediaType, or redirects to where the storage backend serves it
+func (h *MuxHandler) serveFile(w http.ResponseWriter, r *http.Request, name string, mediaType string) {
+	if url := h.storage.URL(name); url != "" {
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+	file, info, err := h.storage.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			h.response.NotFound(w, err)
+			return
+		}
+		h.response.InternalServerError(w, err)
+		return
+	}
+	defer file.Close()
+	// the type is always set, so ServeContent never guesses it from the name or the content
+	w.Header().Set("Content-Type", mediaType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if mediaType == "application/octet-stream" {
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+	http.ServeContent(w, r, name, info.Modified, file)
+}
+
+func (h *MuxHandler) SetAlsoKnownAs(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)[nameParam]
+	if err := r.ParseForm(); err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	user, err := h.service.SetAlsoKnownAs(name, r.Form["alias"])
+	if err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func (h *MuxHandler) UpdateProfile(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)[nameParam]
+	if err := activitypub.CheckUploadContentType(r.Header); err == nil {
+		if err := r.ParseMultipartForm(32 << 20); err != nil {
+			h.response.BadRequest(w, err)
+			return
+		}
+	} else if err := r.ParseForm(); err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	var displayName, summary *string
+	if values, ok := r.Form["name"]; ok && len(values) > 0 {
+		displayName = &values[0]
+	}
+	if values, ok := r.Form["summary"]; ok && len(values) > 0 {
+		summary = &values[0]
+	}
+	var icon, image *media.Media
+	if r.MultipartForm != nil {
+		if _, ok := r.MultipartForm.File["avatar"]; ok {
+			m, err := media.ParseImage(r, "avatar")
+			if err != nil {
+				h.response.BadRequest(w, err)
+				return
+			}
+			icon = &m
+		}
+		if _, ok := r.MultipartForm.File["header"]; ok {
+			m, err := media.ParseImage(r, "header")
+			if err != nil {
+				h.response.BadRequest(w, err)
+				return
+			}
+			image = &m
+		}
+	}
+	user, err := h.service.UpdateProfile(name, displayName, summary, icon, image)
+	if err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	actor := h.resource.GenerateActor(user)
+	w.Header().Set("Content-Type", activitypub.ContentType)
+	json.NewEncoder(w).Encode(actor)
+}
+
+func (h *MuxHandler) SetProfileFields(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)[nameParam]
+	if err := r.ParseForm(); err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	names := r.Form["name"]
+	values := r.Form["value"]
+	if len(names) != len(values) {
+		h.response.BadRequest(w, errors.New("each profile field requires a name and a value"))
+		return
+	}
+	fields := make([]models.ProfileField, len(names))
+	for i := range names {
+		fields[i] = models.ProfileField{Name: names[i], Value: values[i]}
+	}
+	user, err := h.service.SetProfileFields(name, fields)
+	if err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
 func (h *MuxHandler) CheckActivity(w http.ResponseWriter, r *http.Request) {
 	name := mux.Vars(r)[nameParam]
 	activityType := r.FormValue("activity")
@@ -492,6 +1230,124 @@ func (h *MuxHandler) CheckActivity(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(checkResponse)
 }
 
+func (h *MuxHandler) GetUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := h.service.GetUsers()
+	if err != nil {
+		h.response.InternalServerError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(users)
+}
+
+func (h *MuxHandler) CreateActor(w http.ResponseWriter, r *http.Request) {
+	name := r.FormValue("name")
+	actorType := r.FormValue("type")
+	if actorType == "" {
+		actorType = "Person"
+	}
+	user, err := h.service.CreateActor(name, actorType)
+	if err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	h.response.Created(w, user.IRI)
+	json.NewEncoder(w).Encode(user)
+}
+
+func (h *MuxHandler) SetActorType(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)[nameParam]
+	user, err := h.service.SetActorType(name, r.FormValue("type"))
+	if err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func (h *MuxHandler) SuspendUser(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)[nameParam]
+	user, err := h.service.SuspendUser(name)
+	if err != nil {
+		h.response.NotFound(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func (h *MuxHandler) UnsuspendUser(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)[nameParam]
+	user, err := h.service.UnsuspendUser(name)
+	if err != nil {
+		h.response.NotFound(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func (h *MuxHandler) ToggleUserDiscoverable(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)[nameParam]
+	user, err := h.service.ToggleUserDiscoverable(name)
+	if err != nil {
+		h.response.NotFound(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(user)
+}
+
+func (h *MuxHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)[nameParam]
+	err := h.service.DeleteUser(name)
+	if err != nil {
+		h.response.NotFound(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *MuxHandler) GetFederationStats(w http.ResponseWriter, r *http.Request) {
+	stats := h.service.GetFederationStats()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+func (h *MuxHandler) GetDomainBlocks(w http.ResponseWriter, r *http.Request) {
+	blocks, err := h.service.GetDomainBlocks()
+	if err != nil {
+		h.response.InternalServerError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(blocks)
+}
+
+func (h *MuxHandler) BlockDomain(w http.ResponseWriter, r *http.Request) {
+	domain := r.FormValue(domainParam)
+	block, err := h.service.BlockDomain(domain)
+	if err != nil {
+		h.response.BadRequest(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(block)
+}
+
+func (h *MuxHandler) UnblockDomain(w http.ResponseWriter, r *http.Request) {
+	domain := mux.Vars(r)[domainParam]
+	err := h.service.UnblockDomain(domain)
+	if err != nil {
+		h.response.NotFound(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
 func (h *MuxHandler) GetGoroutines(w http.ResponseWriter, r *http.Request) {
 	pprof.Lookup("goroutine").WriteTo(w, 2)
 }
@@ -499,3 +1355,106 @@ func (h *MuxHandler) GetGoroutines(w http.ResponseWriter, r *http.Request) {
 func (h *MuxHandler) SinkHandler(w http.ResponseWriter, r *http.Request) {
 	h.response.NotFound(w, fmt.Errorf("endpoint %s does not exist", r.URL))
 }
+
+// parsePage reads a page number (?page=0), the newest page (?page=true) or an activity id
+// cursor (?max_id= / ?min_id=) along with an optional ?limit= from the request, returning nil
+// when no page was requested
+func (h *MuxHandler) parsePage(r *http.Request) (*models.Page, error) {
+	var page models.Page
+	if limit := r.FormValue("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid limit %s", limit)
+		}
+		if n > h.conf.MaxPageLength {
+			n = h.conf.MaxPageLength
+		}
+		if n != h.conf.PageLength {
+			page.Limit = n
+		}
+	}
+	if minID := r.FormValue("min_id"); minID != "" {
+		id, err := strconv.Atoi(minID)
+		if err != nil || id < 0 {
+			return nil, fmt.Errorf("invalid min_id %s", minID)
+		}
+		page.Keyset, page.MinID, page.HasMinID = true, id, true
+		return &page, nil
+	}
+	if maxID := r.FormValue("max_id"); maxID != "" {
+		id, err := strconv.Atoi(maxID)
+		if err != nil || id < 1 {
+			return nil, fmt.Errorf("invalid max_id %s", maxID)
+		}
+		page.Keyset, page.MaxID = true, id
+		return &page, nil
+	}
+	num := r.FormValue("page")
+	if num == "" {
+		return nil, nil
+	}
+	if num == "true" {
+		page.Keyset = true
+		return &page, nil
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("invalid page %s", num)
+	}
+	page.Num = n
+	return &page, nil
+}
+
+func activityItems(activities []models.Activity) []interface{} {
+	orderedItems := make([]interface{}, len(activities))
+	for i, activity := range activities {
+		orderedItems[i] = activity
+	}
+	return orderedItems
+}
+
+func iriItems(iris []string) []interface{} {
+	orderedItems := make([]interface{}, len(iris))
+	for i, iri := range iris {
+		orderedItems[i] = iri
+	}
+	return orderedItems
+}
+
+func writeEvent(w http.ResponseWriter, id int, event string, data interface{}) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		log.Println(fmt.Sprintf("error encoding %s event %d: %s", event, id, err))
+		return
+	}
+	fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", id, event, payload)
+}
+
+// parseDate reads an RFC 3339 timestamp or a plain date, returning nil when empty
+func parseDate(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.Parse(layout, value); err == nil {
+			return &t, nil
+		}
+	}
+	return nil, fmt.Errorf("invalid date %s", value)
+}
+
+func objectItems(objects []models.Object) []interface{} {
+	orderedItems := make([]interface{}, len(objects))
+	for i, object := range objects {
+		orderedItems[i] = object
+	}
+	return orderedItems
+}
+
+func notificationItems(notifications []models.Notification) []interface{} {
+	orderedItems := make([]interface{}, len(notifications))
+	for i, notification := range notifications {
+		orderedItems[i] = notification
+	}
+	return orderedItems
+}
@@ -0,0 +1,297 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/cheebz/go-pub/pkg/models"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	wsWriteWait      = 10 * time.Second
+	wsMaxMessageSize = 4096
+)
+
+// wsConn is a single WebSocket client multiplexing several of a user's streams. The read loop
+// only decodes requests; the write loop owns the subscriptions and is the only writer.
+type wsConn struct {
+	h         *MuxHandler
+	name      string
+	conn      *websocket.Conn
+	requests  chan models.StreamMessage
+	replays   chan wsReplay
+	done      chan struct{}
+	closed    chan struct{}
+	streams   map[string]*wsStream
+	tagEvents <-chan models.StreamEvent
+	untag     func()
+}
+
+// wsStream is a subscribed stream, holding back live events while what was missed is replayed
+type wsStream struct {
+	replaying bool
+	since     int
+	pending   []models.StreamEvent
+}
+
+// wsReplay is what a stream missed since an id, read off the write loop
+type wsReplay struct {
+	stream string
+	since  int
+	items  []interface{}
+	ids    []int
+	gap    bool
+	err    error
+}
+
+// GetWebSocket upgrades the request to a WebSocket that pushes the streams the client
+// subscribes to with {"type":"subscribe","stream":"feed"} messages
+func (h *MuxHandler) GetWebSocket(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)[nameParam]
+	events, unsubscribe, err := h.service.Subscribe(name)
+	if err != nil {
+		h.response.NotFound(w, err)
+		return
+	}
+	defer unsubscribe()
+	upgrader := websocket.Upgrader{
+		CheckOrigin: h.checkOrigin,
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Println(fmt.Sprintf("error upgrading websocket for %s: %s", name, err))
+		return
+	}
+	c := &wsConn{
+		h:        h,
+		name:     name,
+		conn:     conn,
+		requests: make(chan models.StreamMessage),
+		replays:  make(chan wsReplay),
+		done:     make(chan struct{}),
+		closed:   make(chan struct{}),
+		streams:  make(map[string]*wsStream),
+	}
+	go c.readLoop()
+	c.writeLoop(events)
+	close(c.closed)
+	conn.Close()
+}
+
+// checkOrigin allows clients without an Origin (mobile apps), same host requests and ALLOWED_ORIGINS
+func (h *MuxHandler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range strings.Split(h.conf.AllowedOrigins, ",") {
+		if strings.TrimSpace(allowed) == origin {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *wsConn) readLoop() {
+	defer close(c.done)
+	c.conn.SetReadLimit(wsMaxMessageSize)
+	if c.h.conf.StreamHeartbeat > 0 {
+		wait := 2 * time.Duration(c.h.conf.StreamHeartbeat) * time.Second
+		c.conn.SetReadDeadline(time.Now().Add(wait))
+		c.conn.SetPongHandler(func(string) error {
+			return c.conn.SetReadDeadline(time.Now().Add(wait))
+		})
+	}
+	for {
+		var msg models.StreamMessage
+		err := c.conn.ReadJSON(&msg)
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				log.Println(fmt.Sprintf("websocket for %s closed: %s", c.name, err))
+			}
+			return
+		}
+		select {
+		case c.requests <- msg:
+		case <-c.closed:
+			return
+		}
+	}
+}
+
+func (c *wsConn) write(msg models.StreamMessage) error {
+	c.conn.SetWriteDeadline(time.Now().Add(wsWriteWait))
+	return c.conn.WriteJSON(msg)
+}
+
+// writeLoop answers requests and sends published events to the streams they belong to, the
+// user's arriving on events and the hashtag streams' on c.tagEvents while any is subscribed
+func (c *wsConn) writeLoop(events <-chan models.StreamEvent) {
+	defer c.unsubscribeHashtags()
+	var heartbeat <-chan time.Time
+	if c.h.conf.StreamHeartbeat > 0 {
+		ticker := time.NewTicker(time.Duration(c.h.conf.StreamHeartbeat) * time.Second)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+	for {
+		var err error
+		select {
+		case <-c.done:
+			return
+		case msg := <-c.requests:
+			err = c.handle(msg)
+		case replay := <-c.replays:
+			err = c.finishReplay(replay)
+		case event, ok := <-events:
+			err = c.deliver(event, ok)
+		case event, ok := <-c.tagEvents:
+			err = c.deliver(event, ok)
+		case <-heartbeat:
+			err = c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteWait))
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func (c *wsConn) handle(msg models.StreamMessage) error {
+	switch msg.Type {
+	case "subscribe":
+		if !c.h.isStream(msg.Stream) {
+			return c.write(models.StreamMessage{Type: "error", Stream: msg.Stream, Error: fmt.Sprintf("unknown stream %s", msg.Stream)})
+		}
+		if _, ok := c.streams[msg.Stream]; ok {
+			return c.write(models.StreamMessage{Type: "subscribed", Stream: msg.Stream})
+		}
+		stream := &wsStream{replaying: msg.Since > 0, since: msg.Since}
+		c.streams[msg.Stream] = stream
+		if _, ok := hashtagStream(msg.Stream); ok && c.tagEvents == nil {
+			c.tagEvents, c.untag = c.h.service.SubscribeHashtags()
+		}
+		if stream.replaying {
+			go c.replay(msg.Stream, msg.Since)
+		}
+		return c.write(models.StreamMessage{Type: "subscribed", Stream: msg.Stream})
+	case "unsubscribe":
+		delete(c.streams, msg.Stream)
+		if !c.hashtagSubscribed() {
+			c.unsubscribeHashtags()
+		}
+		return c.write(models.StreamMessage{Type: "unsubscribed", Stream: msg.Stream})
+	}
+	return c.write(models.StreamMessage{Type: "error", Error: fmt.Sprintf("unknown message type %s", msg.Type)})
+}
+
+func (c *wsConn) hashtagSubscribed() bool {
+	for stream := range c.streams {
+		if _, ok := hashtagStream(stream); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *wsConn) unsubscribeHashtags() {
+	if c.untag != nil {
+		c.untag()
+	}
+	c.tagEvents, c.untag = nil, nil
+}
+
+// replay reads what the client missed on the stream since the given id and hands it to the writer
+func (c *wsConn) replay(stream string, since int) {
+	replay := wsReplay{stream: stream, since: since}
+	replay.items, replay.ids, replay.gap, replay.err = c.h.replayStream(c.name, stream, since)
+	select {
+	case c.replays <- replay:
+	case <-c.closed:
+	}
+}
+
+// finishReplay sends what a stream missed followed by the live events held back meanwhile,
+// skipping those already replayed
+func (c *wsConn) finishReplay(replay wsReplay) error {
+	stream, ok := c.streams[replay.stream]
+	if !ok || !stream.replaying || stream.since != replay.since {
+		// unsubscribed, or subscribed again, since the replay started
+		return nil
+	}
+	last := replay.since
+	if replay.err != nil {
+		if err := c.write(models.StreamMessage{Type: "error", Stream: replay.stream, Error: replay.err.Error()}); err != nil {
+			return err
+		}
+	}
+	for i, item := range replay.items {
+		if err := c.write(models.StreamMessage{Type: "event", Stream: replay.stream, ID: replay.ids[i], Payload: item}); err != nil {
+			return err
+		}
+		last = replay.ids[i]
+	}
+	if replay.gap {
+		// too much was missed to replay, the client should refetch the collection from since
+		if err := c.write(models.StreamMessage{Type: "gap", Stream: replay.stream, Since: last}); err != nil {
+			return err
+		}
+	}
+	pending := stream.pending
+	stream.replaying, stream.pending = false, nil
+	for _, event := range pending {
+		if event.ID <= last {
+			continue
+		}
+		if err := c.send(replay.stream, event); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deliver sends a published event to each subscribed stream it belongs to, holding it back
+// for streams still replaying
+func (c *wsConn) deliver(event models.StreamEvent, ok bool) error {
+	if !ok {
+		// dropped by the broker for falling behind, the client should reconnect with since
+		c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "subscriber fell behind"), time.Now().Add(wsWriteWait))
+		return errors.New("subscriber fell behind")
+	}
+	for name, stream := range c.streams {
+		if !stream.replaying {
+			if err := c.send(name, event); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, ok := c.h.streamPayload(name, event); !ok {
+			continue
+		}
+		if len(stream.pending) >= c.h.conf.StreamBuffer {
+			c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "subscriber fell behind"), time.Now().Add(wsWriteWait))
+			return errors.New("subscriber fell behind")
+		}
+		stream.pending = append(stream.pending, event)
+	}
+	return nil
+}
+
+// send writes an event to the stream if it belongs there
+func (c *wsConn) send(stream string, event models.StreamEvent) error {
+	payload, ok := c.h.streamPayload(stream, event)
+	if !ok {
+		return nil
+	}
+	return c.write(models.StreamMessage{Type: "event", Stream: stream, ID: event.ID, Payload: payload})
+}
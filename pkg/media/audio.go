@@ -0,0 +1,455 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/hajimehoshi/go-mp3"
+)
+
+// WaveformLength is the number of peaks in the waveform generated for an uploaded audio file
+const WaveformLength = 1000
+
+// maxTagSize keeps oversized ID3 tags from being read into memory
+const maxTagSize = 16 << 20
+
+// Waveform is the peak amplitude (0 to 1) of each equal slice of an audio file, for players to draw
+type Waveform struct {
+	Duration float64   `json:"duration"`
+	Peaks    []float64 `json:"peaks"`
+}
+
+// id3 holds the metadata read from an ID3 tag
+type id3 struct {
+	title     string
+	artist    string
+	cover     []byte
+	coverType byte
+	size      int64
+}
+
+// memoryFile lets an embedded file be saved like an upload
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (memoryFile) Close() error {
+	return nil
+}
+
+// saveAudio saves an MP3 file and reads its duration from the frame headers, its title and
+// artist from its ID3 tags, and peaks for a waveform. Embedded cover art is saved as the
+// file's preview when requested.
+func (m *Media) saveAudio(storage Storage, preview bool) error {
+	// the file is read a few times over, so it's copied to a temporary file rather than memory
+	f, err := os.CreateTemp("", "upload-*"+m.FileExt)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	_, err = io.Copy(f, m.File)
+	if err != nil {
+		return err
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	err = storage.Put(m.UUID+m.FileExt, f, m.MimeType)
+	if err != nil {
+		return err
+	}
+
+	tag, err := readID3(f)
+	if err != nil {
+		return err
+	}
+	m.Title, m.Artist = tag.title, tag.artist
+	m.Duration, err = mp3Duration(f, tag.size)
+	if err != nil {
+		return err
+	}
+	peaks, err := mp3Peaks(f)
+	if err == nil {
+		waveform, err := json.Marshal(Waveform{Duration: m.Duration, Peaks: peaks})
+		if err != nil {
+			return err
+		}
+		name := m.UUID + "-waveform.json"
+		err = storage.Put(name, bytes.NewReader(waveform), "application/json")
+		if err != nil {
+			return err
+		}
+		m.Waveform = name
+	}
+
+	if !preview || tag.cover == nil {
+		return nil
+	}
+	cover := Media{
+		File:     memoryFile{bytes.NewReader(tag.cover)},
+		MimeType: Detect(tag.cover),
+		UUID:     m.UUID + "-cover",
+	}
+	if !isImage(cover.MimeType) {
+		return nil
+	}
+	cover.FileExt = extension(cover.MimeType)
+	err = cover.saveImage(storage, false)
+	if err != nil {
+		// an unreadable cover doesn't make the audio unusable
+		return nil
+	}
+	m.Preview = cover.UUID + cover.FileExt
+	m.PreviewMimeType = cover.MimeType
+	return nil
+}
+
+// readID3 reads the title, artist and front cover from the ID3v2 tag at the start of a
+// file, falling back to an ID3v1 tag at its end
+func readID3(f *os.File) (id3, error) {
+	var tag id3
+	header := make([]byte, 10)
+	_, err := f.ReadAt(header, 0)
+	if err != nil && err != io.EOF {
+		return tag, err
+	}
+	if bytes.HasPrefix(header, []byte("ID3")) && header[3] >= 2 && header[3] <= 4 {
+		version, flags := header[3], header[5]
+		size := syncsafe(header[6:10])
+		tag.size = 10 + size
+		if flags&0x10 != 0 {
+			tag.size += 10
+		}
+		if size <= maxTagSize {
+			data := make([]byte, size)
+			_, err = f.ReadAt(data, 10)
+			if err != nil && err != io.EOF {
+				return tag, err
+			}
+			if version < 4 && flags&0x80 != 0 {
+				data = unsynchronise(data)
+			}
+			if flags&0x40 != 0 && version > 2 && len(data) > 4 {
+				// skip the extended header
+				extended := int64(binary.BigEndian.Uint32(data)) + 4
+				if version == 4 {
+					extended = syncsafe(data[:4])
+				}
+				if extended > int64(len(data)) {
+					extended = int64(len(data))
+				}
+				data = data[extended:]
+			}
+			readID3Frames(&tag, data, version)
+		}
+	}
+	if tag.title != "" || tag.artist != "" {
+		return tag, nil
+	}
+
+	info, err := f.Stat()
+	if err != nil || info.Size() < 128 {
+		return tag, err
+	}
+	v1 := make([]byte, 128)
+	_, err = f.ReadAt(v1, info.Size()-128)
+	if err != nil {
+		return tag, err
+	}
+	if bytes.HasPrefix(v1, []byte("TAG")) {
+		tag.title = latin1(bytes.TrimRight(v1[3:33], "\x00 "))
+		tag.artist = latin1(bytes.TrimRight(v1[33:63], "\x00 "))
+	}
+	return tag, nil
+}
+
+func readID3Frames(tag *id3, data []byte, version byte) {
+	headerSize, idSize := 10, 4
+	if version == 2 {
+		headerSize, idSize = 6, 3
+	}
+	for len(data) >= headerSize && data[0] != 0 {
+		id := string(data[:idSize])
+		var size int64
+		switch version {
+		case 2:
+			size = int64(data[3])<<16 | int64(data[4])<<8 | int64(data[5])
+		case 3:
+			size = int64(binary.BigEndian.Uint32(data[4:8]))
+		default:
+			size = syncsafe(data[4:8])
+		}
+		if size > int64(len(data)-headerSize) {
+			return
+		}
+		frame := data[headerSize : int64(headerSize)+size]
+		if version == 4 && data[9]&0x02 != 0 {
+			frame = unsynchronise(frame)
+		}
+		switch id {
+		case "TIT2", "TT2":
+			tag.title = id3Text(frame)
+		case "TPE1", "TP1":
+			tag.artist = id3Text(frame)
+		case "APIC", "PIC":
+			picture, pictureType := id3Picture(frame, version)
+			// prefer the front cover (type 3) over other pictures
+			if picture != nil && (tag.cover == nil || (pictureType == 3 && tag.coverType != 3)) {
+				tag.cover, tag.coverType = picture, pictureType
+			}
+		}
+		data = data[int64(headerSize)+size:]
+	}
+}
+
+// id3Text decodes the first value of a text frame
+func id3Text(frame []byte) string {
+	if len(frame) < 2 {
+		return ""
+	}
+	text, _ := id3String(frame[1:], frame[0])
+	return strings.TrimSpace(text)
+}
+
+// id3Picture reads the image data and picture type of an APIC (or ID3v2.2 PIC) frame
+func id3Picture(frame []byte, version byte) ([]byte, byte) {
+	if len(frame) < 5 {
+		return nil, 0
+	}
+	encoding := frame[0]
+	rest := frame[1:]
+	if version == 2 {
+		// three character image format
+		rest = rest[3:]
+	} else {
+		i := bytes.IndexByte(rest, 0)
+		if i < 0 {
+			return nil, 0
+		}
+		rest = rest[i+1:]
+	}
+	if len(rest) < 2 {
+		return nil, 0
+	}
+	pictureType := rest[0]
+	_, n := id3String(rest[1:], encoding)
+	if 1+n > len(rest) {
+		return nil, 0
+	}
+	return rest[1+n:], pictureType
+}
+
+// id3String decodes a null terminated string in an ID3 text encoding, returning it along
+// with the number of bytes read including the terminator
+func id3String(b []byte, encoding byte) (string, int) {
+	switch encoding {
+	case 1, 2:
+		end := len(b)
+		n := len(b)
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				end, n = i, i+2
+				break
+			}
+		}
+		return utf16String(b[:end], encoding == 2), n
+	case 3:
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			return string(b[:i]), i + 1
+		}
+		return string(b), len(b)
+	}
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		return latin1(b[:i]), i + 1
+	}
+	return latin1(b), len(b)
+}
+
+func utf16String(b []byte, bigEndian bool) string {
+	var order binary.ByteOrder = binary.LittleEndian
+	if bigEndian {
+		order = binary.BigEndian
+	}
+	if len(b) >= 2 {
+		switch {
+		case b[0] == 0xFE && b[1] == 0xFF:
+			order, b = binary.BigEndian, b[2:]
+		case b[0] == 0xFF && b[1] == 0xFE:
+			order, b = binary.LittleEndian, b[2:]
+		}
+	}
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = order.Uint16(b[i*2:])
+	}
+	return string(utf16.Decode(units))
+}
+
+func latin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+func syncsafe(b []byte) int64 {
+	return int64(b[0]&0x7F)<<21 | int64(b[1]&0x7F)<<14 | int64(b[2]&0x7F)<<7 | int64(b[3]&0x7F)
+}
+
+// unsynchronise reverses ID3 unsynchronisation, which inserts a zero byte after each 0xFF
+func unsynchronise(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte{0xFF, 0x00}, []byte{0xFF})
+}
+
+var (
+	mp3Bitrates = [2][3][16]int{
+		// MPEG 1, layers I, II and III
+		{
+			{0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448, 0},
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384, 0},
+			{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0},
+		},
+		// MPEG 2 and 2.5, layers I, II and III
+		{
+			{0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256, 0},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+			{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},
+		},
+	}
+	mp3SampleRates = map[byte][3]int{
+		3: {44100, 48000, 32000}, // MPEG 1
+		2: {22050, 24000, 16000}, // MPEG 2
+		0: {11025, 12000, 8000},  // MPEG 2.5
+	}
+)
+
+// mp3Frame reads an MPEG audio frame header, returning the frame's length in bytes, its
+// number of samples and its sample rate
+func mp3Frame(header []byte) (int, int, int, bool) {
+	if len(header) < 4 || header[0] != 0xFF || header[1]&0xE0 != 0xE0 {
+		return 0, 0, 0, false
+	}
+	version := header[1] >> 3 & 0x03
+	layer := 4 - int(header[1]>>1&0x03)
+	bitrateIndex := header[2] >> 4
+	rateIndex := header[2] >> 2 & 0x03
+	padding := int(header[2] >> 1 & 0x01)
+	rates, ok := mp3SampleRates[version]
+	if !ok || layer == 4 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return 0, 0, 0, false
+	}
+	table := 0
+	if version != 3 {
+		table = 1
+	}
+	bitrate := mp3Bitrates[table][layer-1][bitrateIndex] * 1000
+	sampleRate := rates[rateIndex]
+	switch {
+	case layer == 1:
+		return (12*bitrate/sampleRate + padding) * 4, 384, sampleRate, true
+	case layer == 3 && version != 3:
+		return 72*bitrate/sampleRate + padding, 576, sampleRate, true
+	}
+	return 144*bitrate/sampleRate + padding, 1152, sampleRate, true
+}
+
+// mp3Duration adds up the samples of the frames following the ID3 tag, in seconds
+func mp3Duration(f *os.File, offset int64) (float64, error) {
+	_, err := f.Seek(offset, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return 0, err
+	}
+	duration := 0.0
+	for i := 0; i+4 <= len(data); {
+		length, samples, sampleRate, ok := mp3Frame(data[i : i+4])
+		if !ok || length < 4 {
+			// skip anything between frames
+			i++
+			continue
+		}
+		duration += float64(samples) / float64(sampleRate)
+		i += length
+	}
+	if duration == 0 {
+		return 0, fmt.Errorf("no MPEG audio frames found")
+	}
+	return math.Round(duration*1000) / 1000, nil
+}
+
+// mp3Peaks decodes an MP3 file into WaveformLength peaks
+func mp3Peaks(f *os.File) ([]float64, error) {
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := mp3.NewDecoder(f)
+	if err != nil {
+		return nil, err
+	}
+	// decoded samples are 16 bit stereo
+	samples := decoder.Length() / 4
+	if samples <= 0 {
+		return nil, fmt.Errorf("unknown audio length")
+	}
+	bucket := (samples + WaveformLength - 1) / WaveformLength
+	peaks := make([]float64, 0, WaveformLength)
+	buf := make([]byte, 4*4096)
+	peak, count := 0, int64(0)
+	for {
+		n, err := io.ReadFull(decoder, buf)
+		for i := 0; i+4 <= n; i += 4 {
+			for _, v := range []int{int(int16(binary.LittleEndian.Uint16(buf[i:]))), int(int16(binary.LittleEndian.Uint16(buf[i+2:])))} {
+				if v < 0 {
+					v = -v
+				}
+				if v > peak {
+					peak = v
+				}
+			}
+			count++
+			if count == bucket {
+				peaks = append(peaks, math.Round(float64(peak)/32768*100)/100)
+				peak, count = 0, 0
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if count > 0 {
+		peaks = append(peaks, math.Round(float64(peak)/32768*100)/100)
+	}
+	return peaks, nil
+}
+
+// FormatDuration formats seconds as an xsd:duration (e.g. PT3M25.5S)
+func FormatDuration(seconds float64) string {
+	millis := int64(math.Round(seconds * 1000))
+	h, m := millis/3600000, millis/60000%60
+	s := float64(millis%60000) / 1000
+	duration := "PT"
+	if h > 0 {
+		duration += fmt.Sprintf("%dH", h)
+	}
+	if m > 0 {
+		duration += fmt.Sprintf("%dM", m)
+	}
+	return duration + strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.3f", s), "0"), ".") + "S"
+}
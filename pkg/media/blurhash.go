@@ -0,0 +1,103 @@
+package media
+
+import (
+	"image"
+	"math"
+	"strings"
+)
+
+const base83 = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+// Blurhash encodes a compact placeholder for img from x by y components (see: https://github.com/woltapp/blurhash)
+func Blurhash(img *image.RGBA, x int, y int) string {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	if w == 0 || h == 0 {
+		return ""
+	}
+	var linear [256]float64
+	for i := range linear {
+		linear[i] = sRGBToLinear(i)
+	}
+	factors := make([][3]float64, x*y)
+	cosX := make([]float64, w)
+	cosY := make([]float64, h)
+	for j := 0; j < y; j++ {
+		for py := 0; py < h; py++ {
+			cosY[py] = math.Cos(math.Pi * float64(j) * float64(py) / float64(h))
+		}
+		for i := 0; i < x; i++ {
+			for px := 0; px < w; px++ {
+				cosX[px] = math.Cos(math.Pi * float64(i) * float64(px) / float64(w))
+			}
+			var r, g, b float64
+			for py := 0; py < h; py++ {
+				row := img.Pix[py*img.Stride:]
+				for px := 0; px < w; px++ {
+					basis := cosX[px] * cosY[py]
+					r += basis * linear[row[px*4]]
+					g += basis * linear[row[px*4+1]]
+					b += basis * linear[row[px*4+2]]
+				}
+			}
+			scale := 1.0
+			if i != 0 || j != 0 {
+				scale = 2.0
+			}
+			scale /= float64(w * h)
+			factors[j*x+i] = [3]float64{r * scale, g * scale, b * scale}
+		}
+	}
+
+	var hash strings.Builder
+	hash.WriteString(encode83((x-1)+(y-1)*9, 1))
+	maximum := 1.0
+	if len(factors) > 1 {
+		actual := 0.0
+		for _, f := range factors[1:] {
+			actual = math.Max(actual, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+		}
+		quantised := int(math.Max(0, math.Min(82, math.Floor(actual*166-0.5))))
+		maximum = float64(quantised+1) / 166
+		hash.WriteString(encode83(quantised, 1))
+	} else {
+		hash.WriteString(encode83(0, 1))
+	}
+	dc := factors[0]
+	hash.WriteString(encode83(linearToSRGB(dc[0])<<16+linearToSRGB(dc[1])<<8+linearToSRGB(dc[2]), 4))
+	for _, f := range factors[1:] {
+		quantise := func(v float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maximum, 0.5)*9+9.5))))
+		}
+		hash.WriteString(encode83(quantise(f[0])*19*19+quantise(f[1])*19+quantise(f[2]), 2))
+	}
+	return hash.String()
+}
+
+func encode83(value int, length int) string {
+	var b strings.Builder
+	for i := 1; i <= length; i++ {
+		digit := (value / int(math.Pow(83, float64(length-i)))) % 83
+		b.WriteByte(base83[digit])
+	}
+	return b.String()
+}
+
+func sRGBToLinear(value int) float64 {
+	v := float64(value) / 255
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(value float64) int {
+	v := math.Max(0, math.Min(1, value))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(value float64, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(value), exp), value)
+}
@@ -0,0 +1,142 @@
+package media
+
+import (
+	"bytes"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+)
+
+var extensions = map[string]string{
+	"image/png":        ".png",
+	"image/jpeg":       ".jpg",
+	"image/gif":        ".gif",
+	"image/webp":       ".webp",
+	"image/avif":       ".avif",
+	"image/heic":       ".heic",
+	"video/mp4":        ".mp4",
+	"video/webm":       ".webm",
+	"video/ogg":        ".ogv",
+	"video/quicktime":  ".mov",
+	"video/x-matroska": ".mkv",
+	"audio/mpeg":       ".mp3",
+	"audio/ogg":        ".ogg",
+	"audio/flac":       ".flac",
+	"audio/wav":        ".wav",
+	"audio/mp4":        ".m4a",
+	"audio/aac":        ".aac",
+	"application/pdf":  ".pdf",
+}
+
+// extension is the file extension files of a MIME type are stored with. It never comes from the
+// uploaded file's name, so a file is always served as the type it was detected as.
+func extension(mimeType string) string {
+	if ext, ok := extensions[mimeType]; ok {
+		return ext
+	}
+	if exts, err := mime.ExtensionsByType(mimeType); err == nil && len(exts) > 0 {
+		return exts[0]
+	}
+	return ".bin"
+}
+
+// Extension is the file extension for a MIME type
+func Extension(mimeType string) string {
+	return extension(mimeType)
+}
+
+// TypeByName is the media type a stored file is served as, taken from the extension it was
+// stored with. Anything that isn't an allowed upload type, an image or a waveform is served as
+// application/octet-stream.
+func TypeByName(name string, allowed AllowList) string {
+	ext := strings.ToLower(filepath.Ext(name))
+	mimeType := ""
+	for t, e := range extensions {
+		if e == ext {
+			mimeType = t
+			break
+		}
+	}
+	if mimeType == "" {
+		mimeType, _, _ = mime.ParseMediaType(mime.TypeByExtension(ext))
+	}
+	if _, ok := allowed[mimeType]; ok {
+		return mimeType
+	}
+	if _, ok := imageTypes[mimeType]; ok || mimeType == "application/json" {
+		return mimeType
+	}
+	return "application/octet-stream"
+}
+
+// Detect sniffs the MIME type of a file from its first bytes, recognizing the audio and video
+// containers http.DetectContentType misses or reports too loosely
+func Detect(buff []byte) string {
+	switch {
+	case bytes.HasPrefix(buff, []byte("fLaC")):
+		return "audio/flac"
+	case bytes.HasPrefix(buff, []byte("OggS")):
+		if bytes.Contains(buff, []byte("\x80theora")) {
+			return "video/ogg"
+		}
+		return "audio/ogg"
+	case bytes.HasPrefix(buff, []byte("ID3")):
+		return "audio/mpeg"
+	case len(buff) > 1 && buff[0] == 0xFF && (buff[1] == 0xF1 || buff[1] == 0xF9):
+		return "audio/aac"
+	case len(buff) > 1 && buff[0] == 0xFF && buff[1]&0xE0 == 0xE0:
+		return "audio/mpeg"
+	case len(buff) > 11 && bytes.HasPrefix(buff, []byte("RIFF")):
+		switch string(buff[8:12]) {
+		case "WAVE":
+			return "audio/wav"
+		case "WEBP":
+			return "image/webp"
+		case "AVI ":
+			return "video/x-msvideo"
+		}
+	case len(buff) > 11 && string(buff[4:8]) == "ftyp":
+		switch string(buff[8:12]) {
+		case "M4A ", "M4B ":
+			return "audio/mp4"
+		case "qt  ":
+			return "video/quicktime"
+		case "avif", "avis":
+			return "image/avif"
+		case "heic", "heix", "mif1":
+			return "image/heic"
+		}
+		return "video/mp4"
+	case bytes.HasPrefix(buff, []byte("\x1A\x45\xDF\xA3")):
+		if bytes.Contains(buff, []byte("webm")) {
+			return "video/webm"
+		}
+		return "video/x-matroska"
+	case bytes.HasPrefix(buff, []byte("%PDF-")):
+		return "application/pdf"
+	}
+	mimeType, _, err := mime.ParseMediaType(http.DetectContentType(buff))
+	if err != nil {
+		return "application/octet-stream"
+	}
+	return mimeType
+}
+
+// ObjectType maps a MIME type to the ActivityStreams object type for it
+func ObjectType(mimeType string) string {
+	switch {
+	case strings.HasPrefix(mimeType, "image/"):
+		return "Image"
+	case strings.HasPrefix(mimeType, "video/"):
+		return "Video"
+	case strings.HasPrefix(mimeType, "audio/"):
+		return "Audio"
+	}
+	return "Document"
+}
+
+// IsObjectType checks if t is one of the object types media is mapped to
+func IsObjectType(t string) bool {
+	return t == "Image" || t == "Video" || t == "Audio" || t == "Document"
+}
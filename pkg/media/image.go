@@ -0,0 +1,310 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+)
+
+// PreviewSize is the longest side of the preview generated for an uploaded image
+const PreviewSize = 400
+
+// maxPixels keeps decoding of oversized images from exhausting memory
+const maxPixels = 40000000
+
+// isImage checks if an image of mimeType is processed before it is saved
+func isImage(mimeType string) bool {
+	switch mimeType {
+	case "image/jpeg", "image/png", "image/gif", "image/webp":
+		return true
+	}
+	return false
+}
+
+// saveImage saves an image stripped of its metadata, recording its size and blurhash, along
+// with a preview if requested. JPEG and PNG files are re-encoded, which drops EXIF data, and
+// WebP files have their EXIF and XMP chunks removed. GIF files carry no EXIF data and are
+// saved as uploaded to keep animations intact.
+func (m *Media) saveImage(storage Storage, preview bool) error {
+	data, err := io.ReadAll(m.File)
+	if err != nil {
+		return err
+	}
+	var img *image.RGBA
+	switch m.MimeType {
+	case "image/jpeg", "image/png", "image/gif":
+		config, _, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		if config.Width*config.Height > maxPixels {
+			return fmt.Errorf("image too large: %dx%d", config.Width, config.Height)
+		}
+		decoded, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return err
+		}
+		img = toRGBA(decoded)
+	}
+	var buf bytes.Buffer
+	switch m.MimeType {
+	case "image/jpeg":
+		img = orient(img, jpegOrientation(data))
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90})
+		data = buf.Bytes()
+	case "image/png":
+		err = png.Encode(&buf, img)
+		data = buf.Bytes()
+	case "image/webp":
+		data = stripWebP(data)
+		m.Width, m.Height = webpSize(data)
+	}
+	if err != nil {
+		return err
+	}
+	err = storage.Put(m.UUID+m.FileExt, bytes.NewReader(data), m.MimeType)
+	if err != nil {
+		return err
+	}
+	if img == nil {
+		return nil
+	}
+
+	m.Width, m.Height = img.Bounds().Dx(), img.Bounds().Dy()
+	small := resize(img, PreviewSize)
+	m.Blurhash = Blurhash(small, 4, 3)
+	if !preview {
+		return nil
+	}
+	buf.Reset()
+	name := m.UUID + "-small.jpg"
+	mimeType := "image/jpeg"
+	if m.MimeType == "image/jpeg" {
+		err = jpeg.Encode(&buf, small, &jpeg.Options{Quality: 80})
+	} else {
+		// keep transparency
+		name = m.UUID + "-small.png"
+		mimeType = "image/png"
+		err = png.Encode(&buf, small)
+	}
+	if err != nil {
+		return err
+	}
+	err = storage.Put(name, bytes.NewReader(buf.Bytes()), mimeType)
+	if err != nil {
+		return err
+	}
+	m.Preview = name
+	m.PreviewMimeType = mimeType
+	return nil
+}
+
+func toRGBA(src image.Image) *image.RGBA {
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+	return dst
+}
+
+// resize scales src down to fit within size x size, averaging the pixels each output pixel covers
+func resize(src *image.RGBA, size int) *image.RGBA {
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	if w <= size && h <= size {
+		return src
+	}
+	dw, dh := size, size
+	if w > h {
+		dh = h * size / w
+	} else {
+		dw = w * size / h
+	}
+	if dw < 1 {
+		dw = 1
+	}
+	if dh < 1 {
+		dh = 1
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		sy0, sy1 := dy*h/dh, (dy+1)*h/dh
+		if sy1 == sy0 {
+			sy1++
+		}
+		for dx := 0; dx < dw; dx++ {
+			sx0, sx1 := dx*w/dw, (dx+1)*w/dw
+			if sx1 == sx0 {
+				sx1++
+			}
+			var sum [4]uint64
+			for sy := sy0; sy < sy1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := sx0; sx < sx1; sx++ {
+					p := row[sx*4 : sx*4+4]
+					sum[0] += uint64(p[0])
+					sum[1] += uint64(p[1])
+					sum[2] += uint64(p[2])
+					sum[3] += uint64(p[3])
+				}
+			}
+			n := uint64((sy1 - sy0) * (sx1 - sx0))
+			i := dst.PixOffset(dx, dy)
+			for c := 0; c < 4; c++ {
+				dst.Pix[i+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// orient rotates and flips src upright according to its EXIF orientation, since the
+// orientation tag is dropped along with the rest of the EXIF data
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return src
+	}
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			var sx, sy int
+			switch orientation {
+			case 2:
+				sx, sy = w-1-x, y
+			case 3:
+				sx, sy = w-1-x, h-1-y
+			case 4:
+				sx, sy = x, h-1-y
+			case 5:
+				sx, sy = y, x
+			case 6:
+				sx, sy = y, h-1-x
+			case 7:
+				sx, sy = w-1-y, h-1-x
+			case 8:
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):])
+		}
+	}
+	return dst
+}
+
+// jpegOrientation reads the orientation tag from the EXIF segment of a JPEG file, defaulting to 1 (upright)
+func jpegOrientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return 1
+		}
+		marker := data[i+1]
+		// the metadata segments all come before the image data
+		if marker == 0xDA || marker == 0xD9 {
+			return 1
+		}
+		length := int(binary.BigEndian.Uint16(data[i+2:]))
+		if length < 2 || i+2+length > len(data) {
+			return 1
+		}
+		segment := data[i+4 : i+2+length]
+		if marker == 0xE1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return exifOrientation(segment[6:])
+		}
+		i += 2 + length
+	}
+	return 1
+}
+
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	offset := int64(order.Uint32(tiff[4:]))
+	if offset+2 > int64(len(tiff)) {
+		return 1
+	}
+	entries := int64(order.Uint16(tiff[offset:]))
+	for i := int64(0); i < entries; i++ {
+		entry := offset + 2 + i*12
+		if entry+12 > int64(len(tiff)) {
+			return 1
+		}
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			orientation := int(order.Uint16(tiff[entry+8:]))
+			if orientation < 1 || orientation > 8 {
+				return 1
+			}
+			return orientation
+		}
+	}
+	return 1
+}
+
+// stripWebP removes the EXIF and XMP chunks of an extended WebP file (simple files carry no metadata)
+func stripWebP(data []byte) []byte {
+	if len(data) < 30 || string(data[12:16]) != "VP8X" {
+		return data
+	}
+	out := append([]byte{}, data[:12]...)
+	for i := 12; i+8 <= len(data); {
+		id := string(data[i : i+4])
+		size := int64(binary.LittleEndian.Uint32(data[i+4:]))
+		end := int64(i) + 8 + size + size%2
+		if end > int64(len(data)) {
+			end = int64(len(data))
+		}
+		if id != "EXIF" && id != "XMP " {
+			out = append(out, data[i:end]...)
+		}
+		i = int(end)
+	}
+	// clear the EXIF and XMP flags of the VP8X chunk
+	out[20] &^= 0x0C
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out
+}
+
+// webpSize reads the canvas size from the header of a WebP file
+func webpSize(data []byte) (int, int) {
+	if len(data) < 30 {
+		return 0, 0
+	}
+	switch string(data[12:16]) {
+	case "VP8X":
+		w := int(data[24]) | int(data[25])<<8 | int(data[26])<<16
+		h := int(data[27]) | int(data[28])<<8 | int(data[29])<<16
+		return w + 1, h + 1
+	case "VP8L":
+		if data[20] != 0x2F {
+			return 0, 0
+		}
+		bits := binary.LittleEndian.Uint32(data[21:])
+		return int(bits&0x3FFF) + 1, int(bits>>14&0x3FFF) + 1
+	case "VP8 ":
+		if !bytes.Equal(data[23:26], []byte{0x9D, 0x01, 0x2A}) {
+			return 0, 0
+		}
+		return int(binary.LittleEndian.Uint16(data[26:]) & 0x3FFF), int(binary.LittleEndian.Uint16(data[28:]) & 0x3FFF)
+	}
+	return 0, 0
+}
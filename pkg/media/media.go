@@ -3,47 +3,104 @@ package media
 import (
 	"fmt"
 	"io"
-	"log"
 	"mime/multipart"
 	"net/http"
-	"os"
-	"path/filepath"
+	"strconv"
+	"strings"
 
 	"github.com/google/uuid"
 )
 
 type Media struct {
-	File     multipart.File
-	MimeType string
-	Name     string
-	UUID     string
-	FileExt  string
+	File            multipart.File
+	MimeType        string
+	Name            string
+	UUID            string
+	FileExt         string
+	Width           int
+	Height          int
+	Blurhash        string
+	Preview         string
+	PreviewMimeType string
+	Duration        float64
+	Title           string
+	Artist          string
+	Waveform        string
+	Size            int64
+	SHA256          string
+	// Stored is set when the file was written to storage as it was received
+	Stored bool
 }
 
-func ParseMedia(r *http.Request, name string) (Media, error) {
-	file, header, err := r.FormFile("file")
-	if err != nil {
-		return Media{}, err
+// AllowList maps the MIME types accepted for upload to their maximum size in bytes
+type AllowList map[string]int64
+
+var imageTypes = AllowList{
+	"image/png":  5 * 1024 * 1024,
+	"image/jpeg": 5 * 1024 * 1024,
+	"image/gif":  5 * 1024 * 1024,
+	"image/webp": 5 * 1024 * 1024,
+}
+
+// ParseAllowList reads a comma separated list of type:megabytes pairs, e.g. "image/png:10,audio/mpeg:15"
+func ParseAllowList(list string) (AllowList, error) {
+	allowed := make(AllowList)
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		i := strings.LastIndex(item, ":")
+		if i < 0 {
+			return nil, fmt.Errorf("missing size limit for %s", item)
+		}
+		size, err := strconv.ParseFloat(item[i+1:], 64)
+		if err != nil || size <= 0 {
+			return nil, fmt.Errorf("invalid size limit for %s", item)
+		}
+		allowed[strings.ToLower(strings.TrimSpace(item[:i]))] = int64(size * 1024 * 1024)
 	}
-	defer file.Close()
+	return allowed, nil
+}
 
-	if header.Size > 15*1024*1024 {
-		return Media{}, fmt.Errorf("file too large: %d", header.Size)
+func ParseMedia(r *http.Request, name string, allowed AllowList) (Media, error) {
+	return parseMedia(r, name, allowed)
+}
+
+func ParseImage(r *http.Request, name string) (Media, error) {
+	return parseMedia(r, name, imageTypes)
+}
+
+func parseMedia(r *http.Request, name string, allowed AllowList) (Media, error) {
+	file, header, err := r.FormFile(name)
+	if err != nil {
+		return Media{}, err
 	}
+	return parseFile(file, header, allowed)
+}
 
+func parseFile(file multipart.File, header *multipart.FileHeader, allowed AllowList) (Media, error) {
 	buff := make([]byte, 512)
-	_, err = file.Read(buff)
-	if err != nil {
+	n, err := file.Read(buff)
+	if err != nil && err != io.EOF {
+		file.Close()
 		return Media{}, err
 	}
 
-	filetype := http.DetectContentType(buff)
-	if filetype != "audio/mpeg" {
+	filetype := Detect(buff[:n])
+	maxSize, ok := allowed[filetype]
+	if !ok {
+		file.Close()
 		return Media{}, fmt.Errorf("invalid file type: %s", filetype)
 	}
+	if header.Size > maxSize {
+		file.Close()
+		return Media{}, fmt.Errorf("file too large for %s: %d", filetype, header.Size)
+	}
 
 	_, err = file.Seek(0, io.SeekStart)
 	if err != nil {
+		file.Close()
 		return Media{}, err
 	}
 
@@ -52,33 +109,32 @@ func ParseMedia(r *http.Request, name string) (Media, error) {
 		MimeType: filetype,
 		Name:     header.Filename,
 		UUID:     uuid.New().String(),
-		FileExt:  filepath.Ext(header.Filename),
+		FileExt:  extension(filetype),
 	}
 
 	return m, nil
 }
 
-func Delete(path string) error {
-	log.Printf("deleting: %s\n", path)
-	return os.Remove(path)
+// Save stores the file, stripping the metadata of images
+func (m *Media) Save(storage Storage) error {
+	return m.save(storage, false)
 }
 
-func (m *Media) Save(dir string) error {
-	err := os.MkdirAll(dir, os.ModePerm)
-	if err != nil {
-		return err
-	}
+// SaveWithPreview stores the file along with a downsized preview when it is an image
+func (m *Media) SaveWithPreview(storage Storage) error {
+	return m.save(storage, true)
+}
 
-	f, err := os.Create(fmt.Sprintf(dir + m.UUID + m.FileExt))
-	if err != nil {
-		return err
+func (m *Media) save(storage Storage, preview bool) error {
+	if m.Stored {
+		return nil
 	}
-	defer f.Close()
-
-	_, err = io.Copy(f, m.File)
-	if err != nil {
-		return err
+	defer m.File.Close()
+	if isImage(m.MimeType) {
+		return m.saveImage(storage, preview)
 	}
-
-	return nil
+	if m.MimeType == "audio/mpeg" {
+		return m.saveAudio(storage, preview)
+	}
+	return storage.Put(m.UUID+m.FileExt, m.File, m.MimeType)
 }
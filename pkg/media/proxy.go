@@ -0,0 +1,147 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+)
+
+var privateNetworks = []string{
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"240.0.0.0/4",
+	"64:ff9b::/96",
+	"fc00::/7",
+}
+
+// Remote is remote media downloaded to a temporary file
+type Remote struct {
+	File     *os.File
+	MimeType string
+	Size     int64
+}
+
+// Close removes the temporary file
+func (r *Remote) Close() {
+	r.File.Close()
+	os.Remove(r.File.Name())
+}
+
+// NewProxyClient creates an http client that only connects to public addresses, so the media
+// proxy can't be used to reach the server's own network
+func NewProxyClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network string, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil || !isPublic(ip) {
+				return fmt.Errorf("%s is not a public address", host)
+			}
+			return nil
+		},
+	}
+	return &http.Client{
+		Timeout: 60 * time.Second,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("invalid redirect to %s", req.URL)
+			}
+			return nil
+		},
+	}
+}
+
+func isPublic(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+	for _, cidr := range privateNetworks {
+		_, network, _ := net.ParseCIDR(cidr)
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Download fetches remote media to a temporary file, checking its type (by content) and
+// size against the allow list
+func Download(client *http.Client, iri string, allowed AllowList) (*Remote, error) {
+	req, err := http.NewRequestWithContext(context.Background(), "GET", iri, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received status code %d from %s", resp.StatusCode, iri)
+	}
+
+	buff := make([]byte, 512)
+	n, err := io.ReadFull(resp.Body, buff)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	mimeType := Detect(buff[:n])
+	maxSize, ok := allowed[mimeType]
+	if !ok {
+		return nil, fmt.Errorf("invalid file type: %s", mimeType)
+	}
+	if resp.ContentLength > maxSize {
+		return nil, fmt.Errorf("file too large for %s: %d", mimeType, resp.ContentLength)
+	}
+
+	f, err := os.CreateTemp("", "proxy-*")
+	if err != nil {
+		return nil, err
+	}
+	remote := &Remote{File: f, MimeType: mimeType}
+	_, err = f.Write(buff[:n])
+	if err != nil {
+		remote.Close()
+		return nil, err
+	}
+	// the declared length can't be trusted, so the copy stops just past the limit
+	copied, err := io.Copy(f, io.LimitReader(resp.Body, maxSize-int64(n)+1))
+	if err != nil {
+		remote.Close()
+		return nil, err
+	}
+	remote.Size = int64(n) + copied
+	if remote.Size > maxSize {
+		remote.Close()
+		return nil, fmt.Errorf("file too large for %s", mimeType)
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		remote.Close()
+		return nil, err
+	}
+	return remote, nil
+}
@@ -0,0 +1,362 @@
+package media
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cheebz/go-pub/pkg/config"
+)
+
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// S3Storage keeps files in a bucket of an S3 compatible service, such as AWS S3 or MinIO,
+// signing requests with AWS Signature Version 4
+type S3Storage struct {
+	conf     config.Configuration
+	endpoint *url.URL
+	client   *http.Client
+}
+
+type listBucketResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		LastModified time.Time `xml:"LastModified"`
+		Size         int64     `xml:"Size"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+func NewS3Storage(_conf config.Configuration) (Storage, error) {
+	if _conf.Storage.S3Bucket == "" {
+		return nil, errors.New("S3_BUCKET is not set")
+	}
+	endpoint, err := url.Parse(_conf.Storage.S3Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if endpoint.Scheme == "" || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid S3_ENDPOINT %s", _conf.Storage.S3Endpoint)
+	}
+	return &S3Storage{
+		conf:     _conf,
+		endpoint: endpoint,
+		client:   newS3Client(),
+	}, nil
+}
+
+// newS3Client bounds each stage of a request rather than the whole of it, since large files
+// are streamed to and from the bucket for as long as a download or upload takes
+func newS3Client() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ResponseHeaderTimeout: 30 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+			IdleConnTimeout:       90 * time.Second,
+			MaxIdleConnsPerHost:   10,
+		},
+	}
+}
+
+// bucketURL addresses the bucket by path (endpoint/bucket/) or by virtual host (bucket.endpoint/)
+func (s *S3Storage) bucketURL() *url.URL {
+	u := *s.endpoint
+	u.RawQuery = ""
+	if s.conf.Storage.S3PathStyle {
+		u.Path = fmt.Sprintf("%s/%s/", strings.TrimSuffix(u.Path, "/"), s.conf.Storage.S3Bucket)
+	} else {
+		u.Host = fmt.Sprintf("%s.%s", s.conf.Storage.S3Bucket, u.Host)
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/"
+	}
+	return &u
+}
+
+func (s *S3Storage) objectURL(name string) *url.URL {
+	u := s.bucketURL()
+	u.Path += name
+	return u
+}
+
+func (s *S3Storage) do(req *http.Request) (*http.Response, error) {
+	s.sign(req, time.Now().UTC())
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		resp.Body.Close()
+		return nil, os.ErrNotExist
+	}
+	if resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		resp.Body.Close()
+		return nil, fmt.Errorf("received status code %d from %s %s: %s", resp.StatusCode, req.Method, req.URL.Path, body)
+	}
+	return resp, nil
+}
+
+func (s *S3Storage) Put(name string, content io.ReadSeeker, mimeType string) error {
+	size, err := content.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	_, err = content.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PUT", s.objectURL(name).String(), ioutil.NopCloser(content))
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", mimeType)
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (s *S3Storage) Open(name string) (File, FileInfo, error) {
+	req, err := http.NewRequest("HEAD", s.objectURL(name).String(), nil)
+	if err != nil {
+		return nil, FileInfo{}, err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, FileInfo{}, err
+	}
+	resp.Body.Close()
+	info := FileInfo{Name: name, Size: resp.ContentLength}
+	info.Modified, _ = http.ParseTime(resp.Header.Get("Last-Modified"))
+	return &s3File{storage: s, name: name, size: info.Size}, info, nil
+}
+
+func (s *S3Storage) Delete(name string) error {
+	log.Printf("deleting: %s\n", name)
+	req, err := http.NewRequest("DELETE", s.objectURL(name).String(), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// URL is the file under MEDIA_URL if set, or a presigned URL when S3_PRESIGN is enabled
+func (s *S3Storage) URL(name string) string {
+	if u := mediaURL(s.conf, name); u != "" {
+		return u
+	}
+	if !s.conf.Storage.S3Presign {
+		return ""
+	}
+	return s.presign(name, time.Now().UTC())
+}
+
+func (s *S3Storage) List() ([]FileInfo, error) {
+	var files []FileInfo
+	token := ""
+	for {
+		u := s.bucketURL()
+		query := url.Values{"list-type": {"2"}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		u.RawQuery = canonicalQuery(query)
+		req, err := http.NewRequest("GET", u.String(), nil)
+		if err != nil {
+			return files, err
+		}
+		resp, err := s.do(req)
+		if err != nil {
+			return files, err
+		}
+		var result listBucketResult
+		err = xml.NewDecoder(resp.Body).Decode(&result)
+		resp.Body.Close()
+		if err != nil {
+			return files, err
+		}
+		for _, item := range result.Contents {
+			files = append(files, FileInfo{Name: item.Key, Size: item.Size, Modified: item.LastModified})
+		}
+		if !result.IsTruncated || result.NextContinuationToken == "" {
+			return files, nil
+		}
+		token = result.NextContinuationToken
+	}
+}
+
+// sign adds an AWS Signature Version 4 Authorization header to req
+func (s *S3Storage) sign(req *http.Request, t time.Time) {
+	amzDate := t.Format("20060102T150405Z")
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", unsignedPayload)
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n", req.URL.Host, unsignedPayload, amzDate),
+		signedHeaders,
+		unsignedPayload,
+	}, "\n")
+	scope, signature := s.signature(t, canonicalRequest)
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.conf.Storage.S3AccessKey, scope, signedHeaders, signature))
+}
+
+// presign creates a URL allowing anyone holding it to GET a file for S3_PRESIGN_SECONDS
+func (s *S3Storage) presign(name string, t time.Time) string {
+	u := s.objectURL(name)
+	amzDate := t.Format("20060102T150405Z")
+	query := url.Values{
+		"X-Amz-Algorithm":     {"AWS4-HMAC-SHA256"},
+		"X-Amz-Credential":    {fmt.Sprintf("%s/%s", s.conf.Storage.S3AccessKey, s.scope(t))},
+		"X-Amz-Date":          {amzDate},
+		"X-Amz-Expires":       {strconv.Itoa(s.conf.Storage.S3PresignSeconds)},
+		"X-Amz-SignedHeaders": {"host"},
+	}
+	canonicalRequest := strings.Join([]string{
+		"GET",
+		u.EscapedPath(),
+		canonicalQuery(query),
+		fmt.Sprintf("host:%s\n", u.Host),
+		"host",
+		unsignedPayload,
+	}, "\n")
+	_, signature := s.signature(t, canonicalRequest)
+	query.Set("X-Amz-Signature", signature)
+	u.RawQuery = canonicalQuery(query)
+	return u.String()
+}
+
+func (s *S3Storage) scope(t time.Time) string {
+	return fmt.Sprintf("%s/%s/s3/aws4_request", t.Format("20060102"), s.conf.Storage.S3Region)
+}
+
+// signature signs a canonical request, returning the credential scope and the signature
+func (s *S3Storage) signature(t time.Time, canonicalRequest string) (string, string) {
+	scope := s.scope(t)
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := fmt.Sprintf("AWS4-HMAC-SHA256\n%s\n%s\n%s", t.Format("20060102T150405Z"), scope, hex.EncodeToString(hash[:]))
+	key := hmacSHA256([]byte("AWS4"+s.conf.Storage.S3SecretKey), t.Format("20060102"))
+	key = hmacSHA256(key, s.conf.Storage.S3Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	return scope, hex.EncodeToString(hmacSHA256(key, stringToSign))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery sorts and encodes query parameters the way Signature Version 4 expects
+func canonicalQuery(query url.Values) string {
+	var params []string
+	for key, values := range query {
+		for _, value := range values {
+			params = append(params, fmt.Sprintf("%s=%s", uriEncode(key), uriEncode(value)))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// uriEncode percent-encodes everything except the unreserved characters of RFC 3986
+func uriEncode(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3File reads a stored object with ranged GET requests, so it can be served with http.ServeContent
+type s3File struct {
+	storage *S3Storage
+	name    string
+	size    int64
+	offset  int64
+	body    io.ReadCloser
+}
+
+func (f *s3File) Read(p []byte) (int, error) {
+	if f.offset >= f.size {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		req, err := http.NewRequest("GET", f.storage.objectURL(f.name).String(), nil)
+		if err != nil {
+			return 0, err
+		}
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", f.offset))
+		resp, err := f.storage.do(req)
+		if err != nil {
+			return 0, err
+		}
+		f.body = resp.Body
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *s3File) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.size
+	}
+	if offset < 0 {
+		return f.offset, errors.New("negative position")
+	}
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *s3File) Close() error {
+	if f.body == nil {
+		return nil
+	}
+	return f.body.Close()
+}
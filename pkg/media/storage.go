@@ -0,0 +1,160 @@
+package media
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cheebz/go-pub/pkg/config"
+)
+
+// Storage keeps uploaded files by name
+type Storage interface {
+	Put(name string, content io.ReadSeeker, mimeType string) error
+	Open(name string) (File, FileInfo, error)
+	Delete(name string) error
+	// URL is where clients can fetch a file directly, or empty when it is served by the app
+	URL(name string) string
+	List() ([]FileInfo, error)
+}
+
+// StreamStorage is implemented by backends that can store a file as it is read, without
+// knowing its size up front
+type StreamStorage interface {
+	PutStream(name string, content io.Reader, mimeType string) error
+}
+
+// File is a stored file opened for reading
+type File interface {
+	io.ReadSeeker
+	io.Closer
+}
+
+// FileInfo describes a stored file
+type FileInfo struct {
+	Name     string
+	Size     int64
+	Modified time.Time
+}
+
+// NewStorage creates the storage backend named by the STORAGE setting
+func NewStorage(_conf config.Configuration) (Storage, error) {
+	switch _conf.Storage.Backend {
+	case "", "local":
+		return NewLocalStorage(_conf), nil
+	case "s3":
+		return NewS3Storage(_conf)
+	}
+	return nil, fmt.Errorf("unknown storage backend %s", _conf.Storage.Backend)
+}
+
+// mediaURL is a file's address under the configured MEDIA_URL, if any
+func mediaURL(conf config.Configuration, name string) string {
+	if conf.Storage.MediaURL == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s/%s", strings.TrimSuffix(conf.Storage.MediaURL, "/"), name)
+}
+
+// LocalStorage keeps files in UPLOAD_DIR
+type LocalStorage struct {
+	conf config.Configuration
+}
+
+func NewLocalStorage(_conf config.Configuration) Storage {
+	return &LocalStorage{
+		conf: _conf,
+	}
+}
+
+// path keeps names from reaching outside the upload directory
+func (l *LocalStorage) path(name string) string {
+	return filepath.Join(l.conf.UploadDir, filepath.Base(name))
+}
+
+func (l *LocalStorage) Put(name string, content io.ReadSeeker, mimeType string) error {
+	err := os.MkdirAll(l.conf.UploadDir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(l.path(name))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(f, content)
+	return err
+}
+
+// PutStream writes content to the file as it is read, removing what was written if reading fails
+func (l *LocalStorage) PutStream(name string, content io.Reader, mimeType string) error {
+	err := os.MkdirAll(l.conf.UploadDir, os.ModePerm)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(l.path(name))
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(l.path(name))
+		return err
+	}
+	return nil
+}
+
+func (l *LocalStorage) Open(name string) (File, FileInfo, error) {
+	f, err := os.Open(l.path(name))
+	if err != nil {
+		return nil, FileInfo{}, err
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, FileInfo{}, err
+	}
+	if stat.IsDir() {
+		f.Close()
+		return nil, FileInfo{}, os.ErrNotExist
+	}
+	return f, FileInfo{Name: stat.Name(), Size: stat.Size(), Modified: stat.ModTime()}, nil
+}
+
+func (l *LocalStorage) Delete(name string) error {
+	log.Printf("deleting: %s\n", name)
+	return os.Remove(l.path(name))
+}
+
+func (l *LocalStorage) URL(name string) string {
+	return mediaURL(l.conf, name)
+}
+
+func (l *LocalStorage) List() ([]FileInfo, error) {
+	entries, err := os.ReadDir(l.conf.UploadDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var files []FileInfo
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, FileInfo{Name: info.Name(), Size: info.Size(), Modified: info.ModTime()})
+	}
+	return files, nil
+}
@@ -0,0 +1,250 @@
+package media
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// MaxChunkSize is the most a single request of a chunked upload may carry
+	MaxChunkSize int64 = 8 * 1024 * 1024
+	maxValueSize int64 = 1024 * 1024
+)
+
+// tempFile is an upload spooled to disk, removed when closed
+type tempFile struct {
+	*os.File
+}
+
+func (t *tempFile) Close() error {
+	err := t.File.Close()
+	os.Remove(t.File.Name())
+	return err
+}
+
+// limitedReader fails once more than max bytes are read, rather than stopping quietly,
+// so a partly stored file is discarded
+type limitedReader struct {
+	r        io.Reader
+	max      int64
+	n        int64
+	mimeType string
+	hash     hash.Hash
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	n, err := l.r.Read(p)
+	l.n += int64(n)
+	if l.n > l.max {
+		return 0, fmt.Errorf("file too large for %s", l.mimeType)
+	}
+	l.hash.Write(p[:n])
+	return n, err
+}
+
+// processed types are decoded when saved, so they are kept whole until then
+func processed(mimeType string) bool {
+	return isImage(mimeType) || mimeType == "audio/mpeg"
+}
+
+// Receive reads an upload as it arrives, detecting its type from the first bytes, failing as
+// soon as it passes the type's size limit and hashing it on the way. Processed types (images,
+// MP3s) are spooled to a temporary file to be saved later; the rest are written straight to
+// storage when it can stream, or spooled and stored once complete.
+func Receive(content io.Reader, filename string, allowed AllowList, storage Storage) (Media, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(content, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return Media{}, err
+	}
+	mimeType := Detect(head[:n])
+	maxSize, ok := allowed[mimeType]
+	if !ok {
+		return Media{}, fmt.Errorf("invalid file type: %s", mimeType)
+	}
+	m := Media{
+		MimeType: mimeType,
+		Name:     filename,
+		UUID:     uuid.New().String(),
+		FileExt:  extension(mimeType),
+	}
+	reader := &limitedReader{
+		r:        io.MultiReader(bytes.NewReader(head[:n]), content),
+		max:      maxSize,
+		mimeType: mimeType,
+		hash:     sha256.New(),
+	}
+
+	if streamer, ok := storage.(StreamStorage); ok && !processed(mimeType) {
+		err = streamer.PutStream(m.UUID+m.FileExt, reader, mimeType)
+		if err != nil {
+			return Media{}, err
+		}
+		m.Stored = true
+	} else {
+		f, err := os.CreateTemp("", "upload-*")
+		if err != nil {
+			return Media{}, err
+		}
+		file := &tempFile{f}
+		_, err = io.Copy(f, reader)
+		if err == nil {
+			_, err = f.Seek(0, io.SeekStart)
+		}
+		if err != nil {
+			file.Close()
+			return Media{}, err
+		}
+		m.File = file
+	}
+	m.Size = reader.n
+	m.SHA256 = hex.EncodeToString(reader.hash.Sum(nil))
+	return m, nil
+}
+
+// ReadMultipart streams a multipart form, receiving each file of field (at most maxFiles)
+// with Receive and reading the other fields as values. Files are named with the matching
+// entry of the "name" values (alt text) when given.
+func ReadMultipart(r *http.Request, field string, maxFiles int, allowed AllowList, storage Storage) ([]Media, url.Values, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, nil, err
+	}
+	values := make(url.Values)
+	var files []Media
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			Discard(files, storage)
+			return nil, nil, err
+		}
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, maxValueSize+1))
+			part.Close()
+			if err == nil && int64(len(value)) > maxValueSize {
+				err = fmt.Errorf("%s is too long", part.FormName())
+			}
+			if err != nil {
+				Discard(files, storage)
+				return nil, nil, err
+			}
+			values.Add(part.FormName(), string(value))
+			continue
+		}
+		if part.FormName() != field {
+			part.Close()
+			continue
+		}
+		if len(files) >= maxFiles {
+			part.Close()
+			Discard(files, storage)
+			return nil, nil, fmt.Errorf("too many files, at most %d are allowed", maxFiles)
+		}
+		m, err := Receive(part, part.FileName(), allowed, storage)
+		part.Close()
+		if err != nil {
+			Discard(files, storage)
+			return nil, nil, fmt.Errorf("%s: %s", part.FileName(), err)
+		}
+		files = append(files, m)
+	}
+	if len(files) == 0 {
+		return nil, nil, fmt.Errorf("no %s uploaded", field)
+	}
+	for i, name := range values["name"] {
+		if i < len(files) && name != "" {
+			files[i].Name = name
+		}
+	}
+	return files, values, nil
+}
+
+// Discard removes received files that will not be saved
+func Discard(files []Media, storage Storage) {
+	for _, m := range files {
+		m.Discard(storage)
+	}
+}
+
+// Discard removes a received file that will not be saved
+func (m *Media) Discard(storage Storage) {
+	if m.Stored {
+		if err := storage.Delete(m.UUID + m.FileExt); err != nil && !errors.Is(err, os.ErrNotExist) {
+			log.Println(err)
+		}
+		return
+	}
+	m.Close()
+}
+
+// Close releases the file of an upload that has not been saved
+func (m *Media) Close() {
+	if m.File != nil {
+		m.File.Close()
+	}
+}
+
+// PartName is a new stored name for the chunk of upload id starting at offset. Each is unique, so
+// a retried request can't overwrite a chunk another request has recorded.
+func PartName(id string, offset int64) string {
+	return fmt.Sprintf("%s.part%d-%s", id, offset, uuid.New().String())
+}
+
+// partsReader reads the stored chunks of an upload in turn, opening each as it is reached
+type partsReader struct {
+	storage Storage
+	names   []string
+	current File
+}
+
+// OpenParts reads the stored chunks of an upload, in order, as one file
+func OpenParts(storage Storage, names []string) io.ReadCloser {
+	return &partsReader{storage: storage, names: append([]string(nil), names...)}
+}
+
+func (p *partsReader) Read(b []byte) (int, error) {
+	for {
+		if p.current == nil {
+			if len(p.names) == 0 {
+				return 0, io.EOF
+			}
+			f, _, err := p.storage.Open(p.names[0])
+			if err != nil {
+				return 0, err
+			}
+			p.current = f
+			p.names = p.names[1:]
+		}
+		n, err := p.current.Read(b)
+		if err == io.EOF {
+			p.current.Close()
+			p.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (p *partsReader) Close() error {
+	if p.current == nil {
+		return nil
+	}
+	return p.current.Close()
+}
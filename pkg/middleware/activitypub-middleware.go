@@ -7,6 +7,7 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"regexp"
+	"strings"
 
 	"github.com/cheebz/go-auth-helpers"
 	"github.com/cheebz/go-pub/pkg/activitypub"
@@ -35,6 +36,9 @@ func NewActivityPubMiddleware(_client string, _auth string, _response responses.
 func (m *ActivityPubMiddleware) CreateCORSMiddleware(allowedOrigins []string) func(h http.Handler) http.Handler {
 	cors := cors.New(cors.Options{
 		AllowedOrigins:   allowedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PATCH", "DELETE", "HEAD"},
+		AllowedHeaders:   []string{"Accept", "Content-Type", "X-Requested-With", "Upload-Length", "Upload-Offset"},
+		ExposedHeaders:   []string{"Location", "Upload-Length", "Upload-Offset"},
 		AllowCredentials: true,
 	})
 	return cors.Handler
@@ -143,6 +147,34 @@ func (m *ActivityPubMiddleware) CreateJwtUsernameMiddleware(nameParam string) fu
 	}
 }
 
+func (m *ActivityPubMiddleware) CreateAdminMiddleware(admins []string, claim string) func(h http.Handler) http.Handler {
+	return func(h http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			authMap, err := auth.Authenticate(w, r, m.auth)
+			if err != nil {
+				m.response.UnauthorizedRequest(w, err)
+				return
+			}
+			username, ok := authMap["username"].(string)
+			if !ok {
+				m.response.UnauthorizedRequest(w, errors.New("invalid response from auth endpoint"))
+				return
+			}
+			if isAdmin, ok := authMap[claim].(bool); ok && isAdmin {
+				h.ServeHTTP(w, r)
+				return
+			}
+			for _, admin := range admins {
+				if admin = strings.TrimSpace(admin); admin != "" && admin == username {
+					h.ServeHTTP(w, r)
+					return
+				}
+			}
+			m.response.Forbidden(w, errors.New("admins only"))
+		})
+	}
+}
+
 func (m *ActivityPubMiddleware) CreateUserMiddleware(service services.Service) func(h http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -13,4 +13,5 @@ type Middleware interface {
 	// JwtMiddleware(h http.Handler) http.Handler
 	CreateUserMiddleware(service services.Service) func(h http.Handler) http.Handler
 	CreateJwtUsernameMiddleware(name string) func(h http.Handler) http.Handler
+	CreateAdminMiddleware(admins []string, claim string) func(h http.Handler) http.Handler
 }
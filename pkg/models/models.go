@@ -1,6 +1,9 @@
 package models
 
 import (
+	"fmt"
+	"time"
+
 	"github.com/cheebz/arb"
 )
 
@@ -45,10 +48,30 @@ type DataSource struct {
 
 // User struct
 type User struct {
-	ID           int    `json:"id"`
-	Name         string `json:"name"`
-	Discoverable bool   `json:"discoverable"`
-	IRI          string `json:"url"`
+	ID             int            `json:"id"`
+	Name           string         `json:"name"`
+	Discoverable   bool           `json:"discoverable"`
+	IRI            string         `json:"url"`
+	Suspended      bool           `json:"suspended"`
+	Deleted        *time.Time     `json:"deleted,omitempty"`
+	AlsoKnownAs    []string       `json:"alsoKnownAs"`
+	MovedTo        string         `json:"movedTo,omitempty"`
+	DisplayName    string         `json:"displayName"`
+	Summary        string         `json:"summary"`
+	Icon           string         `json:"icon,omitempty"`
+	IconMediaType  string         `json:"iconMediaType,omitempty"`
+	Image          string         `json:"image,omitempty"`
+	ImageMediaType string         `json:"imageMediaType,omitempty"`
+	Fields         []ProfileField `json:"fields,omitempty"`
+	ActorType      string         `json:"type"`
+}
+
+// ProfileField struct
+type ProfileField struct {
+	ID       int        `json:"id"`
+	Name     string     `json:"name"`
+	Value    string     `json:"value"`
+	Verified *time.Time `json:"verified,omitempty"`
 }
 
 // Group struct
@@ -78,15 +101,15 @@ type Object struct {
 	Id      string      `json:"id"`
 	Type    string      `json:"type"`
 
-	Attachment   string      `json:"attachment,omitempty"`
+	Attachment   interface{} `json:"attachment,omitempty"`
 	AttributedTo interface{} `json:"attributedTo,omitempty"`
 	Audience     []string    `json:"audience,omitempty"`
 	Content      interface{} `json:"content,omitempty"`
 	Name         interface{} `json:"name,omitempty"`
 	EndTime      string      `json:"endTime,omitempty"`
 	Generator    string      `json:"generator,omitempty"`
-	Icon         string      `json:"icon,omitempty"`
-	Image        string      `json:"image,omitempty"`
+	Icon         interface{} `json:"icon,omitempty"`
+	Image        interface{} `json:"image,omitempty"`
 	InReplyTo    interface{} `json:"inReplyTo,omitempty"`
 	Location     string      `json:"location,omitempty"`
 	Preview      string      `json:"preview,omitempty"`
@@ -94,7 +117,7 @@ type Object struct {
 	Replies      string      `json:"replies,omitempty"`
 	StartTime    string      `json:"startTime,omitempty"`
 	Summary      string      `json:"summary,omitempty"`
-	Tag          string      `json:"tag,omitempty"`
+	Tag          interface{} `json:"tag,omitempty"`
 	Updated      string      `json:"updated,omitempty"`
 	Url          interface{} `json:"url,omitempty"`
 	To           []string    `json:"to,omitempty"`
@@ -139,6 +162,42 @@ func NewLink() Link {
 	return link
 }
 
+// Attachment struct (media attached to an object, see: https://docs.joinmastodon.org/spec/activitypub/#as)
+type Attachment struct {
+	Type      string `json:"type"`
+	MediaType string `json:"mediaType,omitempty"`
+	Url       string `json:"url"`
+	Name      string `json:"name,omitempty"`
+	Width     int    `json:"width,omitempty"`
+	Height    int    `json:"height,omitempty"`
+	Blurhash  string `json:"blurhash,omitempty"`
+	Preview   *Image `json:"preview,omitempty"`
+	Duration  string `json:"duration,omitempty"`
+	Waveform  string `json:"waveform,omitempty"`
+}
+
+// Tombstone struct (see: https://www.w3.org/TR/activitystreams-vocabulary/#dfn-tombstone)
+type Tombstone struct {
+	Object
+	FormerType string     `json:"formerType,omitempty"`
+	Deleted    string     `json:"deleted,omitempty"`
+	PublicKey  *PublicKey `json:"publicKey,omitempty"`
+}
+
+// Image struct (see: https://www.w3.org/TR/activitystreams-vocabulary/#dfn-image)
+type Image struct {
+	Type      string `json:"type"`
+	MediaType string `json:"mediaType,omitempty"`
+	Url       string `json:"url"`
+}
+
+// PropertyValue struct (see: https://schema.org/PropertyValue)
+type PropertyValue struct {
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
 // Actor struct
 type Actor struct {
 	Object
@@ -150,6 +209,8 @@ type Actor struct {
 	PreferredUsername         string    `json:"preferredUsername,omitempty"`
 	ManuallyApprovesFollowers bool      `json:"manuallyApprovesFollowers"`
 	PublicKey                 PublicKey `json:"publicKey"`
+	AlsoKnownAs               []string  `json:"alsoKnownAs,omitempty"`
+	MovedTo                   string    `json:"movedTo,omitempty"`
 }
 
 // PublicKey struct
@@ -162,9 +223,9 @@ type PublicKey struct {
 // OrderedCollection struct (see: https://www.w3.org/TR/activitystreams-vocabulary/#dfn-orderedcollection)
 type OrderedCollection struct {
 	Object
-	TotalItems int    `json:"totalItems"`
-	First      string `json:"first"`
-	Last       string `json:"last"`
+	TotalItems int         `json:"totalItems"`
+	First      interface{} `json:"first"`
+	Last       string      `json:"last,omitempty"`
 }
 
 // OrderedCollectionPage struct (see: https://www.w3.org/TR/activitystreams-vocabulary/#dfn-orderedcollectionpage)
@@ -176,6 +237,44 @@ type OrderedCollectionPage struct {
 	Prev         string        `json:"prev,omitempty"`
 }
 
+// Page struct (requested page of a collection, by page number or by activity id cursor)
+type Page struct {
+	Num      int
+	Keyset   bool
+	MaxID    int
+	MinID    int
+	HasMinID bool
+	Limit    int
+	Filter   string // encoded filter parameters carried over to page links
+}
+
+// Size returns the requested page length, or length when none was requested
+func (p Page) Size(length int) int {
+	if p.Limit > 0 {
+		return p.Limit
+	}
+	return length
+}
+
+// Query returns the query string identifying the page
+func (p Page) Query() string {
+	query := "page=true"
+	if !p.Keyset {
+		query = fmt.Sprintf("page=%d", p.Num)
+	} else if p.HasMinID {
+		query = fmt.Sprintf("min_id=%d", p.MinID)
+	} else if p.MaxID > 0 {
+		query = fmt.Sprintf("max_id=%d", p.MaxID)
+	}
+	if p.Limit > 0 {
+		query += fmt.Sprintf("&limit=%d", p.Limit)
+	}
+	if p.Filter != "" {
+		query += "&" + p.Filter
+	}
+	return query
+}
+
 // PostActivityResource struct
 type PostActivityResource struct {
 	Object
@@ -209,3 +308,107 @@ type CheckResponse struct {
 	Exists      bool   `json:"exists"`
 	ActivityIRI string `json:"iri"`
 }
+
+// DomainBlock struct
+type DomainBlock struct {
+	ID      int       `json:"id"`
+	Domain  string    `json:"domain"`
+	Created time.Time `json:"created"`
+}
+
+// FederationStats struct
+type FederationStats struct {
+	InFlight  int64 `json:"inFlight"`
+	Delivered int64 `json:"delivered"`
+	Failed    int64 `json:"failed"`
+	Blocked   int64 `json:"blocked"`
+	Halted    int64 `json:"halted"`
+}
+
+// StreamEvent struct (an activity published to a stream topic, such as a user's inbox)
+type StreamEvent struct {
+	ID           int           `json:"id"`
+	Topic        string        `json:"topic"`
+	Feed         bool          `json:"feed"`
+	FeedOnly     bool          `json:"feedOnly,omitempty"` // in the feed without being delivered to the inbox
+	Activity     arb.Arb       `json:"activity,omitempty"`
+	Object       arb.Arb       `json:"object,omitempty"`
+	Tags         []string      `json:"tags,omitempty"`
+	Notification *Notification `json:"notification,omitempty"`
+}
+
+// StreamMessage struct (a message sent or received on the WebSocket streaming API)
+type StreamMessage struct {
+	Type    string      `json:"type"`
+	Stream  string      `json:"stream,omitempty"`
+	Since   int         `json:"since,omitempty"`
+	ID      int         `json:"id,omitempty"`
+	Payload interface{} `json:"payload,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// Notification struct
+type Notification struct {
+	ID       int        `json:"id"`
+	Type     string     `json:"type"`
+	Actor    string     `json:"actor"`
+	Activity string     `json:"activity"`
+	Object   string     `json:"object,omitempty"`
+	Created  time.Time  `json:"created"`
+	Read     *time.Time `json:"read,omitempty"`
+}
+
+// Hashtag struct (a followed hashtag, see: https://docs.joinmastodon.org/spec/activitypub/#Hashtag)
+type Hashtag struct {
+	Type     string     `json:"type"`
+	Href     string     `json:"href"`
+	Name     string     `json:"name"`
+	Followed *time.Time `json:"followed,omitempty"`
+}
+
+// SearchQuery struct
+type SearchQuery struct {
+	Query  string
+	Author string
+	Type   string
+	Since  *time.Time
+	Until  *time.Time
+}
+
+// ProxiedMedia struct (remote media cached by the media proxy)
+type ProxiedMedia struct {
+	URL       string    `json:"url"`
+	Name      string    `json:"name"`
+	MediaType string    `json:"mediaType"`
+	Size      int64     `json:"size"`
+	Created   time.Time `json:"created"`
+}
+
+// FilePurge struct (what a purge of unused files removed, or would remove on a dry run)
+type FilePurge struct {
+	Files  int   `json:"files"`
+	Bytes  int64 `json:"bytes"`
+	Rows   int   `json:"rows"`
+	Kept   int   `json:"kept"`
+	DryRun bool  `json:"dryRun"`
+}
+
+// PendingMedia struct (media uploaded ahead of the post it will be attached to)
+type PendingMedia struct {
+	ID         string                 `json:"mediaId"`
+	Attachment map[string]interface{} `json:"attachment"`
+	Title      string                 `json:"-"`
+	Artist     string                 `json:"-"`
+	Expires    time.Time              `json:"expires"`
+}
+
+// ChunkedUpload struct (a file uploaded over several requests, which can be resumed)
+type ChunkedUpload struct {
+	ID        string    `json:"uploadId"`
+	Name      string    `json:"name"`
+	MediaType string    `json:"mediaType,omitempty"`
+	Length    int64     `json:"length"`
+	Offset    int64     `json:"offset"`
+	Parts     []string  `json:"-"`
+	Expires   time.Time `json:"expires"`
+}
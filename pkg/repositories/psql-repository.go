@@ -2,30 +2,41 @@ package repositories
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"log"
+	"net"
+	"net/url"
+	"os"
+	"path"
 	"strings"
+	"time"
 
 	"github.com/cheebz/arb"
 	"github.com/cheebz/go-pub/pkg/cache"
 	"github.com/cheebz/go-pub/pkg/config"
+	"github.com/cheebz/go-pub/pkg/media"
 	"github.com/cheebz/go-pub/pkg/models"
+	"github.com/cheebz/go-pub/pkg/streams"
 	"github.com/cheebz/go-pub/pkg/utils"
+	"github.com/jackc/pgx/v4"
 	"github.com/jackc/pgx/v4/pgxpool"
 )
 
 type PSQLRepository struct {
-	conf  config.Configuration
-	cache cache.Cache
-	db    *pgxpool.Pool
+	conf   config.Configuration
+	cache  cache.Cache
+	broker streams.Broker
+	db     *pgxpool.Pool
 }
 
-func NewPSQLRepository(_conf config.Configuration, _cache cache.Cache) Repository {
+func NewPSQLRepository(_conf config.Configuration, _cache cache.Cache, _broker streams.Broker) Repository {
 	return &PSQLRepository{
-		conf:  _conf,
-		cache: _cache,
-		db:    connectDb(_conf.Db),
+		conf:   _conf,
+		cache:  _cache,
+		broker: _broker,
+		db:     connectDb(_conf.Db),
 	}
 }
 
@@ -45,577 +56,477 @@ func (r *PSQLRepository) Close() {
 	r.db.Close()
 }
 
-func (r *PSQLRepository) QueryUserByName(name string) (models.User, error) {
-	sql := `SELECT * FROM users
-	WHERE name = $1
-	LIMIT 1`
-
-	var user models.User
-	err := r.db.QueryRow(context.Background(), sql, name).Scan(
-		&user.ID,
-		&user.Name,
-		&user.Discoverable,
-		&user.IRI,
-	)
-	if err != nil {
-		return user, err
-	}
-	return user, nil
+type activityPage struct {
+	Activities []models.Activity
+	IDs        []int
 }
 
-func (r *PSQLRepository) CheckUser(name string) error {
-	sql := `SELECT 1 from users
-	WHERE name = $1`
-
-	var result int
-	_ = r.db.QueryRow(context.Background(), sql, name).Scan(&result)
-	if result != 1 {
-		return errors.New("user does not exist")
-	}
-	return nil
+type objectPage struct {
+	Objects []models.Object
+	IDs     []int
 }
 
-func (r *PSQLRepository) CreateUser(name string) (string, error) {
-	sql := `INSERT INTO users (name, discoverable, iri)
-	VALUES ($1, true, $2)`
-
-	iri := fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name)
-	_, err := r.db.Exec(context.Background(), sql, name, iri)
-	if err != nil {
-		return iri, err
-	}
-	return iri, nil
+// pageClause returns the ordering and limit for a page of a collection keyed by column,
+// either after an activity id cursor or at a page number offset
+func (r *PSQLRepository) pageClause(column string, page models.Page, pos int) (string, []interface{}) {
+	length := page.Size(r.conf.PageLength)
+	if !page.Keyset {
+		return fmt.Sprintf(`
+	ORDER BY %s DESC
+	OFFSET $%d
+	LIMIT $%d`, column, pos, pos+1), []interface{}{page.Num * length, length + 1}
+	}
+	if page.HasMinID {
+		return fmt.Sprintf(`
+	AND %s > $%d
+	ORDER BY %s ASC
+	LIMIT $%d`, column, pos, column, pos+1), []interface{}{page.MinID, length + 1}
+	}
+	if page.MaxID > 0 {
+		return fmt.Sprintf(`
+	AND %s < $%d
+	ORDER BY %s DESC
+	LIMIT $%d`, column, pos, column, pos+1), []interface{}{page.MaxID, length + 1}
+	}
+	return fmt.Sprintf(`
+	ORDER BY %s DESC
+	LIMIT $%d`, column, pos), []interface{}{length + 1}
 }
 
-func (r *PSQLRepository) QueryFeedTotalItemsByUserName(name string) (int, error) {
-	var count int
-	_, err := r.cache.Get(fmt.Sprintf("feed-totalItems-%s", name), &count)
-	if err == nil {
-		return count, nil
+// reversePage puts min_id pages, which are queried oldest first, back in newest first order
+func reversePage(page models.Page, n int, swap func(i, j int)) {
+	if !page.Keyset || !page.HasMinID {
+		return
 	}
-	log.Println(fmt.Sprintf("no cached %s", fmt.Sprintf("feed-totalItems-%s", name)))
+	for i, j := 0, n-1; i < j; i, j = i+1, j-1 {
+		swap(i, j)
+	}
+}
 
-	sql := `SELECT COUNT(act.*)
-	FROM activities as act
-	JOIN activities_to AS act_to ON act_to.activity_id = act.id
-	INNER JOIN (
-		SELECT obj.iri
-		FROM activities AS act
-		JOIN objects AS obj ON obj.id = act.object_id
-		WHERE act.type = 'Follow'
-		AND act.iri NOT IN (
-			SELECT obj.iri FROM activities AS act
-			JOIN objects AS obj ON obj.id = act.object_id
-			WHERE act.type = 'Undo'
+// feedClause selects what a user ($1 iri, $2 name) receives from the actors they follow,
+// along with public posts carrying a hashtag they follow
+var feedClause = `
+	WHERE act.type IN ('Create', 'Announce')
+	AND (
+		act.id IN (
+			SELECT act_to.activity_id
+			FROM activities_to AS act_to
+			JOIN activities AS to_act ON to_act.id = act_to.activity_id
+			INNER JOIN (
+				SELECT obj.iri
+				FROM activities AS act
+				JOIN objects AS obj ON obj.id = act.object_id
+				WHERE act.type = 'Follow'
+				AND act.iri NOT IN (
+					SELECT obj.iri FROM activities AS act
+					JOIN objects AS obj ON obj.id = act.object_id
+					WHERE act.type = 'Undo'
+				)
+				AND act.actor = $1
+			) as following ON following.iri = to_act.actor
+			WHERE act_to.iri = $1
 		)
-		AND act.actor = $1
-	) as following ON following.iri = act.actor
-	WHERE act_to.iri = $1
-	AND ACT.type IN ('Create', 'Announce')`
+		OR (act.type = 'Create' AND act.object_id IN (
+			SELECT obj_tag.object_id
+			FROM object_hashtags AS obj_tag
+			JOIN objects AS obj ON obj.id = obj_tag.object_id
+			JOIN followed_hashtags AS fol_tag ON fol_tag.hashtag_id = obj_tag.hashtag_id
+			WHERE obj.public
+			AND fol_tag.user_id = (SELECT id FROM users WHERE name = $2)
+		))
+	)`
+
+var userColumns = `id, name, discoverable, iri, suspended, deleted, also_known_as, moved_to,
+	display_name, summary, icon, icon_media_type, image, image_media_type, actor_type`
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
 
-	err = r.db.QueryRow(context.Background(), sql,
-		fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
-	).Scan(&count)
+func scanUser(row scanner) (models.User, error) {
+	var user models.User
+	err := row.Scan(
+		&user.ID,
+		&user.Name,
+		&user.Discoverable,
+		&user.IRI,
+		&user.Suspended,
+		&user.Deleted,
+		&user.AlsoKnownAs,
+		&user.MovedTo,
+		&user.DisplayName,
+		&user.Summary,
+		&user.Icon,
+		&user.IconMediaType,
+		&user.Image,
+		&user.ImageMediaType,
+		&user.ActorType,
+	)
 	if err != nil {
-		return count, err
+		return user, err
 	}
+	return user, nil
+}
 
-	err = r.cache.Set(fmt.Sprintf("feed-totalItems-%s", name), count)
-	if err != nil {
-		log.Println(fmt.Sprintf("error setting cache %s", fmt.Sprintf("feed-totalItems-%s", name)))
-	}
+func (r *PSQLRepository) QueryUserByName(name string) (models.User, error) {
+	sql := `SELECT ` + userColumns + ` FROM users
+	WHERE name = $1
+	LIMIT 1`
 
-	return count, nil
+	return scanUser(r.db.QueryRow(context.Background(), sql, name))
 }
 
-func (r *PSQLRepository) QueryFeedByUserName(name string, pageNum int) ([]models.Activity, error) {
-	var activities []models.Activity
-	r.cache.Get(fmt.Sprintf("feed-%s-%d", name, pageNum), &activities)
-	if activities != nil {
-		return activities, nil
-	}
-	log.Println(fmt.Sprintf("no cached %s", fmt.Sprintf("feed-%s-%d", name, pageNum)))
-
-	sql := `SELECT act.*
-	FROM activities as act
-	JOIN activities_to AS act_to ON act_to.activity_id = act.id
-	INNER JOIN (
-		SELECT obj.iri
-		FROM activities AS act
-		JOIN objects AS obj ON obj.id = act.object_id
-		WHERE act.type = 'Follow'
-		AND act.iri NOT IN (
-			SELECT obj.iri FROM activities AS act
-			JOIN objects AS obj ON obj.id = act.object_id
-			WHERE act.type = 'Undo'
-		)
-		AND act.actor = $1
-	) as following ON following.iri = act.actor
-	WHERE act_to.iri = $1
-	AND ACT.type IN ('Create', 'Announce')
-	ORDER BY id DESC
-	OFFSET $2
-	LIMIT $3`
+func (r *PSQLRepository) QueryUsers() ([]models.User, error) {
+	sql := `SELECT ` + userColumns + ` FROM users
+	ORDER BY id`
 
-	rows, err := r.db.Query(context.Background(), sql,
-		fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
-		pageNum*r.conf.PageLength,
-		r.conf.PageLength+1,
-	)
+	rows, err := r.db.Query(context.Background(), sql)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
+	users := make([]models.User, 0)
 	for rows.Next() {
-		var activity_id int
-		var object_id int
-		activity := models.NewActivity()
-		err = rows.Scan(
-			&activity_id,
-			&activity.Type,
-			&activity.Actor,
-			&object_id,
-			&activity.Id,
-		)
-		if err != nil {
-			return activities, err
-		}
-		object_iri, err := r.queryObjectIRIById(object_id)
-		if err != nil {
-			return activities, err
-		}
-		object, err := r.queryObjectByIRI(object_iri)
-		if err != nil {
-			activity.ChildObject = object_iri
-
-		} else {
-			activity.ChildObject = object
-		}
-		activity.To, err = r.queryToByActivityId(activity_id)
+		user, err := scanUser(rows)
 		if err != nil {
-			return activities, err
+			return users, err
 		}
-		activities = append(activities, activity)
+		users = append(users, user)
 	}
 	err = rows.Err()
 	if err != nil {
-		return activities, err
+		return users, err
 	}
+	return users, nil
+}
 
-	err = r.cache.Set(fmt.Sprintf("feed-%s-%d", name, pageNum), activities)
-	if err != nil {
-		log.Println(fmt.Sprintf("error setting cache %s", fmt.Sprintf("feed-%s-%d", name, pageNum)))
-	}
+func (r *PSQLRepository) UpdateUserSuspended(name string, suspended bool) (models.User, error) {
+	sql := `UPDATE users
+	SET suspended = $2
+	WHERE name = $1
+	RETURNING ` + userColumns
 
-	return activities, nil
+	return scanUser(r.db.QueryRow(context.Background(), sql, name, suspended))
 }
 
-func (r *PSQLRepository) QueryInboxTotalItemsByUserName(name string) (int, error) {
-	var count int
-	_, err := r.cache.Get(fmt.Sprintf("inbox-totalItems-%s", name), &count)
-	if err == nil {
-		return count, nil
-	}
-	log.Println(fmt.Sprintf("no cached %s", fmt.Sprintf("inbox-totalItems-%s", name)))
+func (r *PSQLRepository) ToggleUserDiscoverable(name string) (models.User, error) {
+	sql := `UPDATE users
+	SET discoverable = NOT discoverable
+	WHERE name = $1
+	RETURNING ` + userColumns
 
-	sql := `SELECT COUNT(act.*)
-	FROM activities as act
-	JOIN activities_to AS act_to ON act_to.activity_id = act.id
-	WHERE act_to.iri = $1`
+	return scanUser(r.db.QueryRow(context.Background(), sql, name))
+}
 
-	err = r.db.QueryRow(context.Background(), sql,
-		fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
-	).Scan(&count)
-	if err != nil {
-		return count, err
-	}
+func (r *PSQLRepository) UpdateUserAlsoKnownAs(name string, alsoKnownAs []string) (models.User, error) {
+	sql := `UPDATE users
+	SET also_known_as = $2
+	WHERE name = $1
+	RETURNING ` + userColumns
 
-	err = r.cache.Set(fmt.Sprintf("inbox-totalItems-%s", name), count)
-	if err != nil {
-		log.Println(fmt.Sprintf("error setting cache %s", fmt.Sprintf("inbox-totalItems-%s", name)))
-	}
+	return scanUser(r.db.QueryRow(context.Background(), sql, name, alsoKnownAs))
+}
 
-	return count, nil
+func (r *PSQLRepository) UpdateUserMovedTo(name string, movedTo string) (models.User, error) {
+	sql := `UPDATE users
+	SET moved_to = $2
+	WHERE name = $1
+	RETURNING ` + userColumns
+
+	return scanUser(r.db.QueryRow(context.Background(), sql, name, movedTo))
 }
 
-func (r *PSQLRepository) QueryInboxByUserName(name string, pageNum int) ([]models.Activity, error) {
-	var activities []models.Activity
-	r.cache.Get(fmt.Sprintf("inbox-%s-%d", name, pageNum), &activities)
-	if activities != nil {
-		return activities, nil
-	}
-	log.Println(fmt.Sprintf("no cached %s", fmt.Sprintf("inbox-%s-%d", name, pageNum)))
+func (r *PSQLRepository) UpdateUserProfile(user models.User) (models.User, error) {
+	sql := `UPDATE users
+	SET display_name = $2,
+	summary = $3,
+	icon = $4,
+	icon_media_type = $5,
+	image = $6,
+	image_media_type = $7
+	WHERE name = $1
+	RETURNING ` + userColumns
+
+	return scanUser(r.db.QueryRow(context.Background(), sql,
+		user.Name,
+		user.DisplayName,
+		user.Summary,
+		user.Icon,
+		user.IconMediaType,
+		user.Image,
+		user.ImageMediaType,
+	))
+}
 
-	sql := `SELECT act.*
-	FROM activities as act
-	JOIN activities_to AS act_to ON act_to.activity_id = act.id
-	WHERE act_to.iri = $1
-	ORDER BY id DESC
-	OFFSET $2
-	LIMIT $3`
+func (r *PSQLRepository) QueryUserFieldsByUserName(name string) ([]models.ProfileField, error) {
+	sql := `SELECT f.id, f.name, f.value, f.verified
+	FROM user_fields AS f
+	JOIN users AS usr ON usr.id = f.user_id
+	WHERE usr.name = $1
+	ORDER BY f.position`
 
-	rows, err := r.db.Query(context.Background(), sql,
-		fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
-		pageNum*r.conf.PageLength,
-		r.conf.PageLength+1,
-	)
+	rows, err := r.db.Query(context.Background(), sql, name)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
+	fields := make([]models.ProfileField, 0)
 	for rows.Next() {
-		var activity_id int
-		var object_id int
-		activity := models.NewActivity()
+		var field models.ProfileField
 		err = rows.Scan(
-			&activity_id,
-			&activity.Type,
-			&activity.Actor,
-			&object_id,
-			&activity.Id,
+			&field.ID,
+			&field.Name,
+			&field.Value,
+			&field.Verified,
 		)
 		if err != nil {
-			return activities, err
-		}
-		object_iri, err := r.queryObjectIRIById(object_id)
-		if err != nil {
-			return activities, err
-		}
-		object, err := r.queryObjectByIRI(object_iri)
-		if err != nil {
-			activity.ChildObject = object_iri
-
-		} else {
-			activity.ChildObject = object
-		}
-		activity.To, err = r.queryToByActivityId(activity_id)
-		if err != nil {
-			return activities, err
+			return fields, err
 		}
-		activities = append(activities, activity)
+		fields = append(fields, field)
 	}
 	err = rows.Err()
 	if err != nil {
-		return activities, err
-	}
-
-	err = r.cache.Set(fmt.Sprintf("inbox-%s-%d", name, pageNum), activities)
-	if err != nil {
-		log.Println(fmt.Sprintf("error setting cache %s", fmt.Sprintf("inbox-%s-%d", name, pageNum)))
+		return fields, err
 	}
-
-	return activities, nil
+	return fields, nil
 }
 
-func (r *PSQLRepository) QueryOutboxTotalItemsByUserName(name string) (int, error) {
-	var count int
-	_, err := r.cache.Get(fmt.Sprintf("outbox-totalItems-%s", name), &count)
-	if err == nil {
-		return count, nil
-	}
-	log.Println(fmt.Sprintf("no cached %s", fmt.Sprintf("outbox-totalItems-%s", name)))
-
-	sql := `SELECT COUNT(*) FROM activities
-	WHERE actor = $1`
-
-	err = r.db.QueryRow(context.Background(), sql,
-		fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
-	).Scan(&count)
-	if err != nil {
-		return count, err
-	}
-
-	err = r.cache.Set(fmt.Sprintf("outbox-totalItems-%s", name), count)
+func (r *PSQLRepository) ReplaceUserFields(name string, fields []models.ProfileField) ([]models.ProfileField, error) {
+	ctx := context.Background()
+	tx, err := r.db.Begin(ctx)
 	if err != nil {
-		log.Println(fmt.Sprintf("error setting cache %s", fmt.Sprintf("outbox-totalItems-%s", name)))
-	}
-
-	return count, nil
-}
-
-func (r *PSQLRepository) QueryOutboxByUserName(name string, pageNum int) ([]models.Activity, error) {
-	var activities []models.Activity
-	_, err := r.cache.Get(fmt.Sprintf("outbox-%s-%d", name, pageNum), &activities)
-	if err == nil {
-		return activities, nil
+		return nil, err
 	}
-	log.Println(fmt.Sprintf("no cached %s", fmt.Sprintf("outbox-%s-%d", name, pageNum)))
-
-	sql := `SELECT *
-	FROM activities
-	WHERE actor = $1
-	ORDER BY id DESC
-	OFFSET $2
-	LIMIT $3`
-
-	rows, err := r.db.Query(context.Background(), sql,
-		fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
-		pageNum*r.conf.PageLength,
-		r.conf.PageLength+1,
-	)
+	sql := `DELETE FROM user_fields
+	WHERE user_id = (SELECT id FROM users WHERE name = $1)`
+	_, err = tx.Exec(ctx, sql, name)
 	if err != nil {
+		tx.Rollback(ctx)
 		return nil, err
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var activity_id int
-		var object_id int
-		activity := models.NewActivity()
-		err = rows.Scan(
-			&activity_id,
-			&activity.Type,
-			&activity.Actor,
-			&object_id,
-			&activity.Id,
-		)
-		if err != nil {
-			return activities, err
-		}
-		object_iri, err := r.queryObjectIRIById(object_id)
-		if err != nil {
-			return activities, err
-		}
-		object, err := r.queryObjectByIRI(object_iri)
-		if err != nil {
-			activity.ChildObject = object_iri
-
-		} else {
-			activity.ChildObject = object
-		}
-		activity.To, err = r.queryToByActivityId(activity_id)
+	saved := make([]models.ProfileField, 0)
+	for i, field := range fields {
+		sql = `INSERT INTO user_fields (user_id, position, name, value, verified)
+		VALUES ((SELECT id FROM users WHERE name = $1), $2, $3, $4, $5) RETURNING id;`
+		err = tx.QueryRow(ctx, sql, name, i, field.Name, field.Value, field.Verified).Scan(&field.ID)
 		if err != nil {
-			return activities, err
+			tx.Rollback(ctx)
+			return nil, err
 		}
-		activities = append(activities, activity)
+		saved = append(saved, field)
 	}
-	err = rows.Err()
+	err = tx.Commit(ctx)
 	if err != nil {
-		return activities, err
+		return nil, err
 	}
+	return saved, nil
+}
 
-	err = r.cache.Set(fmt.Sprintf("outbox-%s-%d", name, pageNum), activities)
+func (r *PSQLRepository) UpdateUserFieldVerified(id int, verified *time.Time) error {
+	sql := `UPDATE user_fields
+	SET verified = $2
+	WHERE id = $1`
+
+	_, err := r.db.Exec(context.Background(), sql, id, verified)
 	if err != nil {
-		log.Println(fmt.Sprintf("error setting cache %s", fmt.Sprintf("outbox-%s-%d", name, pageNum)))
+		return err
 	}
-
-	return activities, nil
+	return nil
 }
 
-func (r *PSQLRepository) queryObjectIRIById(object_id int) (string, error) {
-	sql := `SELECT iri
-	FROM objects WHERE id = $1;`
-	var iri string
-	err := r.db.QueryRow(context.Background(), sql, object_id).Scan(
-		&iri,
-	)
+// Tombstone a user and their objects, recording the Delete activity
+func (r *PSQLRepository) DeleteUser(activityArb arb.Arb, name string) (arb.Arb, error) {
+	ctx := context.Background()
+	tx, err := r.db.Begin(ctx)
 	if err != nil {
-		return iri, err
+		return activityArb, err
 	}
-	return iri, nil
-}
-
-func (r *PSQLRepository) queryObjectByIRI(iri string) (models.Object, error) {
-	sql := `SELECT type, iri, content, attributed_to, in_reply_to, name
-	FROM objects WHERE iri = $1;`
-	object := models.NewObject()
-	err := r.db.QueryRow(context.Background(), sql, iri).Scan(
-		&object.Type,
-		&object.Id,
-		&object.Content,
-		&object.AttributedTo,
-		&object.InReplyTo,
-		&object.Name,
-	)
+	iri := fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name)
+	sql := `UPDATE users
+	SET deleted = CURRENT_TIMESTAMP,
+	discoverable = false,
+	display_name = '',
+	summary = '',
+	icon = '',
+	icon_media_type = '',
+	image = '',
+	image_media_type = ''
+	WHERE name = $1
+	AND deleted IS NULL`
+	tag, err := tx.Exec(ctx, sql, name)
 	if err != nil {
-		return object, err
+		tx.Rollback(ctx)
+		return activityArb, err
 	}
-
-	sql = `SELECT id, type, href, media_type
-	FROM object_files
-	WHERE object_id = (SELECT id FROM object WHERE iri = $1)`
-	rows, err := r.db.Query(context.Background(), sql, iri)
+	if tag.RowsAffected() == 0 {
+		tx.Rollback(ctx)
+		return activityArb, errors.New("user does not exist")
+	}
+	sql = `DELETE FROM user_fields
+	WHERE user_id = (SELECT id FROM users WHERE name = $1)`
+	_, err = tx.Exec(ctx, sql, name)
 	if err != nil {
-		return object, err
+		tx.Rollback(ctx)
+		return activityArb, err
 	}
-	defer rows.Close()
-	var links []models.Link
-	for rows.Next() {
-		link := models.NewLink()
-		var link_id int
-		err = rows.Scan(
-			&link_id,
-			&link.Type,
-			&link.Href,
-			&link.MediaType,
-		)
-		if err != nil {
-			return object, err
-		}
-		link.Id = fmt.Sprintf("%s://%s/%s/%d", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Links, link_id)
-		links = append(links, link)
+	// collect what is cached of the user's objects before they are tombstoned
+	cacheKeys, hashtags, err := r.userCacheKeys(ctx, tx, iri, name)
+	if err != nil {
+		tx.Rollback(ctx)
+		return activityArb, err
 	}
-	if links != nil {
-		object.Url = links
+	sql = `DELETE FROM object_files
+	WHERE object_id IN (
+		SELECT id FROM objects WHERE attributed_to = $1
+	)`
+	_, err = tx.Exec(ctx, sql, iri)
+	if err != nil {
+		tx.Rollback(ctx)
+		return activityArb, err
 	}
-	err = rows.Err()
+	sql = `DELETE FROM object_hashtags
+	WHERE object_id IN (
+		SELECT id FROM objects WHERE attributed_to = $1
+	)`
+	_, err = tx.Exec(ctx, sql, iri)
 	if err != nil {
-		return object, err
+		tx.Rollback(ctx)
+		return activityArb, err
 	}
-
-	return object, nil
-}
-
-func (r *PSQLRepository) queryLinksByObjectID(id int) ([]models.Link, error) {
-	var links []models.Link
-	sql := `SELECT id, type, href, media_type
-	FROM object_files
-	WHERE object_id = $1`
-	rows, err := r.db.Query(context.Background(), sql, id)
+	sql = `UPDATE objects
+	SET type = 'Tombstone',
+	content = NULL,
+	name = NULL,
+	tag = NULL,
+	attachment = NULL,
+	public = false
+	WHERE attributed_to = $1`
+	_, err = tx.Exec(ctx, sql, iri)
 	if err != nil {
-		return links, err
+		tx.Rollback(ctx)
+		return activityArb, err
+	}
+	var object_id int
+	sql = `SELECT id
+	FROM objects WHERE iri = $1`
+	err = tx.QueryRow(ctx, sql, iri).Scan(&object_id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		sql = `INSERT INTO objects (iri, type)
+		VALUES ($1, 'Tombstone') RETURNING id;`
+		err = tx.QueryRow(ctx, sql, iri).Scan(&object_id)
 	}
-	defer rows.Close()
-	err = rows.Err()
 	if err != nil {
-		return links, err
+		tx.Rollback(ctx)
+		return activityArb, err
 	}
-	for rows.Next() {
-		link := models.NewLink()
-		var link_id int
-		err = rows.Scan(
-			&link_id,
-			&link.Type,
-			&link.Href,
-			&link.MediaType,
-		)
-		if err != nil {
-			return links, err
-		}
-		link.Id = fmt.Sprintf("%s://%s/%s/%d", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Links, link_id)
-		links = append(links, link)
+	sql = `INSERT INTO activities (type, actor, object_id)
+	VALUES ($1, $2, $3) RETURNING id;`
+	var activity_id int
+	err = tx.QueryRow(ctx, sql, activityArb["type"], iri, object_id).Scan(&activity_id)
+	if err != nil {
+		tx.Rollback(ctx)
+		return activityArb, err
 	}
-	return links, nil
-}
-
-func (r *PSQLRepository) queryToByActivityId(activity_id int) ([]string, error) {
-	sql := `SELECT iri
-	FROM activities_to
-	WHERE activity_id = $1`
-
-	rows, err := r.db.Query(context.Background(), sql, activity_id)
+	activityArb["id"] = fmt.Sprintf("%s://%s/%s/%d", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Activities, activity_id)
+	sql = `UPDATE activities
+	SET iri = $1
+	WHERE id = $2;`
+	_, err = tx.Exec(ctx, sql, activityArb["id"], activity_id)
 	if err != nil {
-		return nil, err
+		tx.Rollback(ctx)
+		return activityArb, err
 	}
-	defer rows.Close()
-	var tos []string
-	for rows.Next() {
-		var to string
-		err = rows.Scan(
-			&to,
-		)
-		if err != nil {
-			return tos, err
-		}
-		tos = append(tos, to)
+	err = tx.Commit(ctx)
+	if err != nil {
+		return activityArb, err
 	}
-	err = rows.Err()
+	err = r.cache.Del(cacheKeys...)
 	if err != nil {
-		return tos, err
+		log.Println(fmt.Sprintf("error deleting cache for deleted user %s", name))
 	}
-	return tos, nil
+	r.deleteHashtagCaches(hashtags)
+	return activityArb, nil
 }
 
-func (r *PSQLRepository) QueryFollowingTotalItemsByUserName(name string) (int, error) {
-	sql := `SELECT COUNT(*)
-	FROM activities
-	WHERE type = 'Follow'
-	AND iri NOT IN (
-		SELECT obj.iri FROM activities AS act
-		JOIN objects AS obj ON obj.id = act.object_id
-		WHERE act.type = 'Undo'
-	)
-	AND actor = $1`
-
-	var count int
-	err := r.db.QueryRow(context.Background(), sql,
-		fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
-	).Scan(
-		&count,
-	)
+// userCacheKeys returns the cache keys of a user's (iri) objects, their activities and the
+// collections showing them: the user's own and the inboxes and feeds of the local users who
+// received them or follow the user. The hashtags of the objects are returned as well.
+func (r *PSQLRepository) userCacheKeys(ctx context.Context, tx pgx.Tx, iri string, name string) ([]string, []string, error) {
+	keys := []string{
+		fmt.Sprintf("outbox-%s-*", name),
+		fmt.Sprintf("outbox-totalItems-%s", name),
+		fmt.Sprintf("inbox-%s-*", name),
+		fmt.Sprintf("inbox-totalItems-%s", name),
+		fmt.Sprintf("feed-%s-*", name),
+		fmt.Sprintf("feed-totalItems-%s", name),
+	}
+	sql := `SELECT 'object-' || id FROM objects WHERE attributed_to = $1
+	UNION
+	SELECT 'activity-' || act.id FROM activities AS act
+	LEFT JOIN objects AS obj ON obj.id = act.object_id
+	WHERE act.actor = $1 OR obj.attributed_to = $1`
+	objectKeys, err := queryStrings(ctx, tx, sql, iri)
 	if err != nil {
-		return count, err
+		return nil, nil, err
 	}
-	return count, nil
-}
-
-func (r *PSQLRepository) QueryFollowingByUserName(name string, pageNum int) ([]string, error) {
-	sql := `SELECT obj.iri
+	keys = append(keys, objectKeys...)
+	sql = `SELECT usr.name
+	FROM activities_to AS act_to
+	JOIN activities AS act ON act.id = act_to.activity_id
+	JOIN users AS usr ON usr.iri = act_to.iri
+	WHERE act.actor = $1
+	UNION
+	SELECT usr.name
 	FROM activities AS act
 	JOIN objects AS obj ON obj.id = act.object_id
+	JOIN users AS usr ON usr.iri = act.actor
 	WHERE act.type = 'Follow'
-	AND act.iri NOT IN (
-		SELECT obj.iri FROM activities AS act
-		JOIN objects AS obj ON obj.id = act.object_id
-		WHERE act.type = 'Undo'
-	)
-	AND act.actor = $1
-	ORDER BY act.id DESC
-	OFFSET $2
-	LIMIT $3`
-
-	rows, err := r.db.Query(context.Background(), sql,
-		fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
-		pageNum*r.conf.PageLength,
-		r.conf.PageLength+1,
-	)
+	AND obj.iri = $1`
+	readers, err := queryStrings(ctx, tx, sql, iri)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	defer rows.Close()
-	var actors []string
-	for rows.Next() {
-		var actor string
-		err = rows.Scan(
-			&actor,
+	for _, reader := range readers {
+		keys = append(keys,
+			fmt.Sprintf("inbox-%s-*", reader),
+			fmt.Sprintf("inbox-totalItems-%s", reader),
+			fmt.Sprintf("feed-%s-*", reader),
+			fmt.Sprintf("feed-totalItems-%s", reader),
 		)
-		if err != nil {
-			return actors, err
-		}
-		actors = append(actors, actor)
 	}
-	err = rows.Err()
+	sql = `SELECT DISTINCT h.name
+	FROM hashtags AS h
+	JOIN object_hashtags AS obj_tag ON obj_tag.hashtag_id = h.id
+	JOIN objects AS obj ON obj.id = obj_tag.object_id
+	WHERE obj.attributed_to = $1`
+	hashtags, err := queryStrings(ctx, tx, sql, iri)
 	if err != nil {
-		return actors, err
+		return nil, nil, err
 	}
-	return actors, nil
+	return keys, hashtags, nil
 }
 
-func (r *PSQLRepository) QueryFollowersTotalItemsByUserName(name string) (int, error) {
-	sql := `SELECT COUNT(*)
-	FROM activities AS act
-	JOIN objects AS obj ON obj.id = act.object_id
-	WHERE act.type = 'Follow'
-	AND act.iri NOT IN (
-		SELECT obj.iri FROM activities AS act
-		JOIN objects AS obj ON obj.id = act.object_id
-		WHERE act.type = 'Undo'
-	)
-	AND obj.iri = $1`
-
-	var count int
-	err := r.db.QueryRow(context.Background(), sql,
-		fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
-	).Scan(
-		&count,
-	)
+// queryStrings reads a single text column from each row
+func queryStrings(ctx context.Context, tx pgx.Tx, sql string, args ...interface{}) ([]string, error) {
+	rows, err := tx.Query(ctx, sql, args...)
 	if err != nil {
-		return count, err
+		return nil, err
 	}
-	return count, nil
+	defer rows.Close()
+	var values []string
+	for rows.Next() {
+		var value string
+		err = rows.Scan(&value)
+		if err != nil {
+			return values, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
 }
 
-func (r *PSQLRepository) QueryFollowersByUserName(name string, pageNum int) ([]string, error) {
-	sql := `SELECT act.actor
+func (r *PSQLRepository) QueryFollowerIRIsByUserName(name string) ([]string, error) {
+	sql := `SELECT DISTINCT act.actor
 	FROM activities AS act
 	JOIN objects AS obj ON obj.id = act.object_id
 	WHERE act.type = 'Follow'
@@ -624,15 +535,10 @@ func (r *PSQLRepository) QueryFollowersByUserName(name string, pageNum int) ([]s
 		JOIN objects AS obj ON obj.id = act.object_id
 		WHERE act.type = 'Undo'
 	)
-	AND obj.iri = $1
-	ORDER BY act.id DESC
-	OFFSET $2
-	LIMIT $3`
+	AND obj.iri = $1`
 
 	rows, err := r.db.Query(context.Background(), sql,
 		fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
-		pageNum*r.conf.PageLength,
-		r.conf.PageLength+1,
 	)
 	if err != nil {
 		return nil, err
@@ -656,734 +562,2632 @@ func (r *PSQLRepository) QueryFollowersByUserName(name string, pageNum int) ([]s
 	return actors, nil
 }
 
-func (r *PSQLRepository) QueryLikedTotalItemsByUserName(name string) (int, error) {
-	sql := `SELECT COUNT(*)
-	FROM activities
-	WHERE type = 'Like'
-	AND iri NOT IN (
-		SELECT obj.iri FROM activities AS act
-		JOIN objects AS obj ON obj.id = act.object_id
-		WHERE act.type = 'Undo'
-	)
-	AND actor = $1`
-
-	var count int
-	err := r.db.QueryRow(context.Background(), sql,
-		fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
-	).Scan(
-		&count,
-	)
-	if err != nil {
-		return count, err
-	}
-	return count, nil
-}
-
-// func (r *PSQLRepository) QueryLikedByUserName(name string, pageNum int) ([]models.Object, error) {
-func (r *PSQLRepository) QueryLikedByUserName(name string, pageNum int) ([]string, error) {
-	// sql := `SELECT obj.type, obj.iri, obj.content, obj.attributed_to, obj.in_reply_to
-	sql := `SELECT obj.iri
-	FROM objects AS obj
-	JOIN activities AS act ON act.object_id = obj.id
-	WHERE act.type = 'Like'
-	AND act.iri NOT IN (
-		SELECT obj.iri FROM activities AS act
-		JOIN objects AS obj ON obj.id = act.object_id
-		WHERE act.type = 'Undo'
-	)
-	AND act.actor = $1
-	ORDER BY act.id DESC
-	OFFSET $2
-	LIMIT $3`
+// GetUserFilesByUserName returns the stored files of a user's objects, with their previews and waveforms
+func (r *PSQLRepository) GetUserFilesByUserName(name string) ([]string, error) {
+	sql := `SELECT f.href, f.preview, f.waveform
+	FROM object_files AS f
+	JOIN objects AS obj ON obj.id = f.object_id
+	WHERE obj.attributed_to = $1`
 
 	rows, err := r.db.Query(context.Background(), sql,
 		fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
-		pageNum*r.conf.PageLength,
-		r.conf.PageLength+1,
 	)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
-	// var objects []models.Object
-	var objects []string
+	return scanFileHrefs(rows)
+}
+
+// scanFileHrefs reads rows of file, preview and waveform hrefs, skipping those that are empty
+func scanFileHrefs(rows pgx.Rows) ([]string, error) {
+	var hrefs []string
 	for rows.Next() {
-		// object := models.NewObject()
-		var object string
-		// err = rows.Scan(
-		// 	&object.Type,
-		// 	&object.Id,
-		// 	&object.Content,
-		// 	&object.AttributedTo,
-		// 	&object.InReplyTo,
-		// )
-		err = rows.Scan(
-			&object,
-		)
+		var href, preview, waveform string
+		err := rows.Scan(&href, &preview, &waveform)
 		if err != nil {
-			return objects, err
+			return hrefs, err
+		}
+		for _, h := range []string{href, preview, waveform} {
+			if h != "" {
+				hrefs = append(hrefs, h)
+			}
 		}
-		objects = append(objects, object)
 	}
-	err = rows.Err()
+	err := rows.Err()
 	if err != nil {
-		return objects, err
+		return hrefs, err
 	}
-	return objects, nil
+	return hrefs, nil
 }
 
-func (r *PSQLRepository) QueryActivity(id int) (models.Activity, error) {
-	activity := models.NewActivity()
-	_, err := r.cache.Get(fmt.Sprintf("activity-%d", id), &activity)
-	if err == nil {
-		return activity, nil
-	}
-	log.Println(fmt.Sprintf("no cached %s", fmt.Sprintf("activity-%d", id)))
+func (r *PSQLRepository) CheckUser(name string) error {
+	sql := `SELECT 1 from users
+	WHERE name = $1`
+
+	var result int
+	_ = r.db.QueryRow(context.Background(), sql, name).Scan(&result)
+	if result != 1 {
+		return errors.New("user does not exist")
+	}
+	return nil
+}
+
+func (r *PSQLRepository) CreateUser(name string, actorType string) (string, error) {
+	sql := `INSERT INTO users (name, discoverable, iri, actor_type)
+	VALUES ($1, true, $2, $3)`
+
+	iri := fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name)
+	_, err := r.db.Exec(context.Background(), sql, name, iri, actorType)
+	if err != nil {
+		return iri, err
+	}
+	return iri, nil
+}
+
+func (r *PSQLRepository) UpdateUserActorType(name string, actorType string) (models.User, error) {
+	sql := `UPDATE users
+	SET actor_type = $2
+	WHERE name = $1
+	RETURNING ` + userColumns
+
+	return scanUser(r.db.QueryRow(context.Background(), sql, name, actorType))
+}
+
+func (r *PSQLRepository) QueryFeedTotalItemsByUserName(name string) (int, error) {
+	var count int
+	_, err := r.cache.Get(fmt.Sprintf("feed-totalItems-%s", name), &count)
+	if err == nil {
+		return count, nil
+	}
+	log.Println(fmt.Sprintf("no cached %s", fmt.Sprintf("feed-totalItems-%s", name)))
+
+	sql := `SELECT COUNT(act.*)
+	FROM activities as act` + feedClause
+
+	err = r.db.QueryRow(context.Background(), sql,
+		fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
+		name,
+	).Scan(&count)
+	if err != nil {
+		return count, err
+	}
+
+	err = r.cache.Set(fmt.Sprintf("feed-totalItems-%s", name), count)
+	if err != nil {
+		log.Println(fmt.Sprintf("error setting cache %s", fmt.Sprintf("feed-totalItems-%s", name)))
+	}
+
+	return count, nil
+}
+
+func (r *PSQLRepository) QueryFeedByUserName(name string, page models.Page) ([]models.Activity, []int, error) {
+	var cached activityPage
+	r.cache.Get(fmt.Sprintf("feed-%s-%s", name, page.Query()), &cached)
+	if cached.Activities != nil {
+		return cached.Activities, cached.IDs, nil
+	}
+	log.Println(fmt.Sprintf("no cached %s", fmt.Sprintf("feed-%s-%s", name, page.Query())))
+
+	sql := `SELECT act.*
+	FROM activities as act` + feedClause
+
+	clause, args := r.pageClause("act.id", page, 3)
+	rows, err := r.db.Query(context.Background(), sql+clause,
+		append([]interface{}{fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name), name}, args...)...,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var activities []models.Activity
+	var ids []int
+	for rows.Next() {
+		var activity_id int
+		var object_id int
+		activity := models.NewActivity()
+		err = rows.Scan(
+			&activity_id,
+			&activity.Type,
+			&activity.Actor,
+			&object_id,
+			&activity.Id,
+		)
+		if err != nil {
+			return activities, ids, err
+		}
+		object_iri, err := r.queryObjectIRIById(object_id)
+		if err != nil {
+			return activities, ids, err
+		}
+		object, err := r.queryObjectByIRI(object_iri)
+		if err != nil {
+			activity.ChildObject = object_iri
+
+		} else {
+			activity.ChildObject = object
+		}
+		activity.To, err = r.queryToByActivityId(activity_id)
+		if err != nil {
+			return activities, ids, err
+		}
+		activities = append(activities, activity)
+		ids = append(ids, activity_id)
+	}
+	err = rows.Err()
+	if err != nil {
+		return activities, ids, err
+	}
+	reversePage(page, len(activities), func(i, j int) {
+		activities[i], activities[j] = activities[j], activities[i]
+		ids[i], ids[j] = ids[j], ids[i]
+	})
+
+	err = r.cache.Set(fmt.Sprintf("feed-%s-%s", name, page.Query()), activityPage{Activities: activities, IDs: ids})
+	if err != nil {
+		log.Println(fmt.Sprintf("error setting cache %s", fmt.Sprintf("feed-%s-%s", name, page.Query())))
+	}
+
+	return activities, ids, nil
+}
+
+func (r *PSQLRepository) QueryInboxTotalItemsByUserName(name string) (int, error) {
+	var count int
+	_, err := r.cache.Get(fmt.Sprintf("inbox-totalItems-%s", name), &count)
+	if err == nil {
+		return count, nil
+	}
+	log.Println(fmt.Sprintf("no cached %s", fmt.Sprintf("inbox-totalItems-%s", name)))
+
+	sql := `SELECT COUNT(act.*)
+	FROM activities as act
+	JOIN activities_to AS act_to ON act_to.activity_id = act.id
+	WHERE act_to.iri = $1`
+
+	err = r.db.QueryRow(context.Background(), sql,
+		fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
+	).Scan(&count)
+	if err != nil {
+		return count, err
+	}
+
+	err = r.cache.Set(fmt.Sprintf("inbox-totalItems-%s", name), count)
+	if err != nil {
+		log.Println(fmt.Sprintf("error setting cache %s", fmt.Sprintf("inbox-totalItems-%s", name)))
+	}
+
+	return count, nil
+}
+
+func (r *PSQLRepository) QueryInboxByUserName(name string, page models.Page) ([]models.Activity, []int, error) {
+	var cached activityPage
+	r.cache.Get(fmt.Sprintf("inbox-%s-%s", name, page.Query()), &cached)
+	if cached.Activities != nil {
+		return cached.Activities, cached.IDs, nil
+	}
+	log.Println(fmt.Sprintf("no cached %s", fmt.Sprintf("inbox-%s-%s", name, page.Query())))
+
+	sql := `SELECT act.*
+	FROM activities as act
+	JOIN activities_to AS act_to ON act_to.activity_id = act.id
+	WHERE act_to.iri = $1`
+
+	clause, args := r.pageClause("act.id", page, 2)
+	rows, err := r.db.Query(context.Background(), sql+clause,
+		append([]interface{}{fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name)}, args...)...,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var activities []models.Activity
+	var ids []int
+	for rows.Next() {
+		var activity_id int
+		var object_id int
+		activity := models.NewActivity()
+		err = rows.Scan(
+			&activity_id,
+			&activity.Type,
+			&activity.Actor,
+			&object_id,
+			&activity.Id,
+		)
+		if err != nil {
+			return activities, ids, err
+		}
+		object_iri, err := r.queryObjectIRIById(object_id)
+		if err != nil {
+			return activities, ids, err
+		}
+		object, err := r.queryObjectByIRI(object_iri)
+		if err != nil {
+			activity.ChildObject = object_iri
+
+		} else {
+			activity.ChildObject = object
+		}
+		activity.To, err = r.queryToByActivityId(activity_id)
+		if err != nil {
+			return activities, ids, err
+		}
+		activities = append(activities, activity)
+		ids = append(ids, activity_id)
+	}
+	err = rows.Err()
+	if err != nil {
+		return activities, ids, err
+	}
+	reversePage(page, len(activities), func(i, j int) {
+		activities[i], activities[j] = activities[j], activities[i]
+		ids[i], ids[j] = ids[j], ids[i]
+	})
+
+	err = r.cache.Set(fmt.Sprintf("inbox-%s-%s", name, page.Query()), activityPage{Activities: activities, IDs: ids})
+	if err != nil {
+		log.Println(fmt.Sprintf("error setting cache %s", fmt.Sprintf("inbox-%s-%s", name, page.Query())))
+	}
+
+	return activities, ids, nil
+}
+
+func (r *PSQLRepository) QueryOutboxTotalItemsByUserName(name string) (int, error) {
+	var count int
+	_, err := r.cache.Get(fmt.Sprintf("outbox-totalItems-%s", name), &count)
+	if err == nil {
+		return count, nil
+	}
+	log.Println(fmt.Sprintf("no cached %s", fmt.Sprintf("outbox-totalItems-%s", name)))
+
+	sql := `SELECT COUNT(*) FROM activities
+	WHERE actor = $1`
+
+	err = r.db.QueryRow(context.Background(), sql,
+		fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
+	).Scan(&count)
+	if err != nil {
+		return count, err
+	}
+
+	err = r.cache.Set(fmt.Sprintf("outbox-totalItems-%s", name), count)
+	if err != nil {
+		log.Println(fmt.Sprintf("error setting cache %s", fmt.Sprintf("outbox-totalItems-%s", name)))
+	}
+
+	return count, nil
+}
+
+func (r *PSQLRepository) QueryOutboxByUserName(name string, page models.Page) ([]models.Activity, []int, error) {
+	var cached activityPage
+	_, err := r.cache.Get(fmt.Sprintf("outbox-%s-%s", name, page.Query()), &cached)
+	if err == nil {
+		return cached.Activities, cached.IDs, nil
+	}
+	log.Println(fmt.Sprintf("no cached %s", fmt.Sprintf("outbox-%s-%s", name, page.Query())))
+
+	sql := `SELECT *
+	FROM activities
+	WHERE actor = $1`
+
+	clause, args := r.pageClause("id", page, 2)
+	rows, err := r.db.Query(context.Background(), sql+clause,
+		append([]interface{}{fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name)}, args...)...,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var activities []models.Activity
+	var ids []int
+	for rows.Next() {
+		var activity_id int
+		var object_id int
+		activity := models.NewActivity()
+		err = rows.Scan(
+			&activity_id,
+			&activity.Type,
+			&activity.Actor,
+			&object_id,
+			&activity.Id,
+		)
+		if err != nil {
+			return activities, ids, err
+		}
+		object_iri, err := r.queryObjectIRIById(object_id)
+		if err != nil {
+			return activities, ids, err
+		}
+		object, err := r.queryObjectByIRI(object_iri)
+		if err != nil {
+			activity.ChildObject = object_iri
+
+		} else {
+			activity.ChildObject = object
+		}
+		activity.To, err = r.queryToByActivityId(activity_id)
+		if err != nil {
+			return activities, ids, err
+		}
+		activities = append(activities, activity)
+		ids = append(ids, activity_id)
+	}
+	err = rows.Err()
+	if err != nil {
+		return activities, ids, err
+	}
+	reversePage(page, len(activities), func(i, j int) {
+		activities[i], activities[j] = activities[j], activities[i]
+		ids[i], ids[j] = ids[j], ids[i]
+	})
+
+	err = r.cache.Set(fmt.Sprintf("outbox-%s-%s", name, page.Query()), activityPage{Activities: activities, IDs: ids})
+	if err != nil {
+		log.Println(fmt.Sprintf("error setting cache %s", fmt.Sprintf("outbox-%s-%s", name, page.Query())))
+	}
+
+	return activities, ids, nil
+}
+
+func (r *PSQLRepository) queryObjectIRIById(object_id int) (string, error) {
+	sql := `SELECT iri
+	FROM objects WHERE id = $1;`
+	var iri string
+	err := r.db.QueryRow(context.Background(), sql, object_id).Scan(
+		&iri,
+	)
+	if err != nil {
+		return iri, err
+	}
+	return iri, nil
+}
+
+func (r *PSQLRepository) queryObjectByIRI(iri string) (models.Object, error) {
+	var tag, attachment []byte
+	var published time.Time
+	sql := `SELECT type, iri, content, attributed_to, in_reply_to, name, tag, attachment, published
+	FROM objects WHERE iri = $1;`
+	object := models.NewObject()
+	err := r.db.QueryRow(context.Background(), sql, iri).Scan(
+		&object.Type,
+		&object.Id,
+		&object.Content,
+		&object.AttributedTo,
+		&object.InReplyTo,
+		&object.Name,
+		&tag,
+		&attachment,
+		&published,
+	)
+	if err != nil {
+		return object, err
+	}
+	if tag != nil {
+		json.Unmarshal(tag, &object.Tag)
+	}
+	object.Published = published.UTC().Format(time.RFC3339)
+
+	err = r.queryFilesByObjectIRI(iri, &object)
+	if err != nil {
+		return object, err
+	}
+	unmarshalRemoteAttachments(attachment, &object)
+
+	return object, nil
+}
+
+// queryFilesByObjectIRI adds an object's uploaded files to it as attachments, along with
+// the url links of files stored before attachments were supported
+func (r *PSQLRepository) queryFilesByObjectIRI(iri string, object *models.Object) error {
+	sql := `SELECT id, name, type, href, media_type, width, height, blurhash, preview, preview_media_type, duration, waveform
+	FROM object_files
+	WHERE object_id = (SELECT id FROM objects WHERE iri = $1 LIMIT 1)
+	ORDER BY id`
+	rows, err := r.db.Query(context.Background(), sql, iri)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	var links []models.Link
+	var attachments []models.Attachment
+	for rows.Next() {
+		var link_id int
+		var attachment models.Attachment
+		var preview models.Image
+		err = rows.Scan(
+			&link_id,
+			&attachment.Name,
+			&attachment.Type,
+			&attachment.Url,
+			&attachment.MediaType,
+			&attachment.Width,
+			&attachment.Height,
+			&attachment.Blurhash,
+			&preview.Url,
+			&preview.MediaType,
+			&attachment.Duration,
+			&attachment.Waveform,
+		)
+		if err != nil {
+			return err
+		}
+		if preview.Url != "" {
+			preview.Type = "Image"
+			attachment.Preview = &preview
+		}
+		// audio posts take their duration and cover art from their first track
+		if attachment.Duration != "" && object.Duration == "" {
+			object.Duration = attachment.Duration
+			if attachment.Preview != nil && object.Icon == nil {
+				object.Icon = attachment.Preview
+			}
+		}
+		if attachment.Type == "Link" {
+			link := models.NewLink()
+			link.Id = fmt.Sprintf("%s://%s/%s/%d", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Links, link_id)
+			link.Type = attachment.Type
+			link.Href = attachment.Url
+			link.MediaType = attachment.MediaType
+			links = append(links, link)
+			attachment.Type = media.ObjectType(attachment.MediaType)
+		}
+		attachments = append(attachments, attachment)
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+	if links != nil {
+		object.Url = links
+	}
+	if attachments != nil {
+		object.Attachment = attachments
+	}
+	return nil
+}
+
+// unmarshalRemoteAttachments adds the stored attachments of a remote object, which has no files of its own
+func unmarshalRemoteAttachments(attachment []byte, object *models.Object) {
+	if attachment == nil || object.Attachment != nil {
+		return
+	}
+	var attachments []models.Attachment
+	if json.Unmarshal(attachment, &attachments) == nil && attachments != nil {
+		object.Attachment = attachments
+	}
+}
+
+func (r *PSQLRepository) queryToByActivityId(activity_id int) ([]string, error) {
+	sql := `SELECT iri
+	FROM activities_to
+	WHERE activity_id = $1`
+
+	rows, err := r.db.Query(context.Background(), sql, activity_id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tos []string
+	for rows.Next() {
+		var to string
+		err = rows.Scan(
+			&to,
+		)
+		if err != nil {
+			return tos, err
+		}
+		tos = append(tos, to)
+	}
+	err = rows.Err()
+	if err != nil {
+		return tos, err
+	}
+	return tos, nil
+}
+
+func (r *PSQLRepository) QueryFollowingTotalItemsByUserName(name string) (int, error) {
+	sql := `SELECT COUNT(*)
+	FROM activities
+	WHERE type = 'Follow'
+	AND iri NOT IN (
+		SELECT obj.iri FROM activities AS act
+		JOIN objects AS obj ON obj.id = act.object_id
+		WHERE act.type = 'Undo'
+	)
+	AND actor = $1`
+
+	var count int
+	err := r.db.QueryRow(context.Background(), sql,
+		fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
+	).Scan(
+		&count,
+	)
+	if err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+func (r *PSQLRepository) QueryFollowingByUserName(name string, page models.Page) ([]string, []int, error) {
+	sql := `SELECT act.id, obj.iri
+	FROM activities AS act
+	JOIN objects AS obj ON obj.id = act.object_id
+	WHERE act.type = 'Follow'
+	AND act.iri NOT IN (
+		SELECT obj.iri FROM activities AS act
+		JOIN objects AS obj ON obj.id = act.object_id
+		WHERE act.type = 'Undo'
+	)
+	AND act.actor = $1`
+
+	clause, args := r.pageClause("act.id", page, 2)
+	rows, err := r.db.Query(context.Background(), sql+clause,
+		append([]interface{}{fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name)}, args...)...,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var actors []string
+	var ids []int
+	for rows.Next() {
+		var id int
+		var actor string
+		err = rows.Scan(
+			&id,
+			&actor,
+		)
+		if err != nil {
+			return actors, ids, err
+		}
+		actors = append(actors, actor)
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	if err != nil {
+		return actors, ids, err
+	}
+	reversePage(page, len(actors), func(i, j int) {
+		actors[i], actors[j] = actors[j], actors[i]
+		ids[i], ids[j] = ids[j], ids[i]
+	})
+	return actors, ids, nil
+}
+
+func (r *PSQLRepository) QueryFollowersTotalItemsByUserName(name string) (int, error) {
+	sql := `SELECT COUNT(*)
+	FROM activities AS act
+	JOIN objects AS obj ON obj.id = act.object_id
+	WHERE act.type = 'Follow'
+	AND act.iri NOT IN (
+		SELECT obj.iri FROM activities AS act
+		JOIN objects AS obj ON obj.id = act.object_id
+		WHERE act.type = 'Undo'
+	)
+	AND obj.iri = $1`
+
+	var count int
+	err := r.db.QueryRow(context.Background(), sql,
+		fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
+	).Scan(
+		&count,
+	)
+	if err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+func (r *PSQLRepository) QueryFollowersByUserName(name string, page models.Page) ([]string, []int, error) {
+	sql := `SELECT act.id, act.actor
+	FROM activities AS act
+	JOIN objects AS obj ON obj.id = act.object_id
+	WHERE act.type = 'Follow'
+	AND act.iri NOT IN (
+		SELECT obj.iri FROM activities AS act
+		JOIN objects AS obj ON obj.id = act.object_id
+		WHERE act.type = 'Undo'
+	)
+	AND obj.iri = $1`
+
+	clause, args := r.pageClause("act.id", page, 2)
+	rows, err := r.db.Query(context.Background(), sql+clause,
+		append([]interface{}{fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name)}, args...)...,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var actors []string
+	var ids []int
+	for rows.Next() {
+		var id int
+		var actor string
+		err = rows.Scan(
+			&id,
+			&actor,
+		)
+		if err != nil {
+			return actors, ids, err
+		}
+		actors = append(actors, actor)
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	if err != nil {
+		return actors, ids, err
+	}
+	reversePage(page, len(actors), func(i, j int) {
+		actors[i], actors[j] = actors[j], actors[i]
+		ids[i], ids[j] = ids[j], ids[i]
+	})
+	return actors, ids, nil
+}
+
+func (r *PSQLRepository) QueryLikedTotalItemsByUserName(name string) (int, error) {
+	sql := `SELECT COUNT(*)
+	FROM activities
+	WHERE type = 'Like'
+	AND iri NOT IN (
+		SELECT obj.iri FROM activities AS act
+		JOIN objects AS obj ON obj.id = act.object_id
+		WHERE act.type = 'Undo'
+	)
+	AND actor = $1`
+
+	var count int
+	err := r.db.QueryRow(context.Background(), sql,
+		fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
+	).Scan(
+		&count,
+	)
+	if err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+// func (r *PSQLRepository) QueryLikedByUserName(name string, page models.Page) ([]models.Object, error) {
+func (r *PSQLRepository) QueryLikedByUserName(name string, page models.Page) ([]string, []int, error) {
+	// sql := `SELECT obj.type, obj.iri, obj.content, obj.attributed_to, obj.in_reply_to
+	sql := `SELECT act.id, obj.iri
+	FROM objects AS obj
+	JOIN activities AS act ON act.object_id = obj.id
+	WHERE act.type = 'Like'
+	AND act.iri NOT IN (
+		SELECT obj.iri FROM activities AS act
+		JOIN objects AS obj ON obj.id = act.object_id
+		WHERE act.type = 'Undo'
+	)
+	AND act.actor = $1`
+
+	clause, args := r.pageClause("act.id", page, 2)
+	rows, err := r.db.Query(context.Background(), sql+clause,
+		append([]interface{}{fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name)}, args...)...,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	// var objects []models.Object
+	var objects []string
+	var ids []int
+	for rows.Next() {
+		// object := models.NewObject()
+		var id int
+		var object string
+		// err = rows.Scan(
+		// 	&object.Type,
+		// 	&object.Id,
+		// 	&object.Content,
+		// 	&object.AttributedTo,
+		// 	&object.InReplyTo,
+		// )
+		err = rows.Scan(
+			&id,
+			&object,
+		)
+		if err != nil {
+			return objects, ids, err
+		}
+		objects = append(objects, object)
+		ids = append(ids, id)
+	}
+	err = rows.Err()
+	if err != nil {
+		return objects, ids, err
+	}
+	reversePage(page, len(objects), func(i, j int) {
+		objects[i], objects[j] = objects[j], objects[i]
+		ids[i], ids[j] = ids[j], ids[i]
+	})
+	return objects, ids, nil
+}
+
+func (r *PSQLRepository) QueryActivity(id int) (models.Activity, error) {
+	activity := models.NewActivity()
+	_, err := r.cache.Get(fmt.Sprintf("activity-%d", id), &activity)
+	if err == nil {
+		return activity, nil
+	}
+	log.Println(fmt.Sprintf("no cached %s", fmt.Sprintf("activity-%d", id)))
+
+	sql := `SELECT * FROM activities
+	WHERE id = $1
+	LIMIT 1`
+	var activity_id int
+	var object_id int
+	err = r.db.QueryRow(context.Background(), sql, id).Scan(
+		&activity_id,
+		&activity.Type,
+		&activity.Actor,
+		&object_id,
+		&activity.Id,
+	)
+	if err != nil {
+		return activity, err
+	}
+	object_iri, err := r.queryObjectIRIById(object_id)
+	if err != nil {
+		return activity, err
+	}
+	object, err := r.queryObjectByIRI(object_iri)
+	if err != nil {
+		activity.ChildObject = object_iri
+
+	} else {
+		activity.ChildObject = object
+	}
+	activity.To, err = r.queryToByActivityId(activity_id)
+	if err != nil {
+		return activity, err
+	}
+
+	err = r.cache.Set(fmt.Sprintf("activity-%d", id), activity)
+	if err != nil {
+		log.Println(fmt.Sprintf("error setting cache %s", fmt.Sprintf("activity-%d", id)))
+	}
+
+	return activity, nil
+}
+
+func (r *PSQLRepository) QueryObject(id int) (models.Object, error) {
+	object := models.NewObject()
+	_, err := r.cache.Get(fmt.Sprintf("object-%d", id), &object)
+	if err == nil {
+		return object, nil
+	}
+	log.Println(fmt.Sprintf("no cached %s", fmt.Sprintf("activity-%d", id)))
+
+	var tag, attachment []byte
+	var published time.Time
+	sql := `SELECT type, iri, content, attributed_to, in_reply_to, name, tag, attachment, published
+	FROM objects WHERE id = $1;`
+	err = r.db.QueryRow(context.Background(), sql, id).Scan(
+		&object.Type,
+		&object.Id,
+		&object.Content,
+		&object.AttributedTo,
+		&object.InReplyTo,
+		&object.Name,
+		&tag,
+		&attachment,
+		&published,
+	)
+	if err != nil {
+		return object, err
+	}
+	if tag != nil {
+		json.Unmarshal(tag, &object.Tag)
+	}
+	object.Published = published.UTC().Format(time.RFC3339)
+	err = r.queryFilesByObjectIRI(object.Id, &object)
+	if err != nil {
+		return object, err
+	}
+	unmarshalRemoteAttachments(attachment, &object)
+	err = r.cache.Set(fmt.Sprintf("object-%d", id), object)
+	if err != nil {
+		log.Println(fmt.Sprintf("error setting cache %s", fmt.Sprintf("object-%d", id)))
+	}
+
+	return object, nil
+}
+
+// Create a new inbox Activity with basic details
+func (r *PSQLRepository) CreateInboxActivity(activityArb arb.Arb, objectArb arb.Arb, actor string, name string) (arb.Arb, error) {
+	ctx := context.Background()
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return activityArb, err
+	}
+	objectIRI, _ := objectArb.GetString("id")
+	object_id, err := r.queryObjectID(objectIRI)
+	if err != nil {
+		var tag []byte
+		if objectArb.Exists("tag") {
+			tag, err = json.Marshal(objectArb["tag"])
+			if err != nil {
+				tx.Rollback(ctx)
+				return activityArb, err
+			}
+		}
+		sql := `INSERT INTO objects (iri, type, content, attributed_to, in_reply_to, name, tag) 
+		VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id;`
+		err = tx.QueryRow(ctx, sql,
+			objectArb["id"],
+			objectArb["type"],
+			objectArb["content"],
+			objectArb["attributedTo"],
+			objectArb["inReplyTo"],
+			objectArb["name"],
+			tag,
+		).Scan(&object_id)
+		if err != nil {
+			tx.Rollback(ctx)
+			return activityArb, err
+		}
+	}
+	activityIRI, _ := activityArb.GetString("id")
+	activity_id, err := r.queryActivityID(activityIRI)
+	if err != nil {
+		sql := `INSERT INTO activities (type, actor, object_id, iri)
+		VALUES ($1, $2, $3, $4) RETURNING id;`
+		err = tx.QueryRow(ctx, sql, activityArb["type"], actor, object_id, activityArb["id"]).Scan(&activity_id)
+		if err != nil {
+			tx.Rollback(ctx)
+			return activityArb, err
+		}
+	}
+	iri := fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name)
+	delivered := false
+	if !r.ActivityToExists(activityIRI, iri) {
+		delivered = true
+		sql := `INSERT INTO activities_to (activity_id, iri) VALUES ($1, $2);`
+		_, err = tx.Exec(ctx, sql, activity_id, iri)
+		if err != nil {
+			tx.Rollback(ctx)
+			return activityArb, err
+		}
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = r.cache.Del(
+		fmt.Sprintf("inbox-%s-*", name),
+		fmt.Sprintf("inbox-totalItems-%s", name),
+		fmt.Sprintf("feed-%s-*", name),
+		fmt.Sprintf("feed-totalItems-%s", name),
+	)
+	if err != nil {
+		log.Println(fmt.Sprintf("error deleting cache %s and %s", fmt.Sprintf("inbox-%s-*", name), fmt.Sprintf("inbox-totalItems-%s", name)))
+	}
+	// TODO: Invalidate other cache items based on activityArb["type"]
+	if delivered {
+		r.publishInboxActivity(activity_id, activityArb, actor, name)
+	}
+	return activityArb, nil
+}
+
+// Create a new inbox Activity with basic details
+func (r *PSQLRepository) CreateInboxReferenceActivity(activityArb arb.Arb, object string, actor string, name string) (arb.Arb, error) {
+	// activityIRI, _ := activityArb.GetString("id")
+	ctx := context.Background()
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return activityArb, err
+	}
+	object_id, err := r.queryObjectID(object)
+	if err != nil {
+		sql := `INSERT INTO objects (iri) 
+		VALUES ($1) RETURNING id;`
+		err = tx.QueryRow(ctx, sql, object).Scan(&object_id)
+		if err != nil {
+			tx.Rollback(ctx)
+			return activityArb, err
+		}
+	}
+	activityIRI, _ := activityArb.GetString("id")
+	activity_id, err := r.queryActivityID(activityIRI)
+	if err != nil {
+		sql := `INSERT INTO activities (type, actor, object_id, iri)
+		VALUES ($1, $2, $3, $4) RETURNING id;`
+		err = tx.QueryRow(ctx, sql, activityArb["type"], actor, object_id, activityArb["id"]).Scan(&activity_id)
+		if err != nil {
+			tx.Rollback(ctx)
+			return activityArb, err
+		}
+	}
+	iri := fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name)
+	delivered := false
+	if !r.ActivityToExists(activityIRI, iri) {
+		delivered = true
+		sql := `INSERT INTO activities_to (activity_id, iri) VALUES ($1,$2);`
+		_, err = tx.Exec(ctx, sql, activity_id, iri)
+		if err != nil {
+			tx.Rollback(ctx)
+			return activityArb, err
+		}
+	}
+	tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = r.cache.Del(
+		fmt.Sprintf("inbox-%s-*", name),
+		fmt.Sprintf("inbox-totalItems-%s", name),
+		fmt.Sprintf("feed-%s-*", name),
+		fmt.Sprintf("feed-totalItems-%s", name),
+	)
+	if err != nil {
+		log.Println(fmt.Sprintf("error deleting cache %s and %s", fmt.Sprintf("inbox-%s-*", name), fmt.Sprintf("inbox-totalItems-%s", name)))
+	}
+	// TODO: Invalidate other cache items based on activityArb["type"]
+	if delivered {
+		r.publishInboxActivity(activity_id, activityArb, actor, name)
+	}
+	return activityArb, nil
+}
+
+// Push a newly delivered inbox Activity to the user's streaming clients
+func (r *PSQLRepository) publishInboxActivity(activity_id int, activityArb arb.Arb, actor string, name string) {
+	activityType, _ := activityArb.GetString("type")
+	event := models.StreamEvent{
+		ID:       activity_id,
+		Topic:    name,
+		Feed:     (activityType == "Create" || activityType == "Announce") && r.CheckActivity(name, "Follow", actor) != "",
+		Activity: activityArb,
+	}
+	err := r.broker.Publish(event)
+	if err != nil {
+		log.Println(fmt.Sprintf("error publishing activity %d to %s: %s", activity_id, name, err))
+	}
+}
+
+func (r *PSQLRepository) queryObjectID(iri string) (int, error) {
+	sql := `SELECT id
+	FROM objects WHERE iri = $1;`
+	var object_id int
+	err := r.db.QueryRow(context.Background(), sql, iri).Scan(&object_id)
+	if err != nil {
+		return object_id, err
+	}
+	return object_id, nil
+}
+
+func (r *PSQLRepository) queryActivityID(iri string) (int, error) {
+	sql := `SELECT id
+	FROM activities WHERE iri = $1;`
+	var activity_id int
+	err := r.db.QueryRow(context.Background(), sql, iri).Scan(&activity_id)
+	if err != nil {
+		return activity_id, err
+	}
+	return activity_id, nil
+}
+
+func (r *PSQLRepository) CreateOutboxActivity(activityArb arb.Arb, objectArb arb.Arb, name string) (arb.Arb, error) {
+	ctx := context.Background()
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return activityArb, err
+	}
+	// TODO: Code here to prevent duplicate objects???
+	var tag []byte
+	if objectArb.Exists("tag") {
+		tag, err = json.Marshal(objectArb["tag"])
+		if err != nil {
+			tx.Rollback(ctx)
+			return activityArb, err
+		}
+	}
+	sql := `INSERT INTO objects (type, content, attributed_to, in_reply_to, name, tag) 
+	VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;`
+	var object_id int
+	err = tx.QueryRow(ctx, sql,
+		objectArb["type"],
+		objectArb["content"],
+		objectArb["attributedTo"],
+		objectArb["inReplyTo"],
+		objectArb["name"],
+		tag,
+	).Scan(&object_id)
+	if err != nil {
+		tx.Rollback(ctx)
+		return activityArb, err
+	}
+	objectArb["id"] = fmt.Sprintf("%s://%s/%s/%d", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Objects, object_id)
+	sql = `UPDATE objects
+	SET iri = $1
+	WHERE id = $2;`
+	_, err = tx.Exec(ctx, sql, objectArb["id"], object_id)
+	if err != nil {
+		tx.Rollback(ctx)
+		return activityArb, err
+	}
+	// only uploads are stored, attachments linking elsewhere have no uuid
+	if fileArbs, err := objectArb.GetArbArray("attachment"); err == nil {
+		for _, fileArb := range fileArbs {
+			uuid, ok := fileArb["uuid"]
+			if !ok {
+				continue
+			}
+			width, _ := fileArb["width"].(int)
+			height, _ := fileArb["height"].(int)
+			blurhash, _ := fileArb.GetString("blurhash")
+			var preview, previewMediaType string
+			if previewArb, err := fileArb.GetArb("preview"); err == nil {
+				preview, _ = previewArb.GetString("url")
+				previewMediaType, _ = previewArb.GetString("mediaType")
+			}
+			duration, _ := fileArb.GetString("duration")
+			waveform, _ := fileArb.GetString("waveform")
+			sha256, _ := fileArb.GetString("sha256")
+			sql = `INSERT INTO object_files (object_id, created, name, uuid, type, href, media_type, width, height, blurhash, preview, preview_media_type, duration, waveform, sha256) 
+			VALUES ($1, CURRENT_TIMESTAMP, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14);`
+			// TODO: return id to populate file iri
+			_, err = tx.Exec(ctx, sql,
+				object_id,
+				fileArb["name"],
+				uuid,
+				fileArb["type"],
+				fileArb["url"],
+				fileArb["mediaType"],
+				width,
+				height,
+				blurhash,
+				preview,
+				previewMediaType,
+				duration,
+				waveform,
+				sha256,
+			)
+			if err != nil {
+				tx.Rollback(ctx)
+				return activityArb, err
+			}
+			sql = `DELETE FROM pending_media
+			WHERE uuid = $1
+			AND user_id = (SELECT id FROM users WHERE name = $2);`
+			_, err = tx.Exec(ctx, sql, uuid, name)
+			if err != nil {
+				tx.Rollback(ctx)
+				return activityArb, err
+			}
+			delete(fileArb, "uuid")
+			delete(fileArb, "sha256")
+		}
+	}
+	sql = `INSERT INTO activities (type, actor, object_id)
+	VALUES ($1, $2, $3) RETURNING id;`
+	var activity_id int
+	err = tx.QueryRow(ctx, sql, activityArb["type"], activityArb["actor"], object_id).Scan(&activity_id)
+	if err != nil {
+		tx.Rollback(ctx)
+		return activityArb, err
+	}
+	activityArb["id"] = fmt.Sprintf("%s://%s/%s/%d", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Activities, activity_id)
+	sql = `UPDATE activities
+	SET iri = $1
+	WHERE id = $2;`
+	_, err = tx.Exec(ctx, sql, activityArb["id"], activity_id)
+	if err != nil {
+		tx.Rollback(ctx)
+		return activityArb, err
+	}
+	// // Insert to records (need to do similar for bto, cc, bcc, and audience)
+	// valueStrings, valueArgs := createRecipientsInsert(activity_id, activityArb.To)
+	// sql = fmt.Sprintf("INSERT INTO activities_to (activity_id, iri) VALUES %s", strings.Join(valueStrings, ","))
+	// _, err = tx.Exec(ctx, sql, valueArgs...)
+	// if err != nil {
+	// 	tx.Rollback(ctx)
+	// 	return activityArb, err
+	// }
+	tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = r.cache.Del(fmt.Sprintf("outbox-%s-*", name), fmt.Sprintf("outbox-totalItems-%s", name))
+	if err != nil {
+		log.Println(fmt.Sprintf("error deleting cache %s and %s", fmt.Sprintf("outbox-%s-*", name), fmt.Sprintf("outbox-totalItems-%s", name)))
+	}
+	// TODO: Invalidate other cache items based on activityArb["type"]
+	return activityArb, nil
+}
 
-	sql := `SELECT * FROM activities
-	WHERE id = $1
-	LIMIT 1`
+func (r *PSQLRepository) CreateOutboxReferenceActivity(activityArb arb.Arb, name string) (arb.Arb, error) {
+	ctx := context.Background()
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return activityArb, err
+	}
+	objectIRI, _ := activityArb.GetString("object")
+	object_id, err := r.queryObjectID(objectIRI)
+	if err != nil {
+		sql := `INSERT INTO objects (iri) 
+		VALUES ($1) RETURNING id;`
+		err = tx.QueryRow(ctx, sql,
+			activityArb["object"],
+		).Scan(&object_id)
+		if err != nil {
+			tx.Rollback(ctx)
+			return activityArb, err
+		}
+	}
+	sql := `INSERT INTO activities (type, actor, object_id)
+	VALUES ($1, $2, $3) RETURNING id;`
 	var activity_id int
-	var object_id int
-	err = r.db.QueryRow(context.Background(), sql, id).Scan(
-		&activity_id,
-		&activity.Type,
-		&activity.Actor,
-		&object_id,
-		&activity.Id,
-	)
+	err = tx.QueryRow(ctx, sql, activityArb["type"], activityArb["actor"], object_id).Scan(&activity_id)
+	if err != nil {
+		tx.Rollback(ctx)
+		return activityArb, err
+	}
+	activityArb["id"] = fmt.Sprintf("%s://%s/%s/%d", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Activities, activity_id)
+	sql = `UPDATE activities
+	SET iri = $1
+	WHERE id = $2;`
+	_, err = tx.Exec(ctx, sql, activityArb["id"], activity_id)
+	if err != nil {
+		tx.Rollback(ctx)
+		return activityArb, err
+	}
+	// Insert to records (need to do similar for bto, cc, bcc, and audience)
+	// valueStrings, valueArgs := createRecipientsInsert(activity_id, activityArb.To)
+	// sql = fmt.Sprintf("INSERT INTO activities_to (activity_id, iri) VALUES %s", strings.Join(valueStrings, ","))
+	// _, err = tx.Exec(ctx, sql, valueArgs...)
+	// if err != nil {
+	// 	tx.Rollback(ctx)
+	// 	return activityArb, err
+	// }
+	tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	err = r.cache.Del(fmt.Sprintf("outbox-%s-*", name), fmt.Sprintf("outbox-totalItems-%s", name))
+	if err != nil {
+		log.Println(fmt.Sprintf("error deleting cache %s and %s", fmt.Sprintf("outbox-%s-*", name), fmt.Sprintf("outbox-totalItems-%s", name)))
+	}
+	// TODO: Invalidate other cache items based on activityArb["type"]
+	return activityArb, nil
+}
+
+func (r *PSQLRepository) ActivityToExists(activityIRI string, recipientIRI string) bool {
+	sql := `SELECT 1 from activities_to
+	WHERE activity_id = (SELECT id from activities WHERE iri = $1 LIMIT 1)
+	AND iri = $2`
+	var result int
+	_ = r.db.QueryRow(context.Background(), sql, activityIRI, recipientIRI).Scan(&result)
+	if result != 1 {
+		return false
+	}
+	log.Println(fmt.Sprintf("%s to %s exists", activityIRI, recipientIRI))
+	return true
+}
+
+func (r *PSQLRepository) AddActivityTo(activityIRI string, recipient string) error {
+	sql := `INSERT INTO activities_to (activity_id, iri) 
+	VALUES (
+		(SELECT id FROM activities WHERE iri = $1 LIMIT 1),
+		$2
+	);`
+	_, err := r.db.Exec(context.Background(), sql, activityIRI, recipient)
+	if err != nil {
+		return err
+	}
+	// If local clear inbox cache of user
+	if utils.IsFromHost(recipient, r.conf.ServerName) {
+		name := strings.TrimPrefix(recipient, fmt.Sprintf("%s://%s/%s/", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users))
+		return r.cache.Del(
+			fmt.Sprintf("inbox-%s-*", name),
+			fmt.Sprintf("inbox-totalItems-%s", name),
+			fmt.Sprintf("feed-%s-*", name),
+			fmt.Sprintf("feed-totalItems-%s", name),
+		)
+	}
+	return nil
+}
+
+func (r *PSQLRepository) DeleteActivity(activityArb arb.Arb, name string) (arb.Arb, error) {
+	ctx := context.Background()
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return activityArb, err
+	}
+	objectIRI, _ := activityArb.GetString("object")
+	object_id, err := r.queryObjectID(objectIRI)
+	if err != nil {
+		sql := `INSERT INTO objects (iri) 
+		VALUES ($1) RETURNING id;`
+		err = tx.QueryRow(ctx, sql,
+			activityArb["object"],
+		).Scan(&object_id)
+		if err != nil {
+			tx.Rollback(ctx)
+			return activityArb, err
+		}
+	}
+	sql := `INSERT INTO activities (type, actor, object_id)
+	VALUES ($1, $2, $3) RETURNING id;`
+	var activity_id int
+	err = tx.QueryRow(ctx, sql, activityArb["type"], activityArb["actor"], object_id).Scan(&activity_id)
+	if err != nil {
+		tx.Rollback(ctx)
+		return activityArb, err
+	}
+	activityArb["id"] = fmt.Sprintf("%s://%s/%s/%d", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Activities, activity_id)
+	sql = `UPDATE activities
+	SET iri = $1
+	WHERE id = $2;`
+	_, err = tx.Exec(ctx, sql, activityArb["id"], activity_id)
+	if err != nil {
+		tx.Rollback(ctx)
+		return activityArb, err
+	}
+	sql = `UPDATE objects
+	SET type = 'Tombstone',
+	content = NULL,
+	name = NULL,
+	tag = NULL,
+	attachment = NULL,
+	public = false
+	WHERE id = $1;`
+	_, err = tx.Exec(ctx, sql, object_id)
+	if err != nil {
+		tx.Rollback(ctx)
+		return activityArb, err
+	}
+	sql = `DELETE FROM object_hashtags
+	WHERE object_id = $1;`
+	_, err = tx.Exec(ctx, sql, object_id)
+	if err != nil {
+		tx.Rollback(ctx)
+		return activityArb, err
+	}
+	sql = `DELETE FROM object_files
+	WHERE object_id = $1;`
+	_, err = tx.Exec(ctx, sql, object_id)
+	if err != nil {
+		tx.Rollback(ctx)
+		return activityArb, err
+	}
+	tx.Commit(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r.deleteObjectCacheInvalidation(object_id)
+	err = r.cache.Del(fmt.Sprintf("outbox-%s-*", name), fmt.Sprintf("outbox-totalItems-%s", name), "tag-*")
+	if err != nil {
+		log.Println(fmt.Sprintf("error deleting cache %s and %s", fmt.Sprintf("outbox-%s-*", name), fmt.Sprintf("outbox-totalItems-%s", name)))
+	}
+	// TODO: Invalidate other cache items based on activityArb["type"]
+	return activityArb, nil
+}
+
+func (r *PSQLRepository) deleteObjectCacheInvalidation(object_id int) {
+	// Invalidate cached Object
+	err := r.cache.Del(fmt.Sprintf("object-%d", object_id))
+	if err != nil {
+		log.Println(fmt.Sprintf("error deleting cache %s", fmt.Sprintf("object-%d", object_id)))
+	}
+	// Invalidate cached Activities referencing this Object
+	activity_ids, err := r.getActivityIDsWithObjectID(object_id)
+	if err != nil {
+		log.Println(fmt.Sprintf("error getting activities referencing object %d: %v", object_id, err))
+	}
+	var activity_keys []string
+	for i := range activity_ids {
+		activity_keys = append(activity_keys, fmt.Sprintf("activity-%d", activity_ids[i]))
+	}
+	err = r.cache.Del(activity_keys...)
+	if err != nil {
+		log.Println(fmt.Sprintf("error deleting cached activities referencing object %d: %v", object_id, err))
+	}
+	// Invalidate cached actor inboxes with Activities referencing this Object
+	actors_receiving, err := r.getActorsReceivingObjectID(object_id)
+	if err != nil {
+		log.Println(fmt.Sprintf("error getting actors receiving object %d: %v", object_id, err))
+	}
+	var actors_receiving_keys []string
+	for i := range actors_receiving {
+		actors_receiving_keys = append(actors_receiving_keys, fmt.Sprintf("inbox-%s-*", actors_receiving[i]), fmt.Sprintf("inbox-totalItems-%s", actors_receiving[i]))
+	}
+	err = r.cache.Del(actors_receiving_keys...)
+	if err != nil {
+		log.Println(fmt.Sprintf("error deleting cached actor inboxes referencing object %d: %v", object_id, err))
+	}
+	// Invalidate cached actor inboxes with Activities referencing this Object
+	actors_sending, err := r.getActorsSendingObjectID(object_id)
+	if err != nil {
+		log.Println(fmt.Sprintf("error getting actors sending object %d: %v", object_id, err))
+	}
+	var actors_sending_keys []string
+	for i := range actors_receiving {
+		actors_sending_keys = append(actors_sending_keys, fmt.Sprintf("outbox-%s-*", actors_sending[i]), fmt.Sprintf("outbox-totalItems-%s", actors_sending[i]))
+	}
+	err = r.cache.Del(actors_sending_keys...)
+	if err != nil {
+		log.Println(fmt.Sprintf("error deleting cached actor outboxes referencing object %d: %v", object_id, err))
+	}
+}
+
+func (r *PSQLRepository) getActivityIDsWithObjectID(object_id int) ([]int, error) {
+	sql := `SELECT id
+	FROM activities
+	WHERE object_id = $1`
+
+	rows, err := r.db.Query(context.Background(), sql, object_id)
+	if err != nil {
+		return nil, err
+	}
+	var activity_ids []int
+	defer rows.Close()
+	for rows.Next() {
+		var activity_id int
+		err = rows.Scan(&activity_id)
+		if err != nil {
+			return activity_ids, err
+		}
+		activity_ids = append(activity_ids, activity_id)
+	}
+	err = rows.Err()
+	if err != nil {
+		return activity_ids, err
+	}
+	return activity_ids, nil
+}
+
+func (r *PSQLRepository) getActorsReceivingObjectID(object_id int) ([]string, error) {
+	sql := `SELECT DISTINCT usr.name
+	FROM activities_to AS act_to
+	JOIN activities AS act ON act.id = act_to.activity_id
+	JOIN objects AS obj ON obj.id = act.object_id
+	JOIN users AS usr ON usr.iri = act_to.iri
+	WHERE obj.id = $1`
+
+	rows, err := r.db.Query(context.Background(), sql, object_id)
 	if err != nil {
-		return activity, err
+		return nil, err
 	}
-	object_iri, err := r.queryObjectIRIById(object_id)
-	if err != nil {
-		return activity, err
+	var actors []string
+	defer rows.Close()
+	for rows.Next() {
+		var actor string
+		err = rows.Scan(&actor)
+		if err != nil {
+			return actors, err
+		}
+		actors = append(actors, actor)
 	}
-	object, err := r.queryObjectByIRI(object_iri)
+	err = rows.Err()
 	if err != nil {
-		activity.ChildObject = object_iri
+		return actors, err
+	}
+	return actors, nil
+}
 
-	} else {
-		activity.ChildObject = object
+func (r *PSQLRepository) getActorsSendingObjectID(object_id int) ([]string, error) {
+	sql := `SELECT DISTINCT usr.name
+	FROM activities AS act
+	JOIN objects AS obj ON obj.id = act.object_id
+	JOIN users AS usr ON usr.iri = act.actor
+	WHERE obj.id = $1`
+
+	rows, err := r.db.Query(context.Background(), sql, object_id)
+	if err != nil {
+		return nil, err
 	}
-	activity.To, err = r.queryToByActivityId(activity_id)
+	var actors []string
+	defer rows.Close()
+	for rows.Next() {
+		var actor string
+		err = rows.Scan(&actor)
+		if err != nil {
+			return actors, err
+		}
+		actors = append(actors, actor)
+	}
+	err = rows.Err()
 	if err != nil {
-		return activity, err
+		return actors, err
 	}
+	return actors, nil
+}
 
-	err = r.cache.Set(fmt.Sprintf("activity-%d", id), activity)
+func (r *PSQLRepository) GetObjectFilesByIRI(objectIRI string) ([]string, error) {
+	sql := `SELECT f.href, f.preview, f.waveform
+	FROM object_files AS f
+	JOIN objects AS obj ON obj.id = f.object_id
+	WHERE obj.iri = $1`
+
+	rows, err := r.db.Query(context.Background(), sql, objectIRI)
 	if err != nil {
-		log.Println(fmt.Sprintf("error setting cache %s", fmt.Sprintf("activity-%d", id)))
+		return nil, err
 	}
+	defer rows.Close()
+	return scanFileHrefs(rows)
+}
 
-	return activity, nil
+type missingFile struct {
+	objectID int
+	name     string
 }
 
-func (r *PSQLRepository) QueryObject(id int) (models.Object, error) {
-	object := models.NewObject()
-	_, err := r.cache.Get(fmt.Sprintf("object-%d", id), &object)
+// isMissing confirms a file absent from a listing is really gone before its row is deleted
+func isMissing(storage media.Storage, name string) bool {
+	file, _, err := storage.Open(name)
 	if err == nil {
-		return object, nil
+		file.Close()
+		return false
 	}
-	log.Println(fmt.Sprintf("no cached %s", fmt.Sprintf("activity-%d", id)))
+	return errors.Is(err, os.ErrNotExist)
+}
 
-	sql := `SELECT type, iri, content, attributed_to, in_reply_to, name
-	FROM objects WHERE id = $1;`
-	err = r.db.QueryRow(context.Background(), sql, id).Scan(
-		&object.Type,
-		&object.Id,
-		&object.Content,
-		&object.AttributedTo,
-		&object.InReplyTo,
-		&object.Name,
-	)
+// PurgeUnusedFiles deletes stored files that no object file, pending or chunked upload, user or proxied media refers to
+// once they are older than grace, and deletes the object file and proxied media rows whose
+// files are missing. With dryRun nothing is deleted, only counted.
+func (r *PSQLRepository) PurgeUnusedFiles(storage media.Storage, grace time.Duration, dryRun bool) (models.FilePurge, error) {
+	purge := models.FilePurge{DryRun: dryRun}
+	// rows created after the listing started may have files it missed, so they aren't checked
+	listed := time.Now()
+	files, err := storage.List()
 	if err != nil {
-		return object, err
+		return purge, err
+	}
+	stored := make(map[string]bool)
+	for _, file := range files {
+		stored[file.Name] = true
 	}
-	links, err := r.queryLinksByObjectID(id)
+
+	// uploads are named by uuid, and their previews, waveforms and cover art by uuid and a suffix
+	uuids := make(map[string]bool)
+	referenced := make(map[string]bool)
+	missingFiles := make(map[int]missingFile)
+	sql := `SELECT id, object_id, uuid, href, preview, waveform, created
+	FROM object_files`
+	rows, err := r.db.Query(context.Background(), sql)
 	if err != nil {
-		return object, err
+		return purge, err
 	}
-	if links != nil {
-		object.Url = links
+	for rows.Next() {
+		var id, object_id int
+		var uuid, href, preview, waveform string
+		var created time.Time
+		err = rows.Scan(&id, &object_id, &uuid, &href, &preview, &waveform, &created)
+		if err != nil {
+			rows.Close()
+			return purge, err
+		}
+		uuids[uuid] = true
+		name := path.Base(href)
+		referenced[name] = true
+		if preview != "" {
+			referenced[path.Base(preview)] = true
+		}
+		if waveform != "" {
+			referenced[path.Base(waveform)] = true
+		}
+		// links to media stored elsewhere aren't named by uuid
+		if strings.HasPrefix(name, uuid) && !stored[name] && created.Before(listed) {
+			missingFiles[id] = missingFile{objectID: object_id, name: name}
+		}
 	}
-	err = r.cache.Set(fmt.Sprintf("object-%d", id), object)
-	if err != nil {
-		log.Println(fmt.Sprintf("error setting cache %s", fmt.Sprintf("object-%d", id)))
+	rows.Close()
+	if rows.Err() != nil {
+		return purge, rows.Err()
 	}
 
-	return object, nil
-}
-
-// Create a new inbox Activity with basic details
-func (r *PSQLRepository) CreateInboxActivity(activityArb arb.Arb, objectArb arb.Arb, actor string, name string) (arb.Arb, error) {
-	ctx := context.Background()
-	tx, err := r.db.Begin(ctx)
+	sql = `SELECT icon, image
+	FROM users`
+	rows, err = r.db.Query(context.Background(), sql)
 	if err != nil {
-		return activityArb, err
+		return purge, err
 	}
-	objectIRI, _ := objectArb.GetString("id")
-	object_id, err := r.queryObjectID(objectIRI)
-	if err != nil {
-		sql := `INSERT INTO objects (iri, type, content, attributed_to, in_reply_to) 
-		VALUES ($1, $2, $3, $4, $5) RETURNING id;`
-		err = tx.QueryRow(ctx, sql,
-			objectArb["id"],
-			objectArb["type"],
-			objectArb["content"],
-			objectArb["attributedTo"],
-			objectArb["inReplyTo"],
-		).Scan(&object_id)
+	for rows.Next() {
+		var icon, image string
+		err = rows.Scan(&icon, &image)
 		if err != nil {
-			tx.Rollback(ctx)
-			return activityArb, err
+			rows.Close()
+			return purge, err
+		}
+		if icon != "" {
+			referenced[path.Base(icon)] = true
+		}
+		if image != "" {
+			referenced[path.Base(image)] = true
 		}
 	}
-	activityIRI, _ := activityArb.GetString("id")
-	activity_id, err := r.queryActivityID(activityIRI)
+	rows.Close()
+	if rows.Err() != nil {
+		return purge, rows.Err()
+	}
+
+	sql = `SELECT uuid
+	FROM pending_media
+	UNION
+	SELECT uuid
+	FROM chunked_uploads`
+	rows, err = r.db.Query(context.Background(), sql)
 	if err != nil {
-		sql := `INSERT INTO activities (type, actor, object_id, iri)
-		VALUES ($1, $2, $3, $4) RETURNING id;`
-		err = tx.QueryRow(ctx, sql, activityArb["type"], actor, object_id, activityArb["id"]).Scan(&activity_id)
+		return purge, err
+	}
+	for rows.Next() {
+		var uuid string
+		err = rows.Scan(&uuid)
 		if err != nil {
-			tx.Rollback(ctx)
-			return activityArb, err
+			rows.Close()
+			return purge, err
 		}
+		uuids[uuid] = true
 	}
-	iri := fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name)
-	if !r.ActivityToExists(activityIRI, iri) {
-		sql := `INSERT INTO activities_to (activity_id, iri) VALUES ($1, $2);`
-		_, err = tx.Exec(ctx, sql, activity_id, iri)
+	rows.Close()
+	if rows.Err() != nil {
+		return purge, rows.Err()
+	}
+
+	missingProxied := make(map[string]string)
+	sql = `SELECT url, name, created
+	FROM proxied_media`
+	rows, err = r.db.Query(context.Background(), sql)
+	if err != nil {
+		return purge, err
+	}
+	for rows.Next() {
+		var iri, name string
+		var created time.Time
+		err = rows.Scan(&iri, &name, &created)
 		if err != nil {
-			tx.Rollback(ctx)
-			return activityArb, err
+			rows.Close()
+			return purge, err
+		}
+		referenced[name] = true
+		if !stored[name] && created.Before(listed) {
+			missingProxied[iri] = name
 		}
 	}
-	err = tx.Commit(ctx)
+	rows.Close()
+	if rows.Err() != nil {
+		return purge, rows.Err()
+	}
+
+	cutoff := time.Now().Add(-grace)
+	for _, file := range files {
+		if referenced[file.Name] || len(file.Name) >= 36 && uuids[file.Name[:36]] {
+			continue
+		}
+		if file.Modified.After(cutoff) {
+			purge.Kept++
+			continue
+		}
+		if !dryRun {
+			err = storage.Delete(file.Name)
+			if err != nil {
+				log.Println(fmt.Sprintf("Failed to delete unused file %s: %s", file.Name, err.Error()))
+				continue
+			}
+		}
+		purge.Files++
+		purge.Bytes += file.Size
+	}
+
+	// an empty listing more likely means the storage is misconfigured than that every file is gone
+	if len(files) == 0 {
+		return purge, nil
+	}
+	for id, missing := range missingFiles {
+		if !isMissing(storage, missing.name) {
+			continue
+		}
+		if !dryRun {
+			sql = `DELETE FROM object_files
+			WHERE id = $1;`
+			_, err = r.db.Exec(context.Background(), sql, id)
+			if err != nil {
+				return purge, err
+			}
+			r.deleteObjectCacheInvalidation(missing.objectID)
+		}
+		purge.Rows++
+	}
+	for iri, name := range missingProxied {
+		if !isMissing(storage, name) {
+			continue
+		}
+		if !dryRun {
+			sql = `DELETE FROM proxied_media
+			WHERE url = $1;`
+			_, err = r.db.Exec(context.Background(), sql, iri)
+			if err != nil {
+				return purge, err
+			}
+			err = r.cache.Del(fmt.Sprintf("proxied-%s", iri))
+			if err != nil {
+				log.Println(fmt.Sprintf("error deleting cache %s", fmt.Sprintf("proxied-%s", iri)))
+			}
+		}
+		purge.Rows++
+	}
+	return purge, nil
+}
+
+func (r *PSQLRepository) CheckActivity(name string, activityType string, objectIRI string) string {
+	sql := `SELECT act.iri FROM activities act
+	JOIN objects obj ON obj.id = act.object_id
+	WHERE act.actor = $1
+	AND act.type = $2
+	AND act.iri NOT IN (
+		SELECT obj.iri FROM activities AS act
+		JOIN objects AS obj ON obj.id = act.object_id
+		WHERE act.type = 'Undo'
+	)
+	AND obj.iri = $3`
+	var iri string
+	_ = r.db.QueryRow(context.Background(), sql,
+		fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
+		activityType,
+		objectIRI,
+	).Scan(&iri)
+	return iri
+}
+
+func (r *PSQLRepository) QueryDomainBlocks() ([]models.DomainBlock, error) {
+	sql := `SELECT id, domain, created FROM domain_blocks
+	ORDER BY domain`
+
+	rows, err := r.db.Query(context.Background(), sql)
 	if err != nil {
 		return nil, err
 	}
-	err = r.cache.Del(
-		fmt.Sprintf("inbox-%s-*", name),
-		fmt.Sprintf("inbox-totalItems-%s", name),
-		fmt.Sprintf("feed-%s-*", name),
-		fmt.Sprintf("feed-totalItems-%s", name),
-	)
+	defer rows.Close()
+	blocks := make([]models.DomainBlock, 0)
+	for rows.Next() {
+		var block models.DomainBlock
+		err = rows.Scan(
+			&block.ID,
+			&block.Domain,
+			&block.Created,
+		)
+		if err != nil {
+			return blocks, err
+		}
+		blocks = append(blocks, block)
+	}
+	err = rows.Err()
 	if err != nil {
-		log.Println(fmt.Sprintf("error deleting cache %s and %s", fmt.Sprintf("inbox-%s-*", name), fmt.Sprintf("inbox-totalItems-%s", name)))
+		return blocks, err
 	}
-	// TODO: Invalidate other cache items based on activityArb["type"]
-	return activityArb, nil
+	return blocks, nil
 }
 
-// Create a new inbox Activity with basic details
-func (r *PSQLRepository) CreateInboxReferenceActivity(activityArb arb.Arb, object string, actor string, name string) (arb.Arb, error) {
-	// activityIRI, _ := activityArb.GetString("id")
-	ctx := context.Background()
-	tx, err := r.db.Begin(ctx)
+func (r *PSQLRepository) CreateDomainBlock(domain string) (models.DomainBlock, error) {
+	sql := `INSERT INTO domain_blocks (domain, created)
+	VALUES ($1, CURRENT_TIMESTAMP)
+	ON CONFLICT (domain) DO UPDATE SET domain = EXCLUDED.domain
+	RETURNING id, domain, created`
+
+	var block models.DomainBlock
+	err := r.db.QueryRow(context.Background(), sql, strings.ToLower(domain)).Scan(
+		&block.ID,
+		&block.Domain,
+		&block.Created,
+	)
 	if err != nil {
-		return activityArb, err
+		return block, err
 	}
-	object_id, err := r.queryObjectID(object)
+	r.deleteDomainBlockedCache(block.Domain)
+	return block, nil
+}
+
+func (r *PSQLRepository) DeleteDomainBlock(domain string) error {
+	sql := `DELETE FROM domain_blocks
+	WHERE domain = $1`
+
+	domain = strings.ToLower(domain)
+	tag, err := r.db.Exec(context.Background(), sql, domain)
 	if err != nil {
-		sql := `INSERT INTO objects (iri) 
-		VALUES ($1) RETURNING id;`
-		err = tx.QueryRow(ctx, sql, object).Scan(&object_id)
-		if err != nil {
-			tx.Rollback(ctx)
-			return activityArb, err
-		}
+		return err
 	}
-	activityIRI, _ := activityArb.GetString("id")
-	activity_id, err := r.queryActivityID(activityIRI)
+	if tag.RowsAffected() == 0 {
+		return errors.New("domain is not blocked")
+	}
+	r.deleteDomainBlockedCache(domain)
+	return nil
+}
+
+// deleteDomainBlockedCache invalidates the cached checks of a domain and its subdomains
+func (r *PSQLRepository) deleteDomainBlockedCache(domain string) {
+	err := r.cache.Del(fmt.Sprintf("domain-blocked-%s", domain), fmt.Sprintf("domain-blocked-*.%s", domain))
 	if err != nil {
-		sql := `INSERT INTO activities (type, actor, object_id, iri)
-		VALUES ($1, $2, $3, $4) RETURNING id;`
-		err = tx.QueryRow(ctx, sql, activityArb["type"], actor, object_id, activityArb["id"]).Scan(&activity_id)
-		if err != nil {
-			tx.Rollback(ctx)
-			return activityArb, err
-		}
+		log.Println(fmt.Sprintf("error deleting cache %s", fmt.Sprintf("domain-blocked-%s", domain)))
 	}
-	iri := fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name)
-	if !r.ActivityToExists(activityIRI, iri) {
-		sql := `INSERT INTO activities_to (activity_id, iri) VALUES ($1,$2);`
-		_, err = tx.Exec(ctx, sql, activity_id, iri)
-		if err != nil {
-			tx.Rollback(ctx)
-			return activityArb, err
-		}
+}
+
+// IsDomainBlocked checks whether a host, or a domain it is a subdomain of, is blocked. Callers
+// pass a hostname, but a port is dropped here as well.
+func (r *PSQLRepository) IsDomainBlocked(domain string) bool {
+	if host, _, err := net.SplitHostPort(domain); err == nil {
+		domain = host
 	}
-	tx.Commit(ctx)
+	domain = strings.TrimSuffix(strings.ToLower(domain), ".")
+	var blocked bool
+	_, err := r.cache.Get(fmt.Sprintf("domain-blocked-%s", domain), &blocked)
+	if err == nil {
+		return blocked
+	}
+
+	sql := `SELECT EXISTS (
+		SELECT 1 FROM domain_blocks
+		WHERE domain = $1
+		OR right($1, length(domain) + 1) = '.' || domain
+	)`
+	err = r.db.QueryRow(context.Background(), sql, domain).Scan(&blocked)
 	if err != nil {
-		return nil, err
+		log.Println(err)
+		return false
 	}
-	err = r.cache.Del(
-		fmt.Sprintf("inbox-%s-*", name),
-		fmt.Sprintf("inbox-totalItems-%s", name),
-		fmt.Sprintf("feed-%s-*", name),
-		fmt.Sprintf("feed-totalItems-%s", name),
-	)
+
+	err = r.cache.Set(fmt.Sprintf("domain-blocked-%s", domain), blocked)
 	if err != nil {
-		log.Println(fmt.Sprintf("error deleting cache %s and %s", fmt.Sprintf("inbox-%s-*", name), fmt.Sprintf("inbox-totalItems-%s", name)))
+		log.Println(fmt.Sprintf("error setting cache %s", fmt.Sprintf("domain-blocked-%s", domain)))
 	}
-	// TODO: Invalidate other cache items based on activityArb["type"]
-	return activityArb, nil
+	return blocked
 }
 
-func (r *PSQLRepository) queryObjectID(iri string) (int, error) {
-	sql := `SELECT id
-	FROM objects WHERE iri = $1;`
-	var object_id int
-	err := r.db.QueryRow(context.Background(), sql, iri).Scan(&object_id)
+func (r *PSQLRepository) QueryObjectAttributedTo(iri string) (string, error) {
+	sql := `SELECT COALESCE(attributed_to, '')
+	FROM objects WHERE iri = $1`
+	var attributedTo string
+	err := r.db.QueryRow(context.Background(), sql, iri).Scan(&attributedTo)
 	if err != nil {
-		return object_id, err
+		return attributedTo, err
 	}
-	return object_id, nil
+	return attributedTo, nil
 }
 
-func (r *PSQLRepository) queryActivityID(iri string) (int, error) {
-	sql := `SELECT id
-	FROM activities WHERE iri = $1;`
-	var activity_id int
-	err := r.db.QueryRow(context.Background(), sql, iri).Scan(&activity_id)
+// Create a Notification for the user, ignoring duplicates of the same activity
+func (r *PSQLRepository) CreateNotification(name string, notification models.Notification) (models.Notification, error) {
+	sql := `INSERT INTO notifications (user_id, type, actor, activity, object)
+	SELECT id, $2, $3, $4, $5 FROM users WHERE name = $1
+	ON CONFLICT (user_id, type, activity) DO NOTHING
+	RETURNING id, created`
+
+	err := r.db.QueryRow(context.Background(), sql,
+		name,
+		notification.Type,
+		notification.Actor,
+		notification.Activity,
+		notification.Object,
+	).Scan(
+		&notification.ID,
+		&notification.Created,
+	)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return notification, nil
+	}
 	if err != nil {
-		return activity_id, err
+		return notification, err
 	}
-	return activity_id, nil
+
+	err = r.broker.Publish(models.StreamEvent{
+		ID:           notification.ID,
+		Topic:        name,
+		Notification: &notification,
+	})
+	if err != nil {
+		log.Println(fmt.Sprintf("error publishing notification %d to %s: %s", notification.ID, name, err))
+	}
+	return notification, nil
 }
 
-func (r *PSQLRepository) CreateOutboxActivity(activityArb arb.Arb, objectArb arb.Arb, name string) (arb.Arb, error) {
-	ctx := context.Background()
-	tx, err := r.db.Begin(ctx)
+func (r *PSQLRepository) DeleteNotificationsByActivity(name string, actor string, activityIRI string) error {
+	sql := `DELETE FROM notifications
+	WHERE user_id = (SELECT id FROM users WHERE name = $1)
+	AND actor = $2
+	AND activity = $3`
+
+	_, err := r.db.Exec(context.Background(), sql, name, actor, activityIRI)
 	if err != nil {
-		return activityArb, err
+		return err
 	}
-	// TODO: Code here to prevent duplicate objects???
-	sql := `INSERT INTO objects (type, content, attributed_to, in_reply_to, name) 
-	VALUES ($1, $2, $3, $4, $5) RETURNING id;`
-	var object_id int
-	err = tx.QueryRow(ctx, sql,
-		objectArb["type"],
-		objectArb["content"],
-		objectArb["attributedTo"],
-		objectArb["inReplyTo"],
-		objectArb["name"],
-	).Scan(&object_id)
+	return nil
+}
+
+func (r *PSQLRepository) QueryNotificationsTotalItemsByUserName(name string) (int, error) {
+	sql := `SELECT COUNT(*)
+	FROM notifications
+	WHERE user_id = (SELECT id FROM users WHERE name = $1)`
+
+	var count int
+	err := r.db.QueryRow(context.Background(), sql, name).Scan(&count)
 	if err != nil {
-		tx.Rollback(ctx)
-		return activityArb, err
+		return count, err
 	}
-	objectArb["id"] = fmt.Sprintf("%s://%s/%s/%d", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Objects, object_id)
-	sql = `UPDATE objects
-	SET iri = $1
-	WHERE id = $2;`
-	_, err = tx.Exec(ctx, sql, objectArb["id"], object_id)
+	return count, nil
+}
+
+func (r *PSQLRepository) QueryNotificationsByUserName(name string, page models.Page) ([]models.Notification, []int, error) {
+	sql := `SELECT id, type, actor, activity, object, created, read
+	FROM notifications
+	WHERE user_id = (SELECT id FROM users WHERE name = $1)`
+
+	clause, args := r.pageClause("id", page, 2)
+	rows, err := r.db.Query(context.Background(), sql+clause,
+		append([]interface{}{name}, args...)...,
+	)
 	if err != nil {
-		tx.Rollback(ctx)
-		return activityArb, err
+		return nil, nil, err
 	}
-	if objectArb.Exists("url") {
-		fileArbs, err := objectArb.GetArbArray("url")
+	defer rows.Close()
+	var notifications []models.Notification
+	var ids []int
+	for rows.Next() {
+		var notification models.Notification
+		err = rows.Scan(
+			&notification.ID,
+			&notification.Type,
+			&notification.Actor,
+			&notification.Activity,
+			&notification.Object,
+			&notification.Created,
+			&notification.Read,
+		)
 		if err != nil {
-			tx.Rollback(ctx)
-			return activityArb, err
-		}
-		var urls []arb.Arb
-		for _, fileArb := range fileArbs {
-			sql = `INSERT INTO object_files (object_id, created, name, uuid, type, href, media_type) 
-			VALUES ($1, CURRENT_TIMESTAMP, $2, $3, $4, $5, $6);`
-			// TODO: return id to populate file iri
-			_, err = tx.Exec(ctx, sql,
-				object_id,
-				fileArb["name"],
-				fileArb["uuid"],
-				fileArb["type"],
-				fileArb["href"],
-				fileArb["mediaType"],
-			)
-			if err != nil {
-				tx.Rollback(ctx)
-				return activityArb, err
-			}
-			urls = append(urls, fileArb)
+			return notifications, ids, err
 		}
-		objectArb["url"] = urls
-	}
-	sql = `INSERT INTO activities (type, actor, object_id)
-	VALUES ($1, $2, $3) RETURNING id;`
-	var activity_id int
-	err = tx.QueryRow(ctx, sql, activityArb["type"], activityArb["actor"], object_id).Scan(&activity_id)
-	if err != nil {
-		tx.Rollback(ctx)
-		return activityArb, err
+		notifications = append(notifications, notification)
+		ids = append(ids, notification.ID)
 	}
-	activityArb["id"] = fmt.Sprintf("%s://%s/%s/%d", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Activities, activity_id)
-	sql = `UPDATE activities
-	SET iri = $1
-	WHERE id = $2;`
-	_, err = tx.Exec(ctx, sql, activityArb["id"], activity_id)
+	err = rows.Err()
 	if err != nil {
-		tx.Rollback(ctx)
-		return activityArb, err
+		return notifications, ids, err
 	}
-	// // Insert to records (need to do similar for bto, cc, bcc, and audience)
-	// valueStrings, valueArgs := createRecipientsInsert(activity_id, activityArb.To)
-	// sql = fmt.Sprintf("INSERT INTO activities_to (activity_id, iri) VALUES %s", strings.Join(valueStrings, ","))
-	// _, err = tx.Exec(ctx, sql, valueArgs...)
-	// if err != nil {
-	// 	tx.Rollback(ctx)
-	// 	return activityArb, err
-	// }
-	tx.Commit(ctx)
-	if err != nil {
-		return nil, err
+	reversePage(page, len(notifications), func(i, j int) {
+		notifications[i], notifications[j] = notifications[j], notifications[i]
+		ids[i], ids[j] = ids[j], ids[i]
+	})
+	return notifications, ids, nil
+}
+
+// Mark the given notifications (or all of them when ids is empty) as read
+func (r *PSQLRepository) UpdateNotificationsRead(name string, ids []int) (int64, error) {
+	sql := `UPDATE notifications SET read = now()
+	WHERE user_id = (SELECT id FROM users WHERE name = $1)
+	AND read IS NULL
+	AND (cardinality($2::int[]) = 0 OR id = ANY($2::int[]))`
+
+	if ids == nil {
+		ids = []int{}
 	}
-	err = r.cache.Del(fmt.Sprintf("outbox-%s-*", name), fmt.Sprintf("outbox-totalItems-%s", name))
+	tag, err := r.db.Exec(context.Background(), sql, name, ids)
 	if err != nil {
-		log.Println(fmt.Sprintf("error deleting cache %s and %s", fmt.Sprintf("outbox-%s-*", name), fmt.Sprintf("outbox-totalItems-%s", name)))
+		return 0, err
 	}
-	// TODO: Invalidate other cache items based on activityArb["type"]
-	return activityArb, nil
+	return tag.RowsAffected(), nil
 }
 
-func (r *PSQLRepository) CreateOutboxReferenceActivity(activityArb arb.Arb, name string) (arb.Arb, error) {
-	ctx := context.Background()
-	tx, err := r.db.Begin(ctx)
+// CreateRemoteObject stores a fetched remote object, adding it if it isn't known yet
+func (r *PSQLRepository) CreateRemoteObject(objectArb arb.Arb, attachments []models.Attachment) error {
+	objectIRI, err := objectArb.GetString("id")
 	if err != nil {
-		return activityArb, err
+		return err
 	}
-	objectIRI, _ := activityArb.GetString("object")
-	object_id, err := r.queryObjectID(objectIRI)
+	_, err = r.queryObjectID(objectIRI)
 	if err != nil {
 		sql := `INSERT INTO objects (iri) 
-		VALUES ($1) RETURNING id;`
-		err = tx.QueryRow(ctx, sql,
-			activityArb["object"],
-		).Scan(&object_id)
+		VALUES ($1);`
+		_, err = r.db.Exec(context.Background(), sql, objectIRI)
 		if err != nil {
-			tx.Rollback(ctx)
-			return activityArb, err
+			return err
 		}
 	}
-	sql := `INSERT INTO activities (type, actor, object_id)
-	VALUES ($1, $2, $3) RETURNING id;`
-	var activity_id int
-	err = tx.QueryRow(ctx, sql, activityArb["type"], activityArb["actor"], object_id).Scan(&activity_id)
-	if err != nil {
-		tx.Rollback(ctx)
-		return activityArb, err
+	return r.UpdateRemoteObject(objectArb, attachments)
+}
+
+// UpdateRemoteObject stores the content of a remote object previously known only by its iri
+func (r *PSQLRepository) UpdateRemoteObject(objectArb arb.Arb, attachments []models.Attachment) error {
+	var tag, attachment []byte
+	var err error
+	if objectArb.Exists("tag") {
+		tag, err = json.Marshal(objectArb["tag"])
+		if err != nil {
+			return err
+		}
 	}
-	activityArb["id"] = fmt.Sprintf("%s://%s/%s/%d", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Activities, activity_id)
-	sql = `UPDATE activities
-	SET iri = $1
-	WHERE id = $2;`
-	_, err = tx.Exec(ctx, sql, activityArb["id"], activity_id)
-	if err != nil {
-		tx.Rollback(ctx)
-		return activityArb, err
+	if attachments != nil {
+		attachment, err = json.Marshal(attachments)
+		if err != nil {
+			return err
+		}
 	}
-	// Insert to records (need to do similar for bto, cc, bcc, and audience)
-	// valueStrings, valueArgs := createRecipientsInsert(activity_id, activityArb.To)
-	// sql = fmt.Sprintf("INSERT INTO activities_to (activity_id, iri) VALUES %s", strings.Join(valueStrings, ","))
-	// _, err = tx.Exec(ctx, sql, valueArgs...)
-	// if err != nil {
-	// 	tx.Rollback(ctx)
-	// 	return activityArb, err
-	// }
-	tx.Commit(ctx)
-	if err != nil {
-		return nil, err
+	var published *time.Time
+	if p, err := objectArb.GetString("published"); err == nil {
+		if t, err := time.Parse(time.RFC3339, p); err == nil {
+			published = &t
+		}
 	}
-	err = r.cache.Del(fmt.Sprintf("outbox-%s-*", name), fmt.Sprintf("outbox-totalItems-%s", name))
+	sql := `UPDATE objects
+	SET type = $2,
+	content = $3,
+	attributed_to = $4,
+	in_reply_to = $5,
+	name = $6,
+	tag = $7,
+	attachment = $8,
+	published = coalesce($9, published)
+	WHERE iri = $1
+	RETURNING id`
+	var object_id int
+	err = r.db.QueryRow(context.Background(), sql,
+		objectArb["id"],
+		objectArb["type"],
+		objectArb["content"],
+		objectArb["attributedTo"],
+		objectArb["inReplyTo"],
+		objectArb["name"],
+		tag,
+		attachment,
+		published,
+	).Scan(&object_id)
 	if err != nil {
-		log.Println(fmt.Sprintf("error deleting cache %s and %s", fmt.Sprintf("outbox-%s-*", name), fmt.Sprintf("outbox-totalItems-%s", name)))
+		return err
 	}
-	// TODO: Invalidate other cache items based on activityArb["type"]
-	return activityArb, nil
+	r.deleteObjectCacheInvalidation(object_id)
+	return nil
 }
 
-func (r *PSQLRepository) ActivityToExists(activityIRI string, recipientIRI string) bool {
-	sql := `SELECT 1 from activities_to
-	WHERE activity_id = (SELECT id from activities WHERE iri = $1 LIMIT 1)
-	AND iri = $2`
-	var result int
-	_ = r.db.QueryRow(context.Background(), sql, activityIRI, recipientIRI).Scan(&result)
-	if result != 1 {
-		return false
+// SearchObjects finds the objects matching a query that are public, attributed to the user (iri)
+// or were delivered to them
+func (r *PSQLRepository) SearchObjects(iri string, query models.SearchQuery, page models.Page) ([]models.Object, []int, error) {
+	sql := `SELECT obj.id
+	FROM objects AS obj
+	WHERE obj.search @@ websearch_to_tsquery('simple', $1)
+	AND obj.type IS DISTINCT FROM 'Tombstone'
+	AND (
+		obj.public
+		OR obj.attributed_to = $2
+		OR obj.id IN (
+			SELECT act.object_id
+			FROM activities AS act
+			JOIN activities_to AS act_to ON act_to.activity_id = act.id
+			WHERE act_to.iri = $2
+		)
+	)`
+	args := []interface{}{query.Query, iri}
+	if query.Author != "" {
+		args = append(args, query.Author)
+		sql += fmt.Sprintf(`
+	AND obj.attributed_to = $%d`, len(args))
 	}
-	log.Println(fmt.Sprintf("%s to %s exists", activityIRI, recipientIRI))
-	return true
-}
-
-func (r *PSQLRepository) AddActivityTo(activityIRI string, recipient string) error {
-	sql := `INSERT INTO activities_to (activity_id, iri) 
-	VALUES (
-		(SELECT id FROM activities WHERE iri = $1 LIMIT 1),
-		$2
-	);`
-	_, err := r.db.Exec(context.Background(), sql, activityIRI, recipient)
-	if err != nil {
-		return err
+	if query.Type != "" {
+		args = append(args, query.Type)
+		sql += fmt.Sprintf(`
+	AND obj.type = $%d`, len(args))
 	}
-	// If local clear inbox cache of user
-	if utils.IsFromHost(recipient, r.conf.ServerName) {
-		name := strings.TrimPrefix(recipient, fmt.Sprintf("%s://%s/%s/", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users))
-		return r.cache.Del(
-			fmt.Sprintf("inbox-%s-*", name),
-			fmt.Sprintf("inbox-totalItems-%s", name),
-			fmt.Sprintf("feed-%s-*", name),
-			fmt.Sprintf("feed-totalItems-%s", name),
-		)
+	if query.Since != nil {
+		args = append(args, *query.Since)
+		sql += fmt.Sprintf(`
+	AND obj.published >= $%d`, len(args))
+	}
+	if query.Until != nil {
+		args = append(args, *query.Until)
+		sql += fmt.Sprintf(`
+	AND obj.published < $%d`, len(args))
 	}
-	return nil
-}
 
-func (r *PSQLRepository) DeleteActivity(activityArb arb.Arb, name string) (arb.Arb, error) {
-	ctx := context.Background()
-	tx, err := r.db.Begin(ctx)
+	clause, pageArgs := r.pageClause("obj.id", page, len(args)+1)
+	rows, err := r.db.Query(context.Background(), sql+clause, append(args, pageArgs...)...)
 	if err != nil {
-		return activityArb, err
+		return nil, nil, err
 	}
-	objectIRI, _ := activityArb.GetString("object")
-	object_id, err := r.queryObjectID(objectIRI)
-	if err != nil {
-		sql := `INSERT INTO objects (iri) 
-		VALUES ($1) RETURNING id;`
-		err = tx.QueryRow(ctx, sql,
-			activityArb["object"],
-		).Scan(&object_id)
+	var ids []int
+	for rows.Next() {
+		var object_id int
+		err = rows.Scan(&object_id)
 		if err != nil {
-			tx.Rollback(ctx)
-			return activityArb, err
+			rows.Close()
+			return nil, ids, err
 		}
+		ids = append(ids, object_id)
 	}
-	sql := `INSERT INTO activities (type, actor, object_id)
-	VALUES ($1, $2, $3) RETURNING id;`
-	var activity_id int
-	err = tx.QueryRow(ctx, sql, activityArb["type"], activityArb["actor"], object_id).Scan(&activity_id)
+	rows.Close()
+	err = rows.Err()
 	if err != nil {
-		tx.Rollback(ctx)
-		return activityArb, err
+		return nil, ids, err
 	}
-	activityArb["id"] = fmt.Sprintf("%s://%s/%s/%d", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Activities, activity_id)
-	sql = `UPDATE activities
-	SET iri = $1
-	WHERE id = $2;`
-	_, err = tx.Exec(ctx, sql, activityArb["id"], activity_id)
-	if err != nil {
-		tx.Rollback(ctx)
-		return activityArb, err
+	var objects []models.Object
+	for _, object_id := range ids {
+		object, err := r.QueryObject(object_id)
+		if err != nil {
+			return objects, ids, err
+		}
+		objects = append(objects, object)
 	}
-	sql = `UPDATE objects
-	SET type = 'Tombstone',
-	content = NULL,
-	name = NULL
-	WHERE id = $1;`
-	_, err = tx.Exec(ctx, sql, object_id)
+	reversePage(page, len(objects), func(i, j int) {
+		objects[i], objects[j] = objects[j], objects[i]
+		ids[i], ids[j] = ids[j], ids[i]
+	})
+	return objects, ids, nil
+}
+
+// TagObject records whether an object is public and links it to its hashtags, adding any that are new.
+// Public objects newly linked to hashtags are published to the hashtag streams, and their Create
+// activity to the feeds of the users following the hashtags.
+func (r *PSQLRepository) TagObject(objectIRI string, activityArb arb.Arb, objectArb arb.Arb, hashtags []string, public bool) error {
+	ctx := context.Background()
+	tx, err := r.db.Begin(ctx)
 	if err != nil {
-		tx.Rollback(ctx)
-		return activityArb, err
+		return err
 	}
-	sql = `DELETE FROM object_files
-	WHERE object_id = $1;`
-	_, err = tx.Exec(ctx, sql, object_id)
+	sql := `UPDATE objects
+	SET public = $2
+	WHERE iri = $1
+	RETURNING id`
+	var object_id int
+	var tagged bool
+	err = tx.QueryRow(ctx, sql, objectIRI, public).Scan(&object_id)
 	if err != nil {
 		tx.Rollback(ctx)
-		return activityArb, err
+		return err
 	}
-	tx.Commit(ctx)
+	if len(hashtags) > 0 {
+		sql = `INSERT INTO hashtags (name)
+		SELECT unnest($1::text[])
+		ON CONFLICT (name) DO NOTHING`
+		_, err = tx.Exec(ctx, sql, hashtags)
+		if err != nil {
+			tx.Rollback(ctx)
+			return err
+		}
+		sql = `INSERT INTO object_hashtags (object_id, hashtag_id)
+		SELECT $1, id FROM hashtags WHERE name = ANY($2::text[])
+		ON CONFLICT DO NOTHING`
+		tag, err := tx.Exec(ctx, sql, object_id, hashtags)
+		if err != nil {
+			tx.Rollback(ctx)
+			return err
+		}
+		// an object is tagged again for each local recipient, only the first time is new
+		tagged = tag.RowsAffected() > 0
+	}
+	err = tx.Commit(ctx)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	r.deleteObjectCacheInvalidation(object_id)
-	err = r.cache.Del(fmt.Sprintf("outbox-%s-*", name), fmt.Sprintf("outbox-totalItems-%s", name))
+	if public {
+		r.deleteHashtagCaches(hashtags)
+		if tagged {
+			r.publishHashtagObject(object_id, objectArb, hashtags)
+			r.publishHashtagFeeds(activityArb, hashtags)
+		}
+	}
+	return nil
+}
+
+// Push a newly tagged public Object to the streams of its hashtags
+func (r *PSQLRepository) publishHashtagObject(object_id int, objectArb arb.Arb, hashtags []string) {
+	err := r.broker.Publish(models.StreamEvent{
+		ID:     object_id,
+		Topic:  streams.HashtagTopic,
+		Object: objectArb,
+		Tags:   hashtags,
+	})
 	if err != nil {
-		log.Println(fmt.Sprintf("error deleting cache %s and %s", fmt.Sprintf("outbox-%s-*", name), fmt.Sprintf("outbox-totalItems-%s", name)))
+		log.Println(fmt.Sprintf("error publishing object %d to hashtags %s: %s", object_id, strings.Join(hashtags, ", "), err))
 	}
-	// TODO: Invalidate other cache items based on activityArb["type"]
-	return activityArb, nil
 }
 
-func (r *PSQLRepository) deleteObjectCacheInvalidation(object_id int) {
-	// Invalidate cached Object
-	err := r.cache.Del(fmt.Sprintf("object-%d", object_id))
+// Push a newly tagged public post's Create activity to the feeds of the users following its
+// hashtags. Those following its actor already have it in their feed from their inbox.
+func (r *PSQLRepository) publishHashtagFeeds(activityArb arb.Arb, hashtags []string) {
+	activityIRI, _ := activityArb.GetString("id")
+	actor, _ := activityArb.GetString("actor")
+	if activityType, _ := activityArb.GetString("type"); activityType != "Create" || activityIRI == "" {
+		return
+	}
+	ctx := context.Background()
+	sql := `SELECT id FROM activities
+	WHERE iri = $1
+	AND type = 'Create'`
+	var activity_id int
+	err := r.db.QueryRow(ctx, sql, activityIRI).Scan(&activity_id)
 	if err != nil {
-		log.Println(fmt.Sprintf("error deleting cache %s", fmt.Sprintf("object-%d", object_id)))
+		log.Println(fmt.Sprintf("error finding activity %s: %s", activityIRI, err))
+		return
 	}
-	// Invalidate cached Activities referencing this Object
-	activity_ids, err := r.getActivityIDsWithObjectID(object_id)
+	sql = `SELECT DISTINCT u.name
+	FROM users AS u
+	JOIN followed_hashtags AS fol_tag ON fol_tag.user_id = u.id
+	JOIN hashtags AS h ON h.id = fol_tag.hashtag_id
+	WHERE h.name = ANY($1::text[])
+	AND u.deleted IS NULL`
+	rows, err := r.db.Query(ctx, sql, hashtags)
 	if err != nil {
-		log.Println(fmt.Sprintf("error getting activities referencing object %d: %v", object_id, err))
+		log.Println(err)
+		return
 	}
-	var activity_keys []string
-	for i := range activity_ids {
-		activity_keys = append(activity_keys, fmt.Sprintf("activity-%d", activity_ids[i]))
+	var names []string
+	for rows.Next() {
+		var name string
+		if rows.Scan(&name) == nil {
+			names = append(names, name)
+		}
 	}
-	err = r.cache.Del(activity_keys...)
-	if err != nil {
-		log.Println(fmt.Sprintf("error deleting cached activities referencing object %d: %v", object_id, err))
+	rows.Close()
+	for _, name := range names {
+		if r.CheckActivity(name, "Follow", actor) != "" {
+			continue
+		}
+		err = r.broker.Publish(models.StreamEvent{
+			ID:       activity_id,
+			Topic:    name,
+			Feed:     true,
+			FeedOnly: true,
+			Activity: activityArb,
+		})
+		if err != nil {
+			log.Println(fmt.Sprintf("error publishing activity %d to %s: %s", activity_id, name, err))
+		}
 	}
-	// Invalidate cached actor inboxes with Activities referencing this Object
-	actors_receiving, err := r.getActorsReceivingObjectID(object_id)
-	if err != nil {
-		log.Println(fmt.Sprintf("error getting actors receiving object %d: %v", object_id, err))
+}
+
+// deleteHashtagCaches invalidates the timelines of hashtags and the feeds of users following them
+func (r *PSQLRepository) deleteHashtagCaches(names []string) {
+	if len(names) == 0 {
+		return
 	}
-	var actors_receiving_keys []string
-	for i := range actors_receiving {
-		actors_receiving_keys = append(actors_receiving_keys, fmt.Sprintf("inbox-%s-*", actors_receiving[i]), fmt.Sprintf("inbox-totalItems-%s", actors_receiving[i]))
+	var patterns []string
+	for _, name := range names {
+		patterns = append(patterns, fmt.Sprintf("tag-%s-*", name), fmt.Sprintf("tag-totalItems-%s", name))
 	}
-	err = r.cache.Del(actors_receiving_keys...)
+	sql := `SELECT DISTINCT u.name
+	FROM users AS u
+	JOIN followed_hashtags AS fol_tag ON fol_tag.user_id = u.id
+	JOIN hashtags AS h ON h.id = fol_tag.hashtag_id
+	WHERE h.name = ANY($1::text[])`
+	rows, err := r.db.Query(context.Background(), sql, names)
 	if err != nil {
-		log.Println(fmt.Sprintf("error deleting cached actor inboxes referencing object %d: %v", object_id, err))
+		log.Println(err)
+	} else {
+		for rows.Next() {
+			var name string
+			if rows.Scan(&name) == nil {
+				patterns = append(patterns, fmt.Sprintf("feed-%s-*", name), fmt.Sprintf("feed-totalItems-%s", name))
+			}
+		}
+		rows.Close()
 	}
-	// Invalidate cached actor inboxes with Activities referencing this Object
-	actors_sending, err := r.getActorsSendingObjectID(object_id)
+	err = r.cache.Del(patterns...)
 	if err != nil {
-		log.Println(fmt.Sprintf("error getting actors sending object %d: %v", object_id, err))
+		log.Println(fmt.Sprintf("error deleting cache %s", strings.Join(patterns, " ")))
 	}
-	var actors_sending_keys []string
-	for i := range actors_receiving {
-		actors_sending_keys = append(actors_sending_keys, fmt.Sprintf("outbox-%s-*", actors_sending[i]), fmt.Sprintf("outbox-totalItems-%s", actors_sending[i]))
+}
+
+func (r *PSQLRepository) QueryHashtagTotalItems(tag string) (int, error) {
+	tag = strings.ToLower(tag)
+	var count int
+	_, err := r.cache.Get(fmt.Sprintf("tag-totalItems-%s", tag), &count)
+	if err == nil {
+		return count, nil
 	}
-	err = r.cache.Del(actors_sending_keys...)
+	log.Println(fmt.Sprintf("no cached %s", fmt.Sprintf("tag-totalItems-%s", tag)))
+
+	sql := `SELECT COUNT(obj.*)
+	FROM objects AS obj
+	JOIN object_hashtags AS obj_tag ON obj_tag.object_id = obj.id
+	JOIN hashtags AS h ON h.id = obj_tag.hashtag_id
+	WHERE h.name = $1
+	AND obj.public
+	AND obj.type IS DISTINCT FROM 'Tombstone'`
+
+	err = r.db.QueryRow(context.Background(), sql, tag).Scan(&count)
 	if err != nil {
-		log.Println(fmt.Sprintf("error deleting cached actor outboxes referencing object %d: %v", object_id, err))
+		return count, err
+	}
+
+	err = r.cache.Set(fmt.Sprintf("tag-totalItems-%s", tag), count)
+	if err != nil {
+		log.Println(fmt.Sprintf("error setting cache %s", fmt.Sprintf("tag-totalItems-%s", tag)))
 	}
+
+	return count, nil
 }
 
-func (r *PSQLRepository) getActivityIDsWithObjectID(object_id int) ([]int, error) {
-	sql := `SELECT id
-	FROM activities
-	WHERE object_id = $1`
+func (r *PSQLRepository) QueryObjectsByHashtag(tag string, page models.Page) ([]models.Object, []int, error) {
+	tag = strings.ToLower(tag)
+	var cached objectPage
+	r.cache.Get(fmt.Sprintf("tag-%s-%s", tag, page.Query()), &cached)
+	if cached.Objects != nil {
+		return cached.Objects, cached.IDs, nil
+	}
+	log.Println(fmt.Sprintf("no cached %s", fmt.Sprintf("tag-%s-%s", tag, page.Query())))
 
-	rows, err := r.db.Query(context.Background(), sql, object_id)
+	sql := `SELECT obj.id
+	FROM objects AS obj
+	JOIN object_hashtags AS obj_tag ON obj_tag.object_id = obj.id
+	JOIN hashtags AS h ON h.id = obj_tag.hashtag_id
+	WHERE h.name = $1
+	AND obj.public
+	AND obj.type IS DISTINCT FROM 'Tombstone'`
+
+	clause, args := r.pageClause("obj.id", page, 2)
+	rows, err := r.db.Query(context.Background(), sql+clause,
+		append([]interface{}{tag}, args...)...,
+	)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	var activity_ids []int
-	defer rows.Close()
+	var ids []int
 	for rows.Next() {
-		var activity_id int
-		err = rows.Scan(&activity_id)
+		var object_id int
+		err = rows.Scan(&object_id)
 		if err != nil {
-			return activity_ids, err
+			rows.Close()
+			return nil, ids, err
 		}
-		activity_ids = append(activity_ids, activity_id)
+		ids = append(ids, object_id)
 	}
+	rows.Close()
 	err = rows.Err()
 	if err != nil {
-		return activity_ids, err
+		return nil, ids, err
 	}
-	return activity_ids, nil
+	var objects []models.Object
+	for _, object_id := range ids {
+		object, err := r.QueryObject(object_id)
+		if err != nil {
+			return objects, ids, err
+		}
+		objects = append(objects, object)
+	}
+	reversePage(page, len(objects), func(i, j int) {
+		objects[i], objects[j] = objects[j], objects[i]
+		ids[i], ids[j] = ids[j], ids[i]
+	})
+
+	err = r.cache.Set(fmt.Sprintf("tag-%s-%s", tag, page.Query()), objectPage{Objects: objects, IDs: ids})
+	if err != nil {
+		log.Println(fmt.Sprintf("error setting cache %s", fmt.Sprintf("tag-%s-%s", tag, page.Query())))
+	}
+
+	return objects, ids, nil
 }
 
-func (r *PSQLRepository) getActorsReceivingObjectID(object_id int) ([]string, error) {
-	sql := `SELECT DISTINCT usr.name
-	FROM activities_to AS act_to
-	JOIN activities AS act ON act.id = act_to.activity_id
-	JOIN objects AS obj ON obj.id = act.object_id
-	JOIN users AS usr ON usr.iri = act_to.iri
-	WHERE obj.id = $1`
+func (r *PSQLRepository) QueryFollowedHashtagsByUserName(name string) ([]models.Hashtag, error) {
+	sql := `SELECT h.name, fol_tag.created
+	FROM followed_hashtags AS fol_tag
+	JOIN hashtags AS h ON h.id = fol_tag.hashtag_id
+	WHERE fol_tag.user_id = (SELECT id FROM users WHERE name = $1)
+	ORDER BY h.name`
 
-	rows, err := r.db.Query(context.Background(), sql, object_id)
+	rows, err := r.db.Query(context.Background(), sql, name)
 	if err != nil {
 		return nil, err
 	}
-	var actors []string
 	defer rows.Close()
+	hashtags := []models.Hashtag{}
 	for rows.Next() {
-		var actor string
-		err = rows.Scan(&actor)
+		var tag string
+		var followed time.Time
+		err = rows.Scan(&tag, &followed)
 		if err != nil {
-			return actors, err
+			return hashtags, err
 		}
-		actors = append(actors, actor)
+		hashtag := r.newHashtag(tag)
+		hashtag.Followed = &followed
+		hashtags = append(hashtags, hashtag)
 	}
-	err = rows.Err()
+	return hashtags, rows.Err()
+}
+
+func (r *PSQLRepository) CreateFollowedHashtag(name string, tag string) (models.Hashtag, error) {
+	tag = strings.ToLower(tag)
+	hashtag := r.newHashtag(tag)
+	ctx := context.Background()
+	tx, err := r.db.Begin(ctx)
 	if err != nil {
-		return actors, err
+		return hashtag, err
 	}
-	return actors, nil
+	sql := `INSERT INTO hashtags (name)
+	VALUES ($1)
+	ON CONFLICT (name) DO NOTHING`
+	_, err = tx.Exec(ctx, sql, tag)
+	if err != nil {
+		tx.Rollback(ctx)
+		return hashtag, err
+	}
+	sql = `INSERT INTO followed_hashtags (user_id, hashtag_id, created)
+	SELECT u.id, h.id, CURRENT_TIMESTAMP
+	FROM users AS u, hashtags AS h
+	WHERE u.name = $1 AND h.name = $2
+	ON CONFLICT (user_id, hashtag_id) DO UPDATE SET user_id = EXCLUDED.user_id
+	RETURNING created`
+	var followed time.Time
+	err = tx.QueryRow(ctx, sql, name, tag).Scan(&followed)
+	if err != nil {
+		tx.Rollback(ctx)
+		return hashtag, err
+	}
+	err = tx.Commit(ctx)
+	if err != nil {
+		return hashtag, err
+	}
+	hashtag.Followed = &followed
+	err = r.cache.Del(fmt.Sprintf("feed-%s-*", name), fmt.Sprintf("feed-totalItems-%s", name))
+	if err != nil {
+		log.Println(fmt.Sprintf("error deleting cache %s and %s", fmt.Sprintf("feed-%s-*", name), fmt.Sprintf("feed-totalItems-%s", name)))
+	}
+	return hashtag, nil
 }
 
-func (r *PSQLRepository) getActorsSendingObjectID(object_id int) ([]string, error) {
-	sql := `SELECT DISTINCT usr.name
-	FROM activities AS act
-	JOIN objects AS obj ON obj.id = act.object_id
-	JOIN users AS usr ON usr.iri = act.actor
-	WHERE obj.id = $1`
+func (r *PSQLRepository) DeleteFollowedHashtag(name string, tag string) error {
+	sql := `DELETE FROM followed_hashtags
+	WHERE user_id = (SELECT id FROM users WHERE name = $1)
+	AND hashtag_id = (SELECT id FROM hashtags WHERE name = $2)`
 
-	rows, err := r.db.Query(context.Background(), sql, object_id)
+	tag = strings.ToLower(tag)
+	result, err := r.db.Exec(context.Background(), sql, name, tag)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	var actors []string
-	defer rows.Close()
-	for rows.Next() {
-		var actor string
-		err = rows.Scan(&actor)
-		if err != nil {
-			return actors, err
-		}
-		actors = append(actors, actor)
+	if result.RowsAffected() == 0 {
+		return errors.New("hashtag is not followed")
 	}
-	err = rows.Err()
+	err = r.cache.Del(fmt.Sprintf("feed-%s-*", name), fmt.Sprintf("feed-totalItems-%s", name))
 	if err != nil {
-		return actors, err
+		log.Println(fmt.Sprintf("error deleting cache %s and %s", fmt.Sprintf("feed-%s-*", name), fmt.Sprintf("feed-totalItems-%s", name)))
 	}
-	return actors, nil
+	return nil
 }
 
-func (r *PSQLRepository) GetObjectFilesByIRI(objectIRI string) ([]string, error) {
-	sql := `SELECT f.href
-	FROM object_files AS f
-	JOIN objects AS obj ON obj.id = f.object_id
-	WHERE obj.iri = $1`
+func (r *PSQLRepository) newHashtag(tag string) models.Hashtag {
+	return models.Hashtag{
+		Type: "Hashtag",
+		Href: fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Tags, url.PathEscape(tag)),
+		Name: "#" + tag,
+	}
+}
 
-	rows, err := r.db.Query(context.Background(), sql, objectIRI)
+// QueryProxiedMedia finds the cached copy of remote media
+func (r *PSQLRepository) QueryProxiedMedia(url string) (models.ProxiedMedia, error) {
+	var proxied models.ProxiedMedia
+	_, err := r.cache.Get(fmt.Sprintf("proxied-%s", url), &proxied)
+	if err == nil && proxied.Name != "" {
+		return proxied, nil
+	}
+	log.Println(fmt.Sprintf("no cached %s", fmt.Sprintf("proxied-%s", url)))
+
+	sql := `SELECT url, name, media_type, size, created
+	FROM proxied_media
+	WHERE url = $1`
+	err = r.db.QueryRow(context.Background(), sql, url).Scan(
+		&proxied.URL,
+		&proxied.Name,
+		&proxied.MediaType,
+		&proxied.Size,
+		&proxied.Created,
+	)
 	if err != nil {
-		return nil, err
+		return proxied, err
+	}
+	err = r.cache.Set(fmt.Sprintf("proxied-%s", url), proxied)
+	if err != nil {
+		log.Println(fmt.Sprintf("error setting cache %s", fmt.Sprintf("proxied-%s", url)))
+	}
+	return proxied, nil
+}
+
+// CreateProxiedMedia records the cached copy of remote media
+func (r *PSQLRepository) CreateProxiedMedia(proxied models.ProxiedMedia) error {
+	sql := `INSERT INTO proxied_media (url, name, media_type, size)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (url) DO UPDATE SET name = $2, media_type = $3, size = $4, created = now()`
+	_, err := r.db.Exec(context.Background(), sql,
+		proxied.URL,
+		proxied.Name,
+		proxied.MediaType,
+		proxied.Size,
+	)
+	return err
+}
+
+// CreatePendingMedia records an upload waiting to be attached to a post
+func (r *PSQLRepository) CreatePendingMedia(name string, pending models.PendingMedia) error {
+	attachment, err := json.Marshal(pending.Attachment)
+	if err != nil {
+		return err
+	}
+	sql := `INSERT INTO pending_media (uuid, user_id, attachment, title, artist, expires)
+	SELECT $2, u.id, $3, $4, $5, $6
+	FROM users AS u
+	WHERE u.name = $1`
+	tag, err := r.db.Exec(context.Background(), sql,
+		name,
+		pending.ID,
+		attachment,
+		pending.Title,
+		pending.Artist,
+		pending.Expires,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("user %s not found", name)
+	}
+	return nil
+}
+
+// QueryPendingMedia finds a user's unexpired uploads waiting to be attached to a post
+func (r *PSQLRepository) QueryPendingMedia(name string, ids []string) ([]models.PendingMedia, error) {
+	var pending []models.PendingMedia
+	sql := `SELECT p.uuid, p.attachment, p.title, p.artist, p.expires
+	FROM pending_media AS p
+	JOIN users AS u ON u.id = p.user_id
+	WHERE u.name = $1
+	AND p.uuid = ANY($2)
+	AND p.expires > now()`
+	rows, err := r.db.Query(context.Background(), sql, name, ids)
+	if err != nil {
+		return pending, err
 	}
 	defer rows.Close()
-	var hrefs []string
-	err = rows.Err()
+	for rows.Next() {
+		var item models.PendingMedia
+		var attachment []byte
+		err = rows.Scan(&item.ID, &attachment, &item.Title, &item.Artist, &item.Expires)
+		if err != nil {
+			return pending, err
+		}
+		err = json.Unmarshal(attachment, &item.Attachment)
+		if err != nil {
+			return pending, err
+		}
+		pending = append(pending, item)
+	}
+	return pending, rows.Err()
+}
+
+// PurgeExpiredMedia deletes uploads that were never attached to a post, and chunked uploads
+// that were never completed, before they expired, along with their files
+func (r *PSQLRepository) PurgeExpiredMedia(storage media.Storage) (int, error) {
+	sql := `DELETE FROM pending_media
+	WHERE expires <= now()
+	RETURNING attachment`
+	rows, err := r.db.Query(context.Background(), sql)
 	if err != nil {
-		return hrefs, err
+		return 0, err
 	}
+	var attachments []map[string]interface{}
 	for rows.Next() {
-		var href string
-		err = rows.Scan(&href)
+		var b []byte
+		err = rows.Scan(&b)
 		if err != nil {
-			return hrefs, err
+			rows.Close()
+			return 0, err
+		}
+		var attachment map[string]interface{}
+		if json.Unmarshal(b, &attachment) == nil {
+			attachments = append(attachments, attachment)
 		}
-		hrefs = append(hrefs, href)
 	}
-	return hrefs, nil
+	rows.Close()
+	if rows.Err() != nil {
+		return 0, rows.Err()
+	}
+	for _, attachment := range attachments {
+		hrefs := []string{}
+		if href, ok := attachment["url"].(string); ok {
+			hrefs = append(hrefs, href)
+		}
+		if preview, ok := attachment["preview"].(map[string]interface{}); ok {
+			if href, ok := preview["url"].(string); ok {
+				hrefs = append(hrefs, href)
+			}
+		}
+		if href, ok := attachment["waveform"].(string); ok {
+			hrefs = append(hrefs, href)
+		}
+		for _, href := range hrefs {
+			if err := storage.Delete(path.Base(href)); err != nil && !os.IsNotExist(err) {
+				log.Println(err)
+			}
+		}
+	}
+	count := len(attachments)
+
+	sql = `DELETE FROM chunked_uploads
+	WHERE expires <= now()
+	RETURNING uuid, parts`
+	rows, err = r.db.Query(context.Background(), sql)
+	if err != nil {
+		return count, err
+	}
+	var parts []string
+	for rows.Next() {
+		var uuid string
+		var names []string
+		err = rows.Scan(&uuid, &names)
+		if err != nil {
+			rows.Close()
+			return count, err
+		}
+		parts = append(parts, names...)
+		count++
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return count, rows.Err()
+	}
+	for _, part := range parts {
+		if err := storage.Delete(part); err != nil && !os.IsNotExist(err) {
+			log.Println(err)
+		}
+	}
+	return count, nil
 }
 
-func (r *PSQLRepository) PurgeUnusedFiles() error {
-	// TODO: Implement this
-	// Need to somehow determine if a file on disk is orphaned
+// CreateChunkedUpload records the start of a chunked upload
+func (r *PSQLRepository) CreateChunkedUpload(name string, upload models.ChunkedUpload) error {
+	sql := `INSERT INTO chunked_uploads (uuid, user_id, name, length, expires)
+	SELECT $2, u.id, $3, $4, $5
+	FROM users AS u
+	WHERE u.name = $1`
+	tag, err := r.db.Exec(context.Background(), sql,
+		name,
+		upload.ID,
+		upload.Name,
+		upload.Length,
+		upload.Expires,
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("user %s not found", name)
+	}
 	return nil
 }
 
-func (r *PSQLRepository) CheckActivity(name string, activityType string, objectIRI string) string {
-	sql := `SELECT act.iri FROM activities act
-	JOIN objects obj ON obj.id = act.object_id
-	WHERE act.actor = $1
-	AND act.type = $2
-	AND act.iri NOT IN (
-		SELECT obj.iri FROM activities AS act
-		JOIN objects AS obj ON obj.id = act.object_id
-		WHERE act.type = 'Undo'
+// QueryChunkedUpload finds a user's unexpired chunked upload
+func (r *PSQLRepository) QueryChunkedUpload(name string, id string) (models.ChunkedUpload, error) {
+	var upload models.ChunkedUpload
+	sql := `SELECT c.uuid, c.name, c.media_type, c.length, c."offset", c.parts, c.expires
+	FROM chunked_uploads AS c
+	JOIN users AS u ON u.id = c.user_id
+	WHERE u.name = $1
+	AND c.uuid = $2
+	AND c.expires > now()`
+	err := r.db.QueryRow(context.Background(), sql, name, id).Scan(
+		&upload.ID,
+		&upload.Name,
+		&upload.MediaType,
+		&upload.Length,
+		&upload.Offset,
+		&upload.Parts,
+		&upload.Expires,
 	)
-	AND obj.iri = $3`
-	var iri string
-	_ = r.db.QueryRow(context.Background(), sql,
-		fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
-		activityType,
-		objectIRI,
-	).Scan(&iri)
-	return iri
+	return upload, err
+}
+
+// UpdateChunkedUpload records a chunk stored as part at offset, reporting false if another
+// request recorded a chunk there first
+func (r *PSQLRepository) UpdateChunkedUpload(id string, offset int64, size int64, part string, mediaType string) (bool, error) {
+	sql := `UPDATE chunked_uploads
+	SET "offset" = "offset" + $3,
+	parts = array_append(parts, $4::text),
+	media_type = CASE WHEN media_type = '' THEN $5 ELSE media_type END
+	WHERE uuid = $1
+	AND "offset" = $2`
+	tag, err := r.db.Exec(context.Background(), sql, id, offset, size, part, mediaType)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// DeleteChunkedUpload removes the record of a chunked upload, leaving its parts to the caller
+func (r *PSQLRepository) DeleteChunkedUpload(id string) error {
+	sql := `DELETE FROM chunked_uploads
+	WHERE uuid = $1`
+	_, err := r.db.Exec(context.Background(), sql, id)
+	return err
 }
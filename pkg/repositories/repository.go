@@ -1,7 +1,10 @@
 package repositories
 
 import (
+	"time"
+
 	"github.com/cheebz/arb"
+	"github.com/cheebz/go-pub/pkg/media"
 	"github.com/cheebz/go-pub/pkg/models"
 )
 
@@ -9,19 +12,32 @@ type Repository interface {
 	Close()
 	QueryUserByName(name string) (models.User, error)
 	CheckUser(name string) error
-	CreateUser(name string) (string, error)
+	CreateUser(name string, actorType string) (string, error)
+	UpdateUserActorType(name string, actorType string) (models.User, error)
+	QueryUsers() ([]models.User, error)
+	UpdateUserSuspended(name string, suspended bool) (models.User, error)
+	ToggleUserDiscoverable(name string) (models.User, error)
+	UpdateUserAlsoKnownAs(name string, alsoKnownAs []string) (models.User, error)
+	UpdateUserMovedTo(name string, movedTo string) (models.User, error)
+	UpdateUserProfile(user models.User) (models.User, error)
+	QueryUserFieldsByUserName(name string) ([]models.ProfileField, error)
+	ReplaceUserFields(name string, fields []models.ProfileField) ([]models.ProfileField, error)
+	UpdateUserFieldVerified(id int, verified *time.Time) error
+	DeleteUser(activityArb arb.Arb, name string) (arb.Arb, error)
+	QueryFollowerIRIsByUserName(name string) ([]string, error)
+	GetUserFilesByUserName(name string) ([]string, error)
 	QueryFeedTotalItemsByUserName(name string) (int, error)
-	QueryFeedByUserName(name string, pageNum int) ([]models.Activity, error)
+	QueryFeedByUserName(name string, page models.Page) ([]models.Activity, []int, error)
 	QueryInboxTotalItemsByUserName(name string) (int, error)
-	QueryInboxByUserName(name string, pageNum int) ([]models.Activity, error)
+	QueryInboxByUserName(name string, page models.Page) ([]models.Activity, []int, error)
 	QueryOutboxTotalItemsByUserName(name string) (int, error)
-	QueryOutboxByUserName(name string, pageNum int) ([]models.Activity, error)
+	QueryOutboxByUserName(name string, page models.Page) ([]models.Activity, []int, error)
 	QueryFollowersTotalItemsByUserName(name string) (int, error)
-	QueryFollowersByUserName(name string, pageNum int) ([]string, error)
+	QueryFollowersByUserName(name string, page models.Page) ([]string, []int, error)
 	QueryFollowingTotalItemsByUserName(name string) (int, error)
-	QueryFollowingByUserName(name string, pageNum int) ([]string, error)
+	QueryFollowingByUserName(name string, page models.Page) ([]string, []int, error)
 	QueryLikedTotalItemsByUserName(name string) (int, error)
-	QueryLikedByUserName(name string, pageNum int) ([]string, error)
+	QueryLikedByUserName(name string, page models.Page) ([]string, []int, error)
 	QueryActivity(ID int) (models.Activity, error)
 	QueryObject(ID int) (models.Object, error)
 	CreateInboxActivity(activityArb arb.Arb, objectArb arb.Arb, actor string, name string) (arb.Arb, error)
@@ -32,6 +48,34 @@ type Repository interface {
 	AddActivityTo(activityIRI string, recipient string) error
 	DeleteActivity(activityArb arb.Arb, name string) (arb.Arb, error)
 	GetObjectFilesByIRI(objectIRI string) ([]string, error)
-	PurgeUnusedFiles() error
+	PurgeUnusedFiles(storage media.Storage, grace time.Duration, dryRun bool) (models.FilePurge, error)
+	CreatePendingMedia(name string, pending models.PendingMedia) error
+	QueryPendingMedia(name string, ids []string) ([]models.PendingMedia, error)
+	PurgeExpiredMedia(storage media.Storage) (int, error)
+	CreateChunkedUpload(name string, upload models.ChunkedUpload) error
+	QueryChunkedUpload(name string, id string) (models.ChunkedUpload, error)
+	UpdateChunkedUpload(id string, offset int64, size int64, part string, mediaType string) (bool, error)
+	DeleteChunkedUpload(id string) error
 	CheckActivity(name string, activityType string, objectIRI string) string
+	QueryDomainBlocks() ([]models.DomainBlock, error)
+	CreateDomainBlock(domain string) (models.DomainBlock, error)
+	DeleteDomainBlock(domain string) error
+	IsDomainBlocked(domain string) bool
+	QueryObjectAttributedTo(iri string) (string, error)
+	CreateNotification(name string, notification models.Notification) (models.Notification, error)
+	DeleteNotificationsByActivity(name string, actor string, activityIRI string) error
+	QueryNotificationsTotalItemsByUserName(name string) (int, error)
+	QueryNotificationsByUserName(name string, page models.Page) ([]models.Notification, []int, error)
+	UpdateNotificationsRead(name string, ids []int) (int64, error)
+	CreateRemoteObject(objectArb arb.Arb, attachments []models.Attachment) error
+	UpdateRemoteObject(objectArb arb.Arb, attachments []models.Attachment) error
+	SearchObjects(iri string, query models.SearchQuery, page models.Page) ([]models.Object, []int, error)
+	TagObject(objectIRI string, activityArb arb.Arb, objectArb arb.Arb, hashtags []string, public bool) error
+	QueryHashtagTotalItems(tag string) (int, error)
+	QueryObjectsByHashtag(tag string, page models.Page) ([]models.Object, []int, error)
+	QueryFollowedHashtagsByUserName(name string) ([]models.Hashtag, error)
+	CreateFollowedHashtag(name string, tag string) (models.Hashtag, error)
+	DeleteFollowedHashtag(name string, tag string) error
+	QueryProxiedMedia(url string) (models.ProxiedMedia, error)
+	CreateProxiedMedia(proxied models.ProxiedMedia) error
 }
@@ -3,8 +3,10 @@ package resources
 import (
 	"errors"
 	"fmt"
-	"math"
+	"html"
+	"net/url"
 	"strings"
+	"time"
 
 	"github.com/cheebz/go-pub/pkg/config"
 	"github.com/cheebz/go-pub/pkg/models"
@@ -68,21 +70,41 @@ func (r *ActivityPubResource) GenerateWebFinger(name string) models.WebFinger {
 	}
 }
 
-func (r *ActivityPubResource) GenerateActor(name string) models.Actor {
-	return models.Actor{
+func (r *ActivityPubResource) GenerateActor(user models.User) models.Actor {
+	name := user.Name
+	displayName := user.DisplayName
+	if displayName == "" {
+		displayName = name
+	}
+	actorType := user.ActorType
+	if actorType == "" {
+		actorType = "Person"
+	}
+	actor := models.Actor{
 		Object: models.Object{
 			Context: []interface{}{
 				"https://www.w3.org/ns/activitystreams",
 				"https://w3id.org/security/v1",
 				map[string]interface{}{
 					"manuallyApprovesFollowers": "as:manuallyApprovesFollowers",
+					"alsoKnownAs": map[string]interface{}{
+						"@id":   "as:alsoKnownAs",
+						"@type": "@id",
+					},
+					"movedTo": map[string]interface{}{
+						"@id":   "as:movedTo",
+						"@type": "@id",
+					},
+					"schema":        "http://schema.org#",
+					"PropertyValue": "schema:PropertyValue",
+					"value":         "schema:value",
 				},
 			},
 			Id:      fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
-			Type:    "Person",
-			Name:    name,
+			Type:    actorType,
+			Name:    displayName,
 			Url:     fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
-			Summary: fmt.Sprintf("Summary of %s to come...", name), // TODO: Implement this
+			Summary: formatSummary(user.Summary),
 		},
 		Inbox:                     fmt.Sprintf("%s://%s/%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name, r.conf.Endpoints.Inbox),
 		Outbox:                    fmt.Sprintf("%s://%s/%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name, r.conf.Endpoints.Outbox),
@@ -96,46 +118,165 @@ func (r *ActivityPubResource) GenerateActor(name string) models.Actor {
 			Owner:        fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
 			PublicKeyPem: r.conf.RSAPublicKey,
 		},
+		AlsoKnownAs: user.AlsoKnownAs,
+		MovedTo:     user.MovedTo,
+	}
+	if len(user.Fields) > 0 {
+		attachment := make([]models.PropertyValue, len(user.Fields))
+		for i, field := range user.Fields {
+			attachment[i] = models.PropertyValue{
+				Type:  "PropertyValue",
+				Name:  field.Name,
+				Value: formatFieldValue(field.Value),
+			}
+		}
+		actor.Attachment = attachment
+	}
+	if user.Icon != "" {
+		actor.Icon = models.Image{
+			Type:      "Image",
+			MediaType: user.IconMediaType,
+			Url:       user.Icon,
+		}
+	}
+	if user.Image != "" {
+		actor.Image = models.Image{
+			Type:      "Image",
+			MediaType: user.ImageMediaType,
+			Url:       user.Image,
+		}
+	}
+	return actor
+}
+
+// formatFieldValue links http(s) values with rel="me" and escapes everything else
+func formatFieldValue(value string) string {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return html.EscapeString(value)
+	}
+	display := strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(value, "https://"), "http://"), "/")
+	return fmt.Sprintf(`<a href="%s" rel="me nofollow noopener noreferrer" target="_blank">%s</a>`, html.EscapeString(value), html.EscapeString(display))
+}
+
+// formatSummary converts a plain text bio into escaped HTML paragraphs
+func formatSummary(summary string) string {
+	summary = strings.TrimSpace(strings.ReplaceAll(summary, "\r\n", "\n"))
+	if summary == "" {
+		return ""
+	}
+	var paragraphs []string
+	for _, p := range strings.Split(summary, "\n\n") {
+		if p = strings.TrimSpace(p); p != "" {
+			paragraphs = append(paragraphs, "<p>"+strings.ReplaceAll(html.EscapeString(p), "\n", "<br>")+"</p>")
+		}
+	}
+	return strings.Join(paragraphs, "")
+}
+
+func (r *ActivityPubResource) GenerateTombstone(user models.User, deleted time.Time) models.Tombstone {
+	name := user.Name
+	formerType := user.ActorType
+	if formerType == "" {
+		formerType = "Person"
+	}
+	return models.Tombstone{
+		Object: models.Object{
+			Context: []interface{}{
+				"https://www.w3.org/ns/activitystreams",
+				"https://w3id.org/security/v1",
+			},
+			Id:   fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
+			Type: "Tombstone",
+		},
+		FormerType: formerType,
+		Deleted:    deleted.UTC().Format(time.RFC3339),
+		PublicKey: &models.PublicKey{
+			ID:           fmt.Sprintf("%s://%s/%s/%s#main-key", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
+			Owner:        fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name),
+			PublicKeyPem: r.conf.RSAPublicKey,
+		},
 	}
 }
 
 func (r *ActivityPubResource) GenerateOrderedCollection(name string, endpoint string, totalItems int) models.OrderedCollection {
-	return models.OrderedCollection{
+	return r.generateOrderedCollection(fmt.Sprintf("%s://%s/%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name, endpoint), totalItems)
+}
+
+func (r *ActivityPubResource) GenerateHashtagCollection(tag string, totalItems int) models.OrderedCollection {
+	return r.generateOrderedCollection(fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Tags, url.PathEscape(tag)), totalItems)
+}
+
+func (r *ActivityPubResource) generateOrderedCollection(collection string, totalItems int) models.OrderedCollection {
+	orderedCollection := models.OrderedCollection{
 		Object: models.Object{
 			Context: []interface{}{
 				"https://www.w3.org/ns/activitystreams",
 				"https://w3id.org/security/v1",
 			},
-			Id:   fmt.Sprintf("%s://%s/%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name, endpoint),
+			Id:   collection,
 			Type: "OrderedCollection",
 		},
 		TotalItems: totalItems,
-		First:      fmt.Sprintf("%s://%s/%s/%s/%s?page=0", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name, endpoint),
-		Last:       fmt.Sprintf("%s://%s/%s/%s/%s?page=%d", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name, endpoint, int(math.Ceil(float64(totalItems/r.conf.PageLength)))),
+		First:      fmt.Sprintf("%s?%s", collection, models.Page{Keyset: true}.Query()),
+	}
+	if totalItems > 0 {
+		orderedCollection.Last = fmt.Sprintf("%s?%s", collection, models.Page{Keyset: true, HasMinID: true}.Query())
 	}
+	return orderedCollection
 }
 
-func (r *ActivityPubResource) GenerateOrderedCollectionPage(name string, endpoint string, orderedItems []interface{}, pageNum int) models.OrderedCollectionPage {
+func (r *ActivityPubResource) GenerateOrderedCollectionPage(name string, endpoint string, orderedItems []interface{}, ids []int, pageQuery models.Page) models.OrderedCollectionPage {
+	return r.generateOrderedCollectionPage(fmt.Sprintf("%s://%s/%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name, endpoint), orderedItems, ids, pageQuery)
+}
+
+func (r *ActivityPubResource) GenerateHashtagCollectionPage(tag string, orderedItems []interface{}, ids []int, pageQuery models.Page) models.OrderedCollectionPage {
+	return r.generateOrderedCollectionPage(fmt.Sprintf("%s://%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Tags, url.PathEscape(tag)), orderedItems, ids, pageQuery)
+}
+
+func (r *ActivityPubResource) generateOrderedCollectionPage(collection string, orderedItems []interface{}, ids []int, pageQuery models.Page) models.OrderedCollectionPage {
 	page := models.OrderedCollectionPage{
 		Object: models.Object{
 			Context: []interface{}{
 				"https://www.w3.org/ns/activitystreams",
 				"https://w3id.org/security/v1",
 			},
-			Id:   fmt.Sprintf("%s://%s/%s/%s/%s?page=%d", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name, endpoint, pageNum),
+			Id:   fmt.Sprintf("%s?%s", collection, pageQuery.Query()),
 			Type: "OrderedCollectionPage",
 		},
-		PartOf: fmt.Sprintf("%s://%s/%s/%s/%s", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name, endpoint),
-		// OrderedItems: orderedItems,
+		PartOf:       collection,
+		OrderedItems: []interface{}{},
 	}
-	if pageNum > 0 {
-		page.Prev = fmt.Sprintf("%s://%s/%s/%s/%s?page=%d", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name, endpoint, pageNum-1)
+	length := pageQuery.Size(r.conf.PageLength)
+	more := len(orderedItems) > length
+	if !pageQuery.Keyset {
+		if more {
+			orderedItems = orderedItems[:length]
+			page.Next = fmt.Sprintf("%s?%s", collection, models.Page{Num: pageQuery.Num + 1, Limit: pageQuery.Limit, Filter: pageQuery.Filter}.Query())
+		}
+		if pageQuery.Num > 0 {
+			page.Prev = fmt.Sprintf("%s?%s", collection, models.Page{Num: pageQuery.Num - 1, Limit: pageQuery.Limit, Filter: pageQuery.Filter}.Query())
+		}
+		if len(orderedItems) > 0 {
+			page.OrderedItems = orderedItems
+		}
+		return page
 	}
-	if len(orderedItems) > r.conf.PageLength {
-		page.Next = fmt.Sprintf("%s://%s/%s/%s/%s?page=%d", r.conf.Protocol, r.conf.ServerName, r.conf.Endpoints.Users, name, endpoint, pageNum+1)
-		page.OrderedItems = orderedItems[:10]
-	} else {
-		page.OrderedItems = orderedItems
+	// min_id pages run forward from the cursor, so the extra row is the newest one
+	if more && pageQuery.HasMinID {
+		orderedItems, ids = orderedItems[len(orderedItems)-length:], ids[len(ids)-length:]
+	} else if more {
+		orderedItems, ids = orderedItems[:length], ids[:length]
+	}
+	if len(ids) == 0 {
+		return page
+	}
+	page.OrderedItems = orderedItems
+	if pageQuery.MaxID > 0 || (pageQuery.HasMinID && more) {
+		page.Prev = fmt.Sprintf("%s?%s", collection, models.Page{Keyset: true, MinID: ids[0], HasMinID: true, Limit: pageQuery.Limit, Filter: pageQuery.Filter}.Query())
+	}
+	if (pageQuery.HasMinID && pageQuery.MinID > 0) || (!pageQuery.HasMinID && more) {
+		page.Next = fmt.Sprintf("%s?%s", collection, models.Page{Keyset: true, MaxID: ids[len(ids)-1], Limit: pageQuery.Limit, Filter: pageQuery.Filter}.Query())
 	}
 	return page
 }
@@ -1,12 +1,19 @@
 package resources
 
-import "github.com/cheebz/go-pub/pkg/models"
+import (
+	"time"
+
+	"github.com/cheebz/go-pub/pkg/models"
+)
 
 type Resource interface {
 	ParseResource(resource string) (string, error)
 	GenerateWebFinger(name string) models.WebFinger
-	GenerateActor(name string) models.Actor
+	GenerateActor(user models.User) models.Actor
+	GenerateTombstone(user models.User, deleted time.Time) models.Tombstone
 	GenerateOrderedCollection(name string, endpoint string, totalItems int) models.OrderedCollection
-	GenerateOrderedCollectionPage(name string, endpoint string, orderedItems []interface{}, pageNum int) models.OrderedCollectionPage
+	GenerateOrderedCollectionPage(name string, endpoint string, orderedItems []interface{}, ids []int, pageQuery models.Page) models.OrderedCollectionPage
+	GenerateHashtagCollection(tag string, totalItems int) models.OrderedCollection
+	GenerateHashtagCollectionPage(tag string, orderedItems []interface{}, ids []int, pageQuery models.Page) models.OrderedCollectionPage
 	GenerateCheckResponse(activityIRI string) models.CheckResponse
 }
@@ -47,6 +47,17 @@ func (a *ActivityPubResponse) NotFound(w http.ResponseWriter, err error) {
 	http.Error(w, msg, http.StatusNotFound)
 }
 
+func (a *ActivityPubResponse) Conflict(w http.ResponseWriter, err error) {
+	logging.LogCaller(err)
+	var msg string
+	if a.debug {
+		msg = err.Error()
+	} else {
+		msg = "Conflict"
+	}
+	http.Error(w, msg, http.StatusConflict)
+}
+
 func (a *ActivityPubResponse) UnauthorizedRequest(w http.ResponseWriter, err error) {
 	logging.LogCaller(err)
 	var msg string
@@ -58,6 +69,17 @@ func (a *ActivityPubResponse) UnauthorizedRequest(w http.ResponseWriter, err err
 	http.Error(w, msg, http.StatusUnauthorized)
 }
 
+func (a *ActivityPubResponse) Forbidden(w http.ResponseWriter, err error) {
+	logging.LogCaller(err)
+	var msg string
+	if a.debug {
+		msg = err.Error()
+	} else {
+		msg = "Forbidden"
+	}
+	http.Error(w, msg, http.StatusForbidden)
+}
+
 func (a *ActivityPubResponse) InternalServerError(w http.ResponseWriter, err error) {
 	logging.LogCaller(err)
 	var msg string
@@ -68,3 +90,14 @@ func (a *ActivityPubResponse) InternalServerError(w http.ResponseWriter, err err
 	}
 	http.Error(w, msg, http.StatusInternalServerError)
 }
+
+func (a *ActivityPubResponse) BadGateway(w http.ResponseWriter, err error) {
+	logging.LogCaller(err)
+	var msg string
+	if a.debug {
+		msg = err.Error()
+	} else {
+		msg = "Bad gateway"
+	}
+	http.Error(w, msg, http.StatusBadGateway)
+}
@@ -7,6 +7,9 @@ type Response interface {
 	Accepted(w http.ResponseWriter)
 	BadRequest(w http.ResponseWriter, err error)
 	NotFound(w http.ResponseWriter, err error)
+	Conflict(w http.ResponseWriter, err error)
 	UnauthorizedRequest(w http.ResponseWriter, err error)
+	Forbidden(w http.ResponseWriter, err error)
 	InternalServerError(w http.ResponseWriter, err error)
+	BadGateway(w http.ResponseWriter, err error)
 }
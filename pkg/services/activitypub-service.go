@@ -1,10 +1,21 @@
 package services
 
 import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net/http"
+	"net/url"
+	"os"
 	"path"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/cheebz/arb"
 	"github.com/cheebz/go-pub/pkg/activitypub"
@@ -12,19 +23,51 @@ import (
 	"github.com/cheebz/go-pub/pkg/media"
 	"github.com/cheebz/go-pub/pkg/models"
 	"github.com/cheebz/go-pub/pkg/repositories"
+	"github.com/cheebz/go-pub/pkg/resources"
+	"github.com/cheebz/go-pub/pkg/streams"
+	"github.com/cheebz/go-pub/pkg/utils"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrUserDeleted      = errors.New("user has been deleted")
+	ErrUserSuspended    = errors.New("user is suspended")
+	ErrInvalidProxyLink = errors.New("invalid media proxy link")
+	ErrUploadNotFound   = errors.New("upload not found or expired")
+	ErrUploadOffset     = errors.New("upload offset does not match")
+	ErrInvalidQuery     = errors.New("invalid handle or iri")
+	ErrDomainBlocked    = errors.New("domain is blocked")
+	ErrNotFound         = errors.New("not found")
+	ErrRemoteFetch      = errors.New("unable to fetch remote document")
+	ErrInvalidObject    = errors.New("invalid object")
+)
+
+var (
+	maxProfileFields = 4
+	localNameRegexp  = regexp.MustCompile(`^[[:alnum:]]+$`)
 )
 
 type ActivityPubService struct {
 	conf      config.Configuration
 	repo      repositories.Repository
 	federator activitypub.Federator
+	webfinger activitypub.WebFingerClient
+	storage   media.Storage
+	proxy     *http.Client
+	resource  resources.Resource
+	broker    streams.Broker
 }
 
-func NewActivityPubService(_conf config.Configuration, _repo repositories.Repository, _federator activitypub.Federator) Service {
+func NewActivityPubService(_conf config.Configuration, _repo repositories.Repository, _federator activitypub.Federator, _webfinger activitypub.WebFingerClient, _storage media.Storage, _resource resources.Resource, _broker streams.Broker) Service {
 	return &ActivityPubService{
 		conf:      _conf,
 		repo:      _repo,
 		federator: _federator,
+		webfinger: _webfinger,
+		storage:   _storage,
+		proxy:     media.NewProxyClient(),
+		resource:  _resource,
+		broker:    _broker,
 	}
 }
 
@@ -40,7 +83,30 @@ func (s *ActivityPubService) DiscoverUserByName(name string) (models.User, error
 }
 
 func (s *ActivityPubService) GetUserByName(name string) (models.User, error) {
-	return s.repo.QueryUserByName(name)
+	user, err := s.repo.QueryUserByName(name)
+	if err != nil {
+		return user, err
+	}
+	if user.Deleted != nil {
+		return user, ErrUserDeleted
+	}
+	if user.Suspended {
+		return user, ErrUserSuspended
+	}
+	return user, nil
+}
+
+// GetProfileByName gets a user along with their profile fields
+func (s *ActivityPubService) GetProfileByName(name string) (models.User, error) {
+	user, err := s.GetUserByName(name)
+	if err != nil {
+		return user, err
+	}
+	user.Fields, err = s.repo.QueryUserFieldsByUserName(name)
+	if err != nil {
+		return user, err
+	}
+	return user, nil
 }
 
 func (s *ActivityPubService) CheckUser(name string) error {
@@ -48,56 +114,575 @@ func (s *ActivityPubService) CheckUser(name string) error {
 }
 
 func (s *ActivityPubService) CreateUser(name string) (string, error) {
-	return s.repo.CreateUser(name)
+	return s.repo.CreateUser(name, "Person")
+}
+
+func (s *ActivityPubService) CreateActor(name string, actorType string) (models.User, error) {
+	if !localNameRegexp.MatchString(name) {
+		return models.User{}, errors.New("name must be alphanumeric")
+	}
+	if !activitypub.IsActor(actorType) {
+		return models.User{}, fmt.Errorf("invalid actor type: %s", actorType)
+	}
+	if s.repo.CheckUser(name) == nil {
+		return models.User{}, errors.New("user already exists")
+	}
+	_, err := s.repo.CreateUser(name, actorType)
+	if err != nil {
+		return models.User{}, err
+	}
+	return s.repo.QueryUserByName(name)
+}
+
+func (s *ActivityPubService) SetActorType(name string, actorType string) (models.User, error) {
+	if !activitypub.IsActor(actorType) {
+		return models.User{}, fmt.Errorf("invalid actor type: %s", actorType)
+	}
+	user, err := s.repo.UpdateUserActorType(name, actorType)
+	if err != nil {
+		return user, err
+	}
+	user, err = s.GetProfileByName(name)
+	if err != nil {
+		return user, err
+	}
+	err = s.federateActorUpdate(user)
+	if err != nil {
+		log.Println(err)
+	}
+	return user, nil
+}
+
+func (s *ActivityPubService) GetUsers() ([]models.User, error) {
+	return s.repo.QueryUsers()
+}
+
+func (s *ActivityPubService) SuspendUser(name string) (models.User, error) {
+	return s.repo.UpdateUserSuspended(name, true)
+}
+
+func (s *ActivityPubService) UnsuspendUser(name string) (models.User, error) {
+	return s.repo.UpdateUserSuspended(name, false)
+}
+
+func (s *ActivityPubService) ToggleUserDiscoverable(name string) (models.User, error) {
+	return s.repo.ToggleUserDiscoverable(name)
+}
+
+func (s *ActivityPubService) DeleteUser(name string) error {
+	user, err := s.repo.QueryUserByName(name)
+	if err != nil {
+		return err
+	}
+	if user.Deleted != nil {
+		return ErrUserDeleted
+	}
+	// collect followers and files before the account is tombstoned
+	followers, err := s.repo.QueryFollowerIRIsByUserName(name)
+	if err != nil {
+		return err
+	}
+	hrefs, err := s.repo.GetUserFilesByUserName(name)
+	if err != nil {
+		return err
+	}
+	activityArb, err := activitypub.NewActivityArbReference(user.IRI, "Delete")
+	if err != nil {
+		return err
+	}
+	activityArb["actor"] = user.IRI
+	activityArb["to"] = []string{activitypub.Public}
+	activityArb, err = s.repo.DeleteUser(activityArb, name)
+	if err != nil {
+		return err
+	}
+	// the avatar and header go with the profile
+	for _, href := range []string{user.Icon, user.Image} {
+		if href != "" {
+			hrefs = append(hrefs, href)
+		}
+	}
+	for _, href := range hrefs {
+		file := path.Base(href)
+		err = s.storage.Delete(file)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	for _, follower := range followers {
+		go s.federator.Federate(models.Federation{Name: name, Recipient: follower, Activity: activityArb})
+	}
+	return nil
+}
+
+func (s *ActivityPubService) SetAlsoKnownAs(name string, alsoKnownAs []string) (models.User, error) {
+	aliases := make([]string, 0)
+	for _, alias := range alsoKnownAs {
+		iri, err := url.Parse(alias)
+		if err != nil {
+			return models.User{}, err
+		}
+		if !iri.IsAbs() {
+			return models.User{}, fmt.Errorf("alias must be an absolute IRI: %s", alias)
+		}
+		aliases = append(aliases, iri.String())
+	}
+	return s.repo.UpdateUserAlsoKnownAs(name, aliases)
+}
+
+func (s *ActivityPubService) UpdateProfile(name string, displayName *string, summary *string, icon *media.Media, image *media.Media) (models.User, error) {
+	user, err := s.GetProfileByName(name)
+	if err != nil {
+		return user, err
+	}
+	previous := user
+	if displayName != nil {
+		if len([]rune(*displayName)) > 100 {
+			return user, errors.New("display name is too long")
+		}
+		user.DisplayName = strings.TrimSpace(*displayName)
+	}
+	if summary != nil {
+		if len([]rune(*summary)) > 5000 {
+			return user, errors.New("summary is too long")
+		}
+		user.Summary = *summary
+	}
+	if icon != nil {
+		err = icon.Save(s.storage)
+		if err != nil {
+			return user, err
+		}
+		user.Icon = s.uploadURL(icon.UUID + icon.FileExt)
+		user.IconMediaType = icon.MimeType
+	}
+	if image != nil {
+		err = image.Save(s.storage)
+		if err != nil {
+			return user, err
+		}
+		user.Image = s.uploadURL(image.UUID + image.FileExt)
+		user.ImageMediaType = image.MimeType
+	}
+	user, err = s.repo.UpdateUserProfile(user)
+	if err != nil {
+		return user, err
+	}
+	user.Fields = previous.Fields
+	// remove replaced uploads
+	if previous.Icon != "" && previous.Icon != user.Icon {
+		if err := s.storage.Delete(path.Base(previous.Icon)); err != nil {
+			log.Println(err)
+		}
+	}
+	if previous.Image != "" && previous.Image != user.Image {
+		if err := s.storage.Delete(path.Base(previous.Image)); err != nil {
+			log.Println(err)
+		}
+	}
+	if previous.DisplayName != user.DisplayName || previous.Summary != user.Summary ||
+		previous.Icon != user.Icon || previous.Image != user.Image {
+		err = s.federateActorUpdate(user)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+	return user, nil
+}
+
+func (s *ActivityPubService) SetProfileFields(name string, fields []models.ProfileField) (models.User, error) {
+	user, err := s.GetProfileByName(name)
+	if err != nil {
+		return user, err
+	}
+	if len(fields) > maxProfileFields {
+		return user, fmt.Errorf("at most %d profile fields are allowed", maxProfileFields)
+	}
+	for i := range fields {
+		fields[i].Name = strings.TrimSpace(fields[i].Name)
+		fields[i].Value = strings.TrimSpace(fields[i].Value)
+		if fields[i].Name == "" {
+			return user, errors.New("profile field name is required")
+		}
+		if len([]rune(fields[i].Name)) > 255 || len([]rune(fields[i].Value)) > 2047 {
+			return user, errors.New("profile field is too long")
+		}
+		// keep verification for values that did not change
+		fields[i].Verified = nil
+		for _, field := range user.Fields {
+			if field.Value == fields[i].Value {
+				fields[i].Verified = field.Verified
+			}
+		}
+	}
+	user.Fields, err = s.repo.ReplaceUserFields(name, fields)
+	if err != nil {
+		return user, err
+	}
+	go s.verifyProfileFields(user)
+	err = s.federateActorUpdate(user)
+	if err != nil {
+		log.Println(err)
+	}
+	return user, nil
+}
+
+// verifyProfileFields marks link fields verified when the linked page has a rel="me" link back to the actor
+func (s *ActivityPubService) verifyProfileFields(user models.User) {
+	for _, field := range user.Fields {
+		u, err := url.Parse(field.Value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			continue
+		}
+		if s.repo.IsDomainBlocked(u.Hostname()) {
+			continue
+		}
+		ok, err := utils.LinksBack(s.proxy, field.Value, user.IRI)
+		if err != nil {
+			log.Println(fmt.Sprintf("unable to verify %s for %s: %s", field.Value, user.Name, err))
+		}
+		var verified *time.Time
+		if ok {
+			now := time.Now()
+			verified = &now
+		}
+		err = s.repo.UpdateUserFieldVerified(field.ID, verified)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// federateActorUpdate sends an Update with the current actor to all followers
+func (s *ActivityPubService) federateActorUpdate(user models.User) error {
+	actor, err := json.Marshal(s.resource.GenerateActor(user))
+	if err != nil {
+		return err
+	}
+	actorArb, err := arb.ReadBytes(actor)
+	if err != nil {
+		return err
+	}
+	activityArb, err := activitypub.NewActivityArbReference(user.IRI, "Update")
+	if err != nil {
+		return err
+	}
+	activityArb["actor"] = user.IRI
+	activityArb["to"] = []string{activitypub.Public}
+	activityArb, err = s.repo.CreateOutboxReferenceActivity(activityArb, user.Name)
+	if err != nil {
+		return err
+	}
+	activityArb["object"] = actorArb
+	return s.federateToFollowers(user.Name, activityArb)
+}
+
+// describeAudio fills in an audio post's duration, its name from the track's title and
+// artist, and its icon from the track's cover art
+func (s *ActivityPubService) describeAudio(objectArb arb.Arb, fileArb arb.Arb, title string, artist string) {
+	objectArb["duration"] = fileArb["duration"]
+	if name, _ := objectArb.GetString("name"); name == "" && title != "" {
+		if artist != "" {
+			objectArb["name"] = fmt.Sprintf("%s - %s", artist, title)
+		} else {
+			objectArb["name"] = title
+		}
+	}
+	if previewArb, ok := fileArb["preview"]; ok && !objectArb.Exists("icon") {
+		objectArb["icon"] = previewArb
+	}
+}
+
+// deleteUploads removes the saved files of attachments that could not be posted
+func (s *ActivityPubService) deleteUploads(attachments []arb.Arb) {
+	for _, fileArb := range attachments {
+		hrefs := []string{}
+		if href, err := fileArb.GetString("url"); err == nil {
+			hrefs = append(hrefs, href)
+		}
+		if previewArb, err := fileArb.GetArb("preview"); err == nil {
+			if href, err := previewArb.GetString("url"); err == nil {
+				hrefs = append(hrefs, href)
+			}
+		}
+		if href, err := fileArb.GetString("waveform"); err == nil {
+			hrefs = append(hrefs, href)
+		}
+		for _, href := range hrefs {
+			if err := s.storage.Delete(path.Base(href)); err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// proxyAttachments points the remote attachments of the activities' objects at the media proxy
+func (s *ActivityPubService) proxyAttachments(activities []models.Activity) []models.Activity {
+	if !s.conf.MediaProxy {
+		return activities
+	}
+	for i, activity := range activities {
+		// objects may be loaded from the db or decoded from the cache, so they're handled as json
+		object, ok := toJSONMap(activity.ChildObject)
+		if !ok {
+			continue
+		}
+		s.proxyObject(object)
+		activities[i].ChildObject = object
+	}
+	return activities
+}
+
+// ProxyActivity points the remote attachments of a streamed activity's object at the media
+// proxy, leaving the published activity, which other subscribers share, untouched
+func (s *ActivityPubService) ProxyActivity(activityArb arb.Arb) arb.Arb {
+	if !s.conf.MediaProxy {
+		return activityArb
+	}
+	activity, ok := toJSONMap(activityArb)
+	if !ok {
+		return activityArb
+	}
+	if object, ok := activity["object"].(map[string]interface{}); ok {
+		s.proxyObject(object)
+	}
+	return activity
+}
+
+// ProxyObject points the remote attachments of a streamed object at the media proxy, leaving
+// the published object untouched
+func (s *ActivityPubService) ProxyObject(objectArb arb.Arb) arb.Arb {
+	if !s.conf.MediaProxy {
+		return objectArb
+	}
+	object, ok := toJSONMap(objectArb)
+	if !ok {
+		return objectArb
+	}
+	s.proxyObject(object)
+	return object
+}
+
+func toJSONMap(v interface{}) (map[string]interface{}, bool) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, false
+	}
+	var m map[string]interface{}
+	if json.Unmarshal(b, &m) != nil || m == nil {
+		return nil, false
+	}
+	return m, true
+}
+
+// proxyObject rewrites the links of an object's attachments and their previews, given as a
+// string, a Link or a list of either
+func (s *ActivityPubService) proxyObject(object map[string]interface{}) {
+	attachments, ok := object["attachment"].([]interface{})
+	if !ok {
+		if attachment, ok := object["attachment"].(map[string]interface{}); ok {
+			attachments = []interface{}{attachment}
+		}
+	}
+	for _, item := range attachments {
+		attachment, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		s.proxyLinks(attachment, "url")
+		s.proxyLinks(attachment, "href")
+		if preview, ok := attachment["preview"].(map[string]interface{}); ok {
+			s.proxyLinks(preview, "url")
+		}
+	}
+}
+
+func (s *ActivityPubService) proxyLinks(m map[string]interface{}, prop string) {
+	switch v := m[prop].(type) {
+	case string:
+		s.proxyProp(m, prop)
+	case map[string]interface{}:
+		s.proxyProp(v, "href")
+	case []interface{}:
+		for i, item := range v {
+			switch link := item.(type) {
+			case string:
+				wrapper := map[string]interface{}{"href": link}
+				s.proxyProp(wrapper, "href")
+				v[i] = wrapper["href"]
+			case map[string]interface{}:
+				s.proxyProp(link, "href")
+			}
+		}
+	}
+}
+
+// proxyProp rewrites a link to remote media to go through the media proxy
+func (s *ActivityPubService) proxyProp(m map[string]interface{}, prop string) {
+	iri, ok := m[prop].(string)
+	if !ok {
+		return
+	}
+	u, err := url.Parse(iri)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == s.conf.ServerName {
+		return
+	}
+	if s.conf.Storage.MediaURL != "" && strings.HasPrefix(iri, s.conf.Storage.MediaURL) {
+		return
+	}
+	m[prop] = fmt.Sprintf("%s://%s/%s/%s?url=%s", s.conf.Protocol, s.conf.ServerName, s.conf.Endpoints.Proxy, s.proxySignature(iri), url.QueryEscape(iri))
+}
+
+// proxySignature is keyed with the server's private key so only links the server made are proxied
+func (s *ActivityPubService) proxySignature(iri string) string {
+	mac := hmac.New(sha256.New, []byte(s.conf.RSAPrivateKey))
+	mac.Write([]byte(iri))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// ProxyMedia returns the cached copy of remote media behind a media proxy link, fetching it
+// into storage the first time it is requested
+func (s *ActivityPubService) ProxyMedia(signature string, iri string) (models.ProxiedMedia, error) {
+	if !hmac.Equal([]byte(signature), []byte(s.proxySignature(iri))) {
+		return models.ProxiedMedia{}, ErrInvalidProxyLink
+	}
+	proxied, err := s.repo.QueryProxiedMedia(iri)
+	if err == nil {
+		return proxied, nil
+	}
+	u, err := url.Parse(iri)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return proxied, ErrInvalidProxyLink
+	}
+	if s.repo.IsDomainBlocked(u.Hostname()) {
+		return proxied, fmt.Errorf("%s is blocked", u.Host)
+	}
+	allowed, err := media.ParseAllowList(s.conf.MediaTypes)
+	if err != nil {
+		return proxied, err
+	}
+	remote, err := media.Download(s.proxy, iri, allowed)
+	if err != nil {
+		return proxied, err
+	}
+	defer remote.Close()
+	hash := sha256.Sum256([]byte(iri))
+	proxied = models.ProxiedMedia{
+		URL:       iri,
+		Name:      fmt.Sprintf("proxy-%s%s", hex.EncodeToString(hash[:]), media.Extension(remote.MimeType)),
+		MediaType: remote.MimeType,
+		Size:      remote.Size,
+	}
+	err = s.storage.Put(proxied.Name, remote.File, proxied.MediaType)
+	if err != nil {
+		return proxied, err
+	}
+	err = s.repo.CreateProxiedMedia(proxied)
+	if err != nil {
+		return proxied, err
+	}
+	return proxied, nil
+}
+
+// uploadURL is the address of a stored file, under MEDIA_URL when set so clients fetch it directly
+func (s *ActivityPubService) uploadURL(name string) string {
+	if s.conf.Storage.MediaURL != "" {
+		return fmt.Sprintf("%s/%s", strings.TrimSuffix(s.conf.Storage.MediaURL, "/"), name)
+	}
+	return fmt.Sprintf("%s://%s/%s/%s", s.conf.Protocol, s.conf.ServerName, s.conf.Endpoints.Uploads, name)
+}
+
+func (s *ActivityPubService) GetFederationStats() models.FederationStats {
+	return s.federator.Stats()
+}
+
+func (s *ActivityPubService) GetDomainBlocks() ([]models.DomainBlock, error) {
+	return s.repo.QueryDomainBlocks()
+}
+
+func (s *ActivityPubService) BlockDomain(domain string) (models.DomainBlock, error) {
+	if domain == "" {
+		return models.DomainBlock{}, errors.New("domain is required")
+	}
+	if strings.EqualFold(domain, s.conf.ServerName) {
+		return models.DomainBlock{}, errors.New("cannot block this server")
+	}
+	return s.repo.CreateDomainBlock(domain)
+}
+
+func (s *ActivityPubService) UnblockDomain(domain string) error {
+	return s.repo.DeleteDomainBlock(domain)
+}
+
+func (s *ActivityPubService) Subscribe(name string) (<-chan models.StreamEvent, func(), error) {
+	_, err := s.GetUserByName(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	events, unsubscribe := s.broker.Subscribe(name)
+	return events, unsubscribe, nil
+}
+
+// SubscribeHashtags receives the public objects newly tagged with any hashtag
+func (s *ActivityPubService) SubscribeHashtags() (<-chan models.StreamEvent, func()) {
+	return s.broker.Subscribe(streams.HashtagTopic)
 }
 
 func (s *ActivityPubService) GetFeedTotalItemsByUserName(name string) (int, error) {
 	return s.repo.QueryFeedTotalItemsByUserName(name)
 }
 
-func (s *ActivityPubService) GetFeedByUserName(name string, pageNum int) ([]models.Activity, error) {
-	return s.repo.QueryFeedByUserName(name, pageNum)
+func (s *ActivityPubService) GetFeedByUserName(name string, page models.Page) ([]models.Activity, []int, error) {
+	activities, ids, err := s.repo.QueryFeedByUserName(name, page)
+	if err != nil {
+		return activities, ids, err
+	}
+	return s.proxyAttachments(activities), ids, nil
 }
 
 func (s *ActivityPubService) GetInboxTotalItemsByUserName(name string) (int, error) {
 	return s.repo.QueryInboxTotalItemsByUserName(name)
 }
 
-func (s *ActivityPubService) GetInboxByUserName(name string, pageNum int) ([]models.Activity, error) {
-	return s.repo.QueryInboxByUserName(name, pageNum)
+func (s *ActivityPubService) GetInboxByUserName(name string, page models.Page) ([]models.Activity, []int, error) {
+	activities, ids, err := s.repo.QueryInboxByUserName(name, page)
+	if err != nil {
+		return activities, ids, err
+	}
+	return s.proxyAttachments(activities), ids, nil
 }
 
 func (s *ActivityPubService) GetOutboxTotalItemsByUserName(name string) (int, error) {
 	return s.repo.QueryOutboxTotalItemsByUserName(name)
 }
 
-func (s *ActivityPubService) GetOutboxByUserName(name string, pageNum int) ([]models.Activity, error) {
-	return s.repo.QueryOutboxByUserName(name, pageNum)
+func (s *ActivityPubService) GetOutboxByUserName(name string, page models.Page) ([]models.Activity, []int, error) {
+	return s.repo.QueryOutboxByUserName(name, page)
 }
 
 func (s *ActivityPubService) GetFollowersTotalItemsByUserName(name string) (int, error) {
 	return s.repo.QueryFollowersTotalItemsByUserName(name)
 }
 
-func (s *ActivityPubService) GetFollowersByUserName(name string, pageNum int) ([]string, error) {
-	return s.repo.QueryFollowersByUserName(name, pageNum)
+func (s *ActivityPubService) GetFollowersByUserName(name string, page models.Page) ([]string, []int, error) {
+	return s.repo.QueryFollowersByUserName(name, page)
 }
 
 func (s *ActivityPubService) GetFollowingTotalItemsByUserName(name string) (int, error) {
 	return s.repo.QueryFollowingTotalItemsByUserName(name)
 }
 
-func (s *ActivityPubService) GetFollowingByUserName(name string, pageNum int) ([]string, error) {
-	return s.repo.QueryFollowingByUserName(name, pageNum)
+func (s *ActivityPubService) GetFollowingByUserName(name string, page models.Page) ([]string, []int, error) {
+	return s.repo.QueryFollowingByUserName(name, page)
 }
 
 func (s *ActivityPubService) GetLikedTotalItemsByUserName(name string) (int, error) {
 	return s.repo.QueryLikedTotalItemsByUserName(name)
 }
 
-// func (s *ActivityPubService) GetLikedByUserName(name string, pageNum int) ([]models.Object, error) {
-func (s *ActivityPubService) GetLikedByUserName(name string, pageNum int) ([]string, error) {
-	return s.repo.QueryLikedByUserName(name, pageNum)
+// func (s *ActivityPubService) GetLikedByUserName(name string, page models.Page) ([]models.Object, error) {
+func (s *ActivityPubService) GetLikedByUserName(name string, page models.Page) ([]string, []int, error) {
+	return s.repo.QueryLikedByUserName(name, page)
 }
 
 func (s *ActivityPubService) GetActivity(ID int) (models.Activity, error) {
@@ -109,6 +694,10 @@ func (s *ActivityPubService) GetObject(ID int) (models.Object, error) {
 }
 
 func (s *ActivityPubService) SaveInboxActivity(activityArb arb.Arb, name string) (arb.Arb, error) {
+	user, err := s.GetUserByName(name)
+	if err != nil {
+		return activityArb, err
+	}
 	activityIRI, err := activitypub.GetIRI(activityArb)
 	if err != nil {
 		return activityArb, err
@@ -121,6 +710,9 @@ func (s *ActivityPubService) SaveInboxActivity(activityArb arb.Arb, name string)
 	if err != nil {
 		return activityArb, err
 	}
+	if s.repo.IsDomainBlocked(actorIRI.Hostname()) {
+		return activityArb, errors.New("domain is blocked")
+	}
 	objectArb, err := activitypub.FindProp(activityArb, "object", activitypub.AcceptHeaders)
 	if err != nil {
 		return activityArb, err
@@ -140,6 +732,23 @@ func (s *ActivityPubService) SaveInboxActivity(activityArb arb.Arb, name string)
 		if err != nil {
 			return activityArb, err
 		}
+		if activityType == "Create" {
+			if objectIRI.Host != s.conf.ServerName {
+				err = s.repo.UpdateRemoteObject(objectArb, activitypub.GetAttachments(objectArb))
+				if err != nil {
+					log.Println(fmt.Sprintf("error storing %s: %s", objectIRI, err))
+				}
+			}
+			s.indexHashtags(activityArb, objectArb)
+		}
+		if activityType == "Create" && user.ActorType == "Group" && activitypub.IsAddressedTo(activityArb, user.IRI) {
+			go func() {
+				err := s.announceToMembers(user, objectIRI.String(), actorIRI.String())
+				if err != nil {
+					log.Println(err)
+				}
+			}()
+		}
 	case "Follow":
 		if objectIRI.String() != recipient {
 			return activityArb, errors.New("wrong inbox")
@@ -158,6 +767,32 @@ func (s *ActivityPubService) SaveInboxActivity(activityArb arb.Arb, name string)
 			return activityArb, err
 		}
 		go s.federator.Federate(models.Federation{Name: name, Recipient: actorIRI.String(), Activity: responseArb})
+	case "Move":
+		if objectIRI.String() != actorIRI.String() {
+			return activityArb, errors.New("actors can only move themselves")
+		}
+		targetIRI, err := activityArb.GetURL("target")
+		if err != nil {
+			return activityArb, err
+		}
+		if s.repo.IsDomainBlocked(targetIRI.Hostname()) {
+			return activityArb, errors.New("move target domain is blocked")
+		}
+		targetArb, err := activitypub.Find(targetIRI.String(), activitypub.AcceptHeaders)
+		if err != nil {
+			return activityArb, err
+		}
+		if !activitypub.IsAlsoKnownAs(targetArb, actorIRI.String()) {
+			return activityArb, errors.New("move target does not list actor in alsoKnownAs")
+		}
+		_, err = s.repo.CreateInboxReferenceActivity(activityArb, objectIRI.String(), actorIRI.String(), name)
+		if err != nil {
+			return activityArb, err
+		}
+		followIRI := s.repo.CheckActivity(name, "Follow", actorIRI.String())
+		if followIRI != "" {
+			go s.migrateFollow(name, followIRI, actorIRI.String(), targetIRI.String())
+		}
 	case "Delete":
 		// TODO: DeleteActivity
 		attributedTo, err := objectArb.GetString("attributedTo")
@@ -175,12 +810,231 @@ func (s *ActivityPubService) SaveInboxActivity(activityArb arb.Arb, name string)
 	default:
 		return activityArb, errors.New("unsupported activity type")
 	}
+	s.notify(name, recipient, activityType, activityArb, objectArb, activityIRI.String(), actorIRI.String(), objectIRI.String())
 	return activityArb, nil
 }
 
-func (s *ActivityPubService) SaveOutboxActivity(activityArb arb.Arb, name string) (arb.Arb, error) {
-	objectArb, err := activitypub.FindProp(activityArb, "object", activitypub.AcceptHeaders)
-	if err != nil {
+// notify records the notification an inbound activity raises for the recipient, if any
+func (s *ActivityPubService) notify(name string, recipient string, activityType string, activityArb arb.Arb, objectArb arb.Arb, activityIRI string, actorIRI string, objectIRI string) {
+	notification := models.Notification{
+		Actor:    actorIRI,
+		Activity: activityIRI,
+		Object:   objectIRI,
+	}
+	switch activityType {
+	case "Follow":
+		notification.Type = "follow"
+		notification.Object = ""
+	case "Like", "Announce":
+		attributedTo, err := s.repo.QueryObjectAttributedTo(objectIRI)
+		if err != nil || attributedTo != recipient {
+			return
+		}
+		notification.Type = strings.ToLower(activityType)
+	case "Create":
+		if inReplyTo, err := objectArb.GetString("inReplyTo"); err == nil && inReplyTo != "" {
+			attributedTo, err := s.repo.QueryObjectAttributedTo(inReplyTo)
+			if err == nil && attributedTo == recipient {
+				notification.Type = "reply"
+			}
+		}
+		if notification.Type == "" && (activitypub.IsMentioned(objectArb, recipient) || activitypub.IsAddressedTo(activityArb, recipient)) {
+			notification.Type = "mention"
+		}
+		if notification.Type == "" {
+			return
+		}
+	case "Undo":
+		err := s.repo.DeleteNotificationsByActivity(name, actorIRI, objectIRI)
+		if err != nil {
+			log.Println(err)
+		}
+		return
+	default:
+		return
+	}
+	_, err := s.repo.CreateNotification(name, notification)
+	if err != nil {
+		log.Println(err)
+	}
+}
+
+func (s *ActivityPubService) GetNotificationsTotalItemsByUserName(name string) (int, error) {
+	return s.repo.QueryNotificationsTotalItemsByUserName(name)
+}
+
+func (s *ActivityPubService) GetNotificationsByUserName(name string, page models.Page) ([]models.Notification, []int, error) {
+	return s.repo.QueryNotificationsByUserName(name, page)
+}
+
+func (s *ActivityPubService) MarkNotificationsRead(name string, ids []int) (int64, error) {
+	return s.repo.UpdateNotificationsRead(name, ids)
+}
+
+func (s *ActivityPubService) GetHashtagTotalItems(tag string) (int, error) {
+	return s.repo.QueryHashtagTotalItems(tag)
+}
+
+func (s *ActivityPubService) GetObjectsByHashtag(tag string, page models.Page) ([]models.Object, []int, error) {
+	return s.repo.QueryObjectsByHashtag(tag, page)
+}
+
+func (s *ActivityPubService) GetFollowedHashtags(name string) ([]models.Hashtag, error) {
+	return s.repo.QueryFollowedHashtagsByUserName(name)
+}
+
+func (s *ActivityPubService) FollowHashtag(name string, tag string) (models.Hashtag, error) {
+	tag = strings.TrimPrefix(strings.TrimSpace(tag), "#")
+	if !activitypub.IsHashtagName(tag) {
+		return models.Hashtag{}, fmt.Errorf("invalid hashtag %s", tag)
+	}
+	return s.repo.CreateFollowedHashtag(name, tag)
+}
+
+func (s *ActivityPubService) UnfollowHashtag(name string, tag string) error {
+	return s.repo.DeleteFollowedHashtag(name, strings.TrimPrefix(tag, "#"))
+}
+
+// Resolve looks up an @user@host handle or an IRI, storing fetched objects so they can be replied to
+func (s *ActivityPubService) Resolve(query string) (arb.Arb, error) {
+	query = strings.TrimSpace(query)
+	var iri string
+	if strings.Contains(query, "://") {
+		iri = query
+	} else {
+		var err error
+		iri, err = s.resolveHandle(query)
+		if err != nil {
+			return nil, err
+		}
+	}
+	resultArb, err := s.fetch(iri)
+	if err != nil {
+		return nil, err
+	}
+	// a post's web page url redirects to its id, which must be on the same host
+	resultIRI, err := activitypub.GetIRI(resultArb)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrRemoteFetch, err)
+	}
+	if resultIRI.String() != iri {
+		resultArb, err = s.fetch(resultIRI.String())
+		if err != nil {
+			return nil, err
+		}
+		if id, err := activitypub.GetIRI(resultArb); err != nil || id.String() != resultIRI.String() {
+			return nil, fmt.Errorf("%w: %s does not resolve to itself", ErrRemoteFetch, resultIRI)
+		}
+	}
+	resultType, err := activitypub.GetType(resultArb)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrRemoteFetch, err)
+	}
+	err = activitypub.FormatRecipients(resultArb)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrRemoteFetch, err)
+	}
+	if activitypub.IsObject(resultType) && resultIRI.Host != s.conf.ServerName {
+		err = s.repo.CreateRemoteObject(resultArb, activitypub.GetAttachments(resultArb))
+		if err != nil {
+			return nil, err
+		}
+		s.indexHashtags(resultArb, resultArb)
+	}
+	return resultArb, nil
+}
+
+// resolveHandle returns the actor iri of a local @user or remote @user@host handle
+func (s *ActivityPubService) resolveHandle(handleString string) (string, error) {
+	handle, err := activitypub.ParseHandle(handleString)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrInvalidQuery, err)
+	}
+	if handle.Host == "" || handle.Host == s.conf.ServerName {
+		user, err := s.GetUserByName(handle.User)
+		if err != nil {
+			return "", fmt.Errorf("%w: %s", ErrNotFound, err)
+		}
+		return user.IRI, nil
+	}
+	if s.repo.IsDomainBlocked(handle.Host) {
+		return "", fmt.Errorf("%w: %s", ErrDomainBlocked, handle.Host)
+	}
+	iri, err := s.webfinger.Lookup(handle)
+	if errors.Is(err, activitypub.ErrNotFound) {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, err)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", ErrRemoteFetch, err)
+	}
+	return iri, nil
+}
+
+// fetch retrieves an ActivityPub document unless its domain is blocked, only connecting to
+// public addresses since the iri comes from the user
+func (s *ActivityPubService) fetch(iri string) (arb.Arb, error) {
+	u, err := url.Parse(iri)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidQuery, iri)
+	}
+	if s.repo.IsDomainBlocked(u.Hostname()) {
+		return nil, fmt.Errorf("%w: %s", ErrDomainBlocked, u.Host)
+	}
+	resultArb, err := activitypub.FindWithClient(s.proxy, iri, activitypub.AcceptHeaders)
+	if errors.Is(err, activitypub.ErrNotFound) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, iri)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrRemoteFetch, err)
+	}
+	return resultArb, nil
+}
+
+// Search finds the objects matching query that the user is allowed to see
+func (s *ActivityPubService) Search(name string, query models.SearchQuery, page models.Page) ([]models.Object, []int, error) {
+	user, err := s.GetUserByName(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if strings.TrimSpace(query.Query) == "" {
+		return nil, nil, errors.New("search query is required")
+	}
+	if query.Author != "" && !strings.Contains(query.Author, "://") {
+		query.Author = fmt.Sprintf("%s://%s/%s/%s", s.conf.Protocol, s.conf.ServerName, s.conf.Endpoints.Users, strings.TrimPrefix(query.Author, "@"))
+	}
+	return s.repo.SearchObjects(user.IRI, query, page)
+}
+
+// indexHashtags stores an object's hashtags and visibility so public objects show on their hashtag timelines
+func (s *ActivityPubService) indexHashtags(activityArb arb.Arb, objectArb arb.Arb) {
+	objectIRI, err := activitypub.GetIRI(objectArb)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	err = s.repo.TagObject(objectIRI.String(), activityArb, objectArb, activitypub.GetHashtags(objectArb), activitypub.IsPublic(activityArb))
+	if err != nil {
+		log.Println(fmt.Sprintf("error indexing hashtags of %s: %s", objectIRI, err))
+	}
+}
+
+func (s *ActivityPubService) SaveOutboxActivity(activityArb arb.Arb, name string) (arb.Arb, error) {
+	_, err := s.GetUserByName(name)
+	if err != nil {
+		return activityArb, err
+	}
+	if activityType, err := activitypub.GetType(activityArb); err == nil && activityType == "Follow" {
+		// accept a handle in place of the followed actor's iri
+		if object, err := activityArb.GetString("object"); err == nil && !strings.Contains(object, "://") {
+			iri, err := s.resolveHandle(object)
+			if err != nil {
+				return activityArb, err
+			}
+			activityArb["object"] = iri
+		}
+	}
+	objectArb, err := activitypub.FindProp(activityArb, "object", activitypub.AcceptHeaders)
+	if err != nil {
 		return activityArb, err
 	}
 	actor := fmt.Sprintf("%s://%s/%s/%s", s.conf.Protocol, s.conf.ServerName, s.conf.Endpoints.Users, name)
@@ -192,7 +1046,11 @@ func (s *ActivityPubService) SaveOutboxActivity(activityArb arb.Arb, name string
 	switch activityType {
 	case "Create":
 		objectArb["attributedTo"] = actor
-		activityArb, err = s.repo.CreateOutboxActivity(activityArb, objectArb, name)
+		err = s.attachPendingMedia(objectArb, name)
+		if err != nil {
+			return activityArb, err
+		}
+		activityArb, err = s.createOutboxObject(activityArb, objectArb, name)
 		if err != nil {
 			return activityArb, err
 		}
@@ -228,6 +1086,41 @@ func (s *ActivityPubService) SaveOutboxActivity(activityArb arb.Arb, name string
 		if err != nil {
 			return activityArb, err
 		}
+	case "Move":
+		objectIRI, err := activitypub.GetIRI(objectArb)
+		if err != nil {
+			return activityArb, err
+		}
+		if objectIRI.String() != actor {
+			return activityArb, errors.New("you can only move your own account")
+		}
+		targetIRI, err := activityArb.GetURL("target")
+		if err != nil {
+			return activityArb, err
+		}
+		targetArb, err := activitypub.Find(targetIRI.String(), activitypub.AcceptHeaders)
+		if err != nil {
+			return activityArb, err
+		}
+		if !activitypub.IsAlsoKnownAs(targetArb, actor) {
+			return activityArb, errors.New("move target does not list this account in alsoKnownAs")
+		}
+		activityArb["object"] = actor
+		activityArb, err = s.repo.CreateOutboxReferenceActivity(activityArb, name)
+		if err != nil {
+			return activityArb, err
+		}
+		_, err = s.repo.UpdateUserMovedTo(name, targetIRI.String())
+		if err != nil {
+			return activityArb, err
+		}
+		// a Move is for the account's followers, who are delivered to here rather than through
+		// its addressing, which would reach them a second time
+		err = s.federateToFollowers(name, activityArb)
+		if err != nil {
+			log.Println(err)
+		}
+		return activityArb, nil
 	case "Delete":
 		attributedTo, err := objectArb.GetString("attributedTo")
 		if err != nil {
@@ -250,7 +1143,7 @@ func (s *ActivityPubService) SaveOutboxActivity(activityArb arb.Arb, name string
 		}
 		for _, href := range hrefs {
 			file := path.Base(href)
-			err = media.Delete(s.conf.UploadDir + file)
+			err = s.storage.Delete(file)
 			if err != nil {
 				log.Println(err)
 			}
@@ -263,6 +1156,9 @@ func (s *ActivityPubService) SaveOutboxActivity(activityArb arb.Arb, name string
 	if err != nil {
 		log.Println(err)
 	}
+	if activityType == "Create" {
+		recipients = append(recipients, mentionedRecipients(activityArb, objectArb)...)
+	}
 	// Deliver to recipients
 	for _, recipient := range recipients {
 		go s.federator.Federate(models.Federation{Name: name, Recipient: recipient.String(), Activity: activityArb})
@@ -270,27 +1166,563 @@ func (s *ActivityPubService) SaveOutboxActivity(activityArb arb.Arb, name string
 	return activityArb, nil
 }
 
-func (s *ActivityPubService) UploadMedia(activityArb arb.Arb, m media.Media, name string) (arb.Arb, error) {
-	err := m.Save(s.conf.UploadDir)
+// createOutboxObject stores a new object of the user's with its Create activity, resolving its
+// mentions and hashtags, and relays it to the local groups it is addressed to
+func (s *ActivityPubService) createOutboxObject(activityArb arb.Arb, objectArb arb.Arb, name string) (arb.Arb, error) {
+	s.tagObject(activityArb, objectArb)
+	activityArb, err := s.repo.CreateOutboxActivity(activityArb, objectArb, name)
 	if err != nil {
-		return nil, err
+		return activityArb, err
+	}
+	s.indexHashtags(activityArb, objectArb)
+	actor, _ := activityArb.GetString("actor")
+	go s.relayToLocalGroups(activityArb, actor)
+	return activityArb, nil
+}
+
+// mentionedRecipients returns the actors mentioned by a new object, who are added to cc
+// and delivered to as well
+func mentionedRecipients(activityArb arb.Arb, objectArb arb.Arb) []*url.URL {
+	var recipients []*url.URL
+	cc, err := activitypub.GetRecipients(activityArb, "cc")
+	if err != nil {
+		log.Println(err)
+	}
+	for _, recipient := range cc {
+		if activitypub.IsMentioned(objectArb, recipient.String()) {
+			recipients = append(recipients, recipient)
+		}
+	}
+	return recipients
+}
+
+// tagObject resolves the @mentions and #hashtags in a new object's content into its tag array,
+// links them in the content and adds the mentioned actors to cc
+func (s *ActivityPubService) tagObject(activityArb arb.Arb, objectArb arb.Arb) {
+	content, err := objectArb.GetString("content")
+	if err != nil || content == "" {
+		return
+	}
+	var tags []interface{}
+	switch v := objectArb["tag"].(type) {
+	case []interface{}:
+		tags = v
+	case map[string]interface{}:
+		tags = []interface{}{v}
+	}
+	mentions := make(map[activitypub.Handle]string)
+	for _, handle := range activitypub.FindMentions(content) {
+		var iri string
+		if handle.Host == "" || handle.Host == s.conf.ServerName {
+			user, err := s.GetUserByName(handle.User)
+			if err != nil {
+				continue
+			}
+			iri = fmt.Sprintf("%s://%s/%s/%s", s.conf.Protocol, s.conf.ServerName, s.conf.Endpoints.Users, user.Name)
+			handle.Host = s.conf.ServerName
+		} else {
+			if s.repo.IsDomainBlocked(handle.Host) {
+				continue
+			}
+			iri, err = s.webfinger.Lookup(handle)
+			if err != nil {
+				log.Println(fmt.Sprintf("unable to resolve %s: %s", handle, err))
+				continue
+			}
+		}
+		mentions[handle] = iri
+		if handle.Host == s.conf.ServerName {
+			mentions[activitypub.Handle{User: handle.User}] = iri
+		}
+		if !activitypub.IsMentioned(objectArb, iri) {
+			tags = append(tags, map[string]interface{}{"type": "Mention", "href": iri, "name": handle.String()})
+		}
+		activitypub.AddRecipient(objectArb, "cc", iri)
+		activitypub.AddRecipient(activityArb, "cc", iri)
+	}
+	hashtags := make(map[string]string)
+	for _, tag := range activitypub.FindHashtags(content) {
+		href := fmt.Sprintf("%s://%s/%s/%s", s.conf.Protocol, s.conf.ServerName, s.conf.Endpoints.Tags, url.PathEscape(strings.ToLower(tag)))
+		hashtags[strings.ToLower(tag)] = href
+		tags = append(tags, map[string]interface{}{"type": "Hashtag", "href": href, "name": "#" + tag})
+	}
+	if len(tags) == 0 {
+		return
+	}
+	objectArb["tag"] = tags
+	objectArb["content"] = activitypub.LinkTags(content, mentions, hashtags)
+}
+
+// relayToLocalGroups has local groups addressed by an outbox Create announce it,
+// since local deliveries never pass through the group's inbox
+func (s *ActivityPubService) relayToLocalGroups(activityArb arb.Arb, author string) {
+	objectArb, err := activityArb.GetArb("object")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	objectIRI, err := activitypub.GetIRI(objectArb)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	for _, prop := range []string{"to", "cc", "audience"} {
+		recipients, err := activitypub.GetRecipients(activityArb, prop)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+		for _, recipient := range recipients {
+			if recipient.Host != s.conf.ServerName {
+				continue
+			}
+			group, err := s.GetUserByName(path.Base(recipient.Path))
+			if err != nil || group.ActorType != "Group" || group.IRI != recipient.String() {
+				continue
+			}
+			err = s.announceToMembers(group, objectIRI.String(), author)
+			if err != nil {
+				log.Println(err)
+			}
+		}
+	}
+}
+
+// announceToMembers boosts an object from a group to all of its followers
+func (s *ActivityPubService) announceToMembers(group models.User, objectIRI string, author string) error {
+	if s.repo.CheckActivity(group.Name, "Announce", objectIRI) != "" {
+		return nil
+	}
+	announceArb, err := activitypub.NewActivityArbReference(objectIRI, "Announce")
+	if err != nil {
+		return err
+	}
+	announceArb["actor"] = group.IRI
+	announceArb["to"] = []string{activitypub.Public}
+	announceArb["cc"] = []string{fmt.Sprintf("%s/%s", group.IRI, s.conf.Endpoints.Followers), author}
+	announceArb, err = s.repo.CreateOutboxReferenceActivity(announceArb, group.Name)
+	if err != nil {
+		return err
+	}
+	go s.federator.Federate(models.Federation{Name: group.Name, Recipient: author, Activity: announceArb})
+	return s.federateToFollowers(group.Name, announceArb)
+}
+
+// migrateFollow replaces a follow of a moved actor with a follow of its new account
+func (s *ActivityPubService) migrateFollow(name string, followIRI string, oldActor string, newActor string) {
+	if s.repo.CheckActivity(name, "Follow", newActor) == "" {
+		followArb, err := activitypub.NewActivityArbReference(newActor, "Follow")
+		if err != nil {
+			log.Println(err)
+			return
+		}
+		followArb["to"] = []string{newActor}
+		_, err = s.SaveOutboxActivity(followArb, name)
+		if err != nil {
+			log.Println(fmt.Sprintf("failed to follow %s for %s: %s", newActor, name, err))
+			return
+		}
+	}
+	undoArb, err := activitypub.NewActivityArbReference(followIRI, "Undo")
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	undoArb["to"] = []string{oldActor}
+	_, err = s.SaveOutboxActivity(undoArb, name)
+	if err != nil {
+		log.Println(fmt.Sprintf("failed to unfollow %s for %s: %s", oldActor, name, err))
 	}
+}
+
+func (s *ActivityPubService) federateToFollowers(name string, activityArb arb.Arb) error {
+	followers, err := s.repo.QueryFollowerIRIsByUserName(name)
+	if err != nil {
+		return err
+	}
+	for _, follower := range followers {
+		go s.federator.Federate(models.Federation{Name: name, Recipient: follower, Activity: activityArb})
+	}
+	return nil
+}
+
+// attachmentArb describes a saved upload as an attachment
+func (s *ActivityPubService) attachmentArb(m media.Media) arb.Arb {
 	fileArb := arb.New()
+	fileArb["type"] = media.ObjectType(m.MimeType)
+	fileArb["mediaType"] = m.MimeType
+	fileArb["url"] = s.uploadURL(m.UUID + m.FileExt)
 	fileArb["name"] = m.Name
 	fileArb["uuid"] = m.UUID
-	fileArb["type"] = "Link"
-	fileArb["href"] = fmt.Sprintf("%s://%s/%s/%s%s", s.conf.Protocol, s.conf.ServerName, s.conf.Endpoints.Uploads, m.UUID, m.FileExt)
-	fileArb["mediaType"] = m.MimeType
+	if m.SHA256 != "" {
+		fileArb["sha256"] = m.SHA256
+	}
+	if m.Width > 0 && m.Height > 0 {
+		fileArb["width"] = m.Width
+		fileArb["height"] = m.Height
+	}
+	if m.Blurhash != "" {
+		fileArb["blurhash"] = m.Blurhash
+	}
+	if m.Preview != "" {
+		previewArb := arb.New()
+		previewArb["type"] = "Image"
+		previewArb["mediaType"] = m.PreviewMimeType
+		previewArb["url"] = s.uploadURL(m.Preview)
+		fileArb["preview"] = previewArb
+	}
+	if m.Duration > 0 {
+		fileArb["duration"] = media.FormatDuration(m.Duration)
+	}
+	if m.Waveform != "" {
+		fileArb["waveform"] = s.uploadURL(m.Waveform)
+	}
+	return fileArb
+}
+
+// UploadPendingMedia stores a file ahead of the post it will be attached to, which must
+// reference it by id within MEDIA_TTL_HOURS
+func (s *ActivityPubService) UploadPendingMedia(file media.Media, name string) (models.PendingMedia, error) {
+	_, err := s.GetUserByName(name)
+	if err != nil {
+		file.Discard(s.storage)
+		return models.PendingMedia{}, fmt.Errorf("%w: %s", ErrNotFound, err)
+	}
+	err = file.SaveWithPreview(s.storage)
+	if err != nil {
+		return models.PendingMedia{}, err
+	}
+	fileArb := s.attachmentArb(file)
+	delete(fileArb, "uuid")
+	pending := models.PendingMedia{
+		ID:         file.UUID,
+		Attachment: fileArb,
+		Title:      file.Title,
+		Artist:     file.Artist,
+		Expires:    time.Now().Add(time.Duration(s.conf.MediaTTL) * time.Hour),
+	}
+	err = s.repo.CreatePendingMedia(name, pending)
+	if err != nil {
+		s.deleteUploads([]arb.Arb{fileArb})
+		return pending, err
+	}
+	return pending, nil
+}
+
+// CreateChunkedUpload starts an upload of length bytes sent over several requests, which can
+// be resumed after a failed one
+func (s *ActivityPubService) CreateChunkedUpload(name string, length int64, altText string) (models.ChunkedUpload, error) {
+	_, err := s.GetUserByName(name)
+	if err != nil {
+		return models.ChunkedUpload{}, err
+	}
+	allowed, err := media.ParseAllowList(s.conf.MediaTypes)
+	if err != nil {
+		return models.ChunkedUpload{}, err
+	}
+	var maxSize int64
+	for _, size := range allowed {
+		if size > maxSize {
+			maxSize = size
+		}
+	}
+	if length <= 0 || length > maxSize {
+		return models.ChunkedUpload{}, fmt.Errorf("invalid upload length %d", length)
+	}
+	upload := models.ChunkedUpload{
+		ID:      uuid.New().String(),
+		Name:    altText,
+		Length:  length,
+		Expires: time.Now().Add(time.Duration(s.conf.MediaTTL) * time.Hour),
+	}
+	err = s.repo.CreateChunkedUpload(name, upload)
+	if err != nil {
+		return upload, err
+	}
+	return upload, nil
+}
+
+func (s *ActivityPubService) GetChunkedUpload(name string, id string) (models.ChunkedUpload, error) {
+	upload, err := s.repo.QueryChunkedUpload(name, id)
+	if err != nil {
+		log.Println(err)
+		return upload, ErrUploadNotFound
+	}
+	return upload, nil
+}
+
+// WriteChunk stores the chunk of a chunked upload starting at offset, which must be where the
+// upload left off. The first chunk decides the file's type, and once the last one arrives the
+// file is received as media uploaded ahead of a post.
+func (s *ActivityPubService) WriteChunk(name string, id string, offset int64, chunk io.Reader) (models.ChunkedUpload, *models.PendingMedia, error) {
+	upload, err := s.GetChunkedUpload(name, id)
+	if err != nil {
+		return upload, nil, err
+	}
+	if offset != upload.Offset {
+		return upload, nil, ErrUploadOffset
+	}
+	allowed, err := media.ParseAllowList(s.conf.MediaTypes)
+	if err != nil {
+		return upload, nil, err
+	}
+
+	limit := upload.Length - upload.Offset
+	if limit > media.MaxChunkSize {
+		limit = media.MaxChunkSize
+	}
+	f, err := os.CreateTemp("", "chunk-*")
+	if err != nil {
+		return upload, nil, err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	size, err := io.Copy(f, io.LimitReader(chunk, limit+1))
+	if err != nil {
+		return upload, nil, err
+	}
+	if size == 0 || size > limit {
+		return upload, nil, fmt.Errorf("chunks must hold between 1 and %d bytes", limit)
+	}
+	mediaType := upload.MediaType
+	if offset == 0 {
+		head := make([]byte, 512)
+		n, err := f.ReadAt(head, 0)
+		if err != nil && err != io.EOF {
+			return upload, nil, err
+		}
+		if n < len(head) && int64(n) < upload.Length {
+			return upload, nil, fmt.Errorf("the first chunk must hold at least %d bytes", len(head))
+		}
+		mediaType = media.Detect(head[:n])
+		maxSize, ok := allowed[mediaType]
+		if !ok {
+			return upload, nil, fmt.Errorf("invalid file type: %s", mediaType)
+		}
+		if upload.Length > maxSize {
+			return upload, nil, fmt.Errorf("file too large for %s: %d", mediaType, upload.Length)
+		}
+	}
+	_, err = f.Seek(0, io.SeekStart)
+	if err != nil {
+		return upload, nil, err
+	}
+	part := media.PartName(id, offset)
+	err = s.storage.Put(part, f, mediaType)
+	if err != nil {
+		return upload, nil, err
+	}
+	recorded, err := s.repo.UpdateChunkedUpload(id, offset, size, part, mediaType)
+	if err != nil || !recorded {
+		// only the part this request stored is removed, never one another request recorded
+		if derr := s.storage.Delete(part); derr != nil {
+			log.Println(derr)
+		}
+		if err == nil {
+			err = ErrUploadOffset
+		}
+		return upload, nil, err
+	}
+	upload.Offset += size
+	upload.Parts = append(upload.Parts, part)
+	upload.MediaType = mediaType
+	if upload.Offset < upload.Length {
+		return upload, nil, nil
+	}
+
+	// the upload is complete, so whether or not the file is accepted its parts are done with
+	defer s.deleteChunkedUpload(upload)
+	parts := media.OpenParts(s.storage, upload.Parts)
+	m, err := media.Receive(parts, upload.Name, allowed, s.storage)
+	parts.Close()
+	if err != nil {
+		return upload, nil, err
+	}
+	pending, err := s.UploadPendingMedia(m, name)
+	if err != nil {
+		return upload, nil, err
+	}
+	return upload, &pending, nil
+}
+
+// DeleteChunkedUpload cancels a chunked upload
+func (s *ActivityPubService) DeleteChunkedUpload(name string, id string) error {
+	upload, err := s.GetChunkedUpload(name, id)
+	if err != nil {
+		return err
+	}
+	s.deleteChunkedUpload(upload)
+	return nil
+}
+
+func (s *ActivityPubService) deleteChunkedUpload(upload models.ChunkedUpload) {
+	err := s.repo.DeleteChunkedUpload(upload.ID)
+	if err != nil {
+		log.Println(err)
+	}
+	for _, part := range upload.Parts {
+		if err := s.storage.Delete(part); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// attachPendingMedia replaces the ids of media uploaded ahead of a post, given in its attachment
+// as strings or as {"mediaId": id, "name": alt text} objects, with the uploaded attachments
+func (s *ActivityPubService) attachPendingMedia(objectArb arb.Arb, name string) error {
+	// only attachments loaded from the user's pending uploads may name stored files
+	switch v := objectArb["attachment"].(type) {
+	case map[string]interface{}:
+		stripUploadFields(v)
+	case arb.Arb:
+		stripUploadFields(v)
+	case []arb.Arb:
+		for _, item := range v {
+			stripUploadFields(item)
+		}
+	case []interface{}:
+		for _, item := range v {
+			switch itemArb := item.(type) {
+			case map[string]interface{}:
+				stripUploadFields(itemArb)
+			case arb.Arb:
+				stripUploadFields(itemArb)
+			}
+		}
+	}
+	items, ok := objectArb["attachment"].([]interface{})
+	if !ok {
+		return nil
+	}
+	var ids []string
+	for _, item := range items {
+		if id := pendingMediaID(item); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	if len(ids) > s.conf.MaxAttachments {
+		return fmt.Errorf("too many attachments, at most %d are allowed", s.conf.MaxAttachments)
+	}
+	pending, err := s.repo.QueryPendingMedia(name, ids)
+	if err != nil {
+		return err
+	}
+	uploads := make(map[string]models.PendingMedia)
+	for _, p := range pending {
+		uploads[p.ID] = p
+	}
+	var attachments []arb.Arb
+	var mediaTypes []string
+	for _, item := range items {
+		id := pendingMediaID(item)
+		if id == "" {
+			switch v := item.(type) {
+			case string:
+				attachments = append(attachments, arb.Arb{"type": "Link", "href": v})
+			case map[string]interface{}:
+				attachments = append(attachments, arb.Arb(v))
+			}
+			continue
+		}
+		upload, ok := uploads[id]
+		if !ok {
+			return fmt.Errorf("media %s not found or expired", id)
+		}
+		// each upload is attached once
+		delete(uploads, id)
+		fileArb := arb.Arb(upload.Attachment)
+		fileArb["uuid"] = upload.ID
+		// json numbers come back as floats
+		for _, prop := range []string{"width", "height"} {
+			if n, ok := fileArb[prop].(float64); ok {
+				fileArb[prop] = int(n)
+			}
+		}
+		if itemArb, ok := item.(map[string]interface{}); ok {
+			if alt, ok := itemArb["name"].(string); ok {
+				fileArb["name"] = alt
+			}
+		}
+		if fileArb.Exists("duration") && !objectArb.Exists("duration") {
+			s.describeAudio(objectArb, fileArb, upload.Title, upload.Artist)
+		}
+		mediaType, _ := fileArb.GetString("mediaType")
+		mediaTypes = append(mediaTypes, mediaType)
+		attachments = append(attachments, fileArb)
+	}
+	if len(attachments) == 1 && len(mediaTypes) == 1 {
+		if objectType, err := activitypub.GetType(objectArb); err != nil || media.IsObjectType(objectType) {
+			objectArb["type"] = media.ObjectType(mediaTypes[0])
+		}
+	}
+	objectArb["attachment"] = attachments
+	return nil
+}
+
+// stripUploadFields removes the fields the repository stores an attachment's file by
+func stripUploadFields(attachment map[string]interface{}) {
+	delete(attachment, "uuid")
+	delete(attachment, "sha256")
+}
+
+// pendingMediaID is the id of an upload referenced by an attachment, if it references one
+func pendingMediaID(item interface{}) string {
+	switch v := item.(type) {
+	case string:
+		if !strings.Contains(v, "://") {
+			return v
+		}
+	case map[string]interface{}:
+		if id, ok := v["mediaId"].(string); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+func (s *ActivityPubService) UploadMedia(activityArb arb.Arb, files []media.Media, name string) (arb.Arb, error) {
+	_, err := s.GetUserByName(name)
+	if err != nil {
+		media.Discard(files, s.storage)
+		return activityArb, fmt.Errorf("%w: %s", ErrNotFound, err)
+	}
 	objectArb, err := activitypub.FindProp(activityArb, "object", activitypub.AcceptHeaders)
 	if err != nil {
-		return activityArb, err
+		media.Discard(files, s.storage)
+		return activityArb, fmt.Errorf("%w: %s", ErrInvalidObject, err)
+	}
+	var attachments []arb.Arb
+	for i := range files {
+		err = files[i].SaveWithPreview(s.storage)
+		if err != nil {
+			// remove the files saved so far, the rest are closed unsaved
+			s.deleteUploads(attachments)
+			media.Discard(files[i+1:], s.storage)
+			return nil, err
+		}
+		fileArb := s.attachmentArb(files[i])
+		if files[i].Duration > 0 && !objectArb.Exists("duration") {
+			s.describeAudio(objectArb, fileArb, files[i].Title, files[i].Artist)
+		}
+		attachments = append(attachments, fileArb)
+	}
+	if len(files) == 1 {
+		if objectType, err := activitypub.GetType(objectArb); err != nil || media.IsObjectType(objectType) {
+			objectArb["type"] = media.ObjectType(files[0].MimeType)
+		}
 	}
 	actor := fmt.Sprintf("%s://%s/%s/%s", s.conf.Protocol, s.conf.ServerName, s.conf.Endpoints.Users, name)
 	activityArb["actor"] = actor
 	objectArb["attributedTo"] = actor
-	objectArb["url"] = []arb.Arb{fileArb}
-	activityArb, err = s.repo.CreateOutboxActivity(activityArb, objectArb, name)
+	objectArb["attachment"] = attachments
+	activityArb, err = s.createOutboxObject(activityArb, objectArb, name)
 	if err != nil {
+		s.deleteUploads(attachments)
 		return activityArb, err
 	}
 	// Get recipients
@@ -298,6 +1730,7 @@ func (s *ActivityPubService) UploadMedia(activityArb arb.Arb, m media.Media, nam
 	if err != nil {
 		log.Println(err)
 	}
+	recipients = append(recipients, mentionedRecipients(activityArb, objectArb)...)
 	// Deliver to recipients
 	for _, recipient := range recipients {
 		go s.federator.Federate(models.Federation{Name: name, Recipient: recipient.String(), Activity: activityArb})
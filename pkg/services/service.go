@@ -1,6 +1,8 @@
 package services
 
 import (
+	"io"
+
 	"github.com/cheebz/arb"
 	"github.com/cheebz/go-pub/pkg/media"
 	"github.com/cheebz/go-pub/pkg/models"
@@ -9,25 +11,60 @@ import (
 type Service interface {
 	DiscoverUserByName(name string) (models.User, error)
 	GetUserByName(name string) (models.User, error)
+	GetProfileByName(name string) (models.User, error)
 	CheckUser(name string) error
 	CreateUser(name string) (string, error)
+	CreateActor(name string, actorType string) (models.User, error)
+	SetActorType(name string, actorType string) (models.User, error)
+	GetUsers() ([]models.User, error)
+	SuspendUser(name string) (models.User, error)
+	UnsuspendUser(name string) (models.User, error)
+	ToggleUserDiscoverable(name string) (models.User, error)
+	DeleteUser(name string) error
+	SetAlsoKnownAs(name string, alsoKnownAs []string) (models.User, error)
+	UpdateProfile(name string, displayName *string, summary *string, icon *media.Media, image *media.Media) (models.User, error)
+	SetProfileFields(name string, fields []models.ProfileField) (models.User, error)
+	GetFederationStats() models.FederationStats
+	GetDomainBlocks() ([]models.DomainBlock, error)
+	BlockDomain(domain string) (models.DomainBlock, error)
+	UnblockDomain(domain string) error
+	Subscribe(name string) (<-chan models.StreamEvent, func(), error)
+	SubscribeHashtags() (<-chan models.StreamEvent, func())
+	GetNotificationsTotalItemsByUserName(name string) (int, error)
+	GetNotificationsByUserName(name string, page models.Page) ([]models.Notification, []int, error)
+	MarkNotificationsRead(name string, ids []int) (int64, error)
+	Resolve(query string) (arb.Arb, error)
+	Search(name string, query models.SearchQuery, page models.Page) ([]models.Object, []int, error)
+	GetHashtagTotalItems(tag string) (int, error)
+	GetObjectsByHashtag(tag string, page models.Page) ([]models.Object, []int, error)
+	GetFollowedHashtags(name string) ([]models.Hashtag, error)
+	FollowHashtag(name string, tag string) (models.Hashtag, error)
+	UnfollowHashtag(name string, tag string) error
 	GetFeedTotalItemsByUserName(name string) (int, error)
-	GetFeedByUserName(name string, pageNum int) ([]models.Activity, error)
+	GetFeedByUserName(name string, page models.Page) ([]models.Activity, []int, error)
 	GetInboxTotalItemsByUserName(name string) (int, error)
-	GetInboxByUserName(name string, pageNum int) ([]models.Activity, error)
+	GetInboxByUserName(name string, page models.Page) ([]models.Activity, []int, error)
 	GetOutboxTotalItemsByUserName(name string) (int, error)
-	GetOutboxByUserName(name string, pageNum int) ([]models.Activity, error)
+	GetOutboxByUserName(name string, page models.Page) ([]models.Activity, []int, error)
 	GetFollowersTotalItemsByUserName(name string) (int, error)
-	GetFollowersByUserName(name string, pageNum int) ([]string, error)
+	GetFollowersByUserName(name string, page models.Page) ([]string, []int, error)
 	GetFollowingTotalItemsByUserName(name string) (int, error)
-	GetFollowingByUserName(name string, pageNum int) ([]string, error)
+	GetFollowingByUserName(name string, page models.Page) ([]string, []int, error)
 	GetLikedTotalItemsByUserName(name string) (int, error)
-	// GetLikedByUserName(name string, pageNum int) ([]models.Object, error)
-	GetLikedByUserName(name string, pageNum int) ([]string, error)
+	// GetLikedByUserName(name string, page models.Page) ([]models.Object, error)
+	GetLikedByUserName(name string, page models.Page) ([]string, []int, error)
 	GetActivity(ID int) (models.Activity, error)
 	GetObject(ID int) (models.Object, error)
 	SaveInboxActivity(activityArb arb.Arb, name string) (arb.Arb, error)
 	SaveOutboxActivity(activityArb arb.Arb, name string) (arb.Arb, error)
-	UploadMedia(activityArb arb.Arb, m media.Media, name string) (arb.Arb, error)
+	UploadMedia(activityArb arb.Arb, files []media.Media, name string) (arb.Arb, error)
+	UploadPendingMedia(file media.Media, name string) (models.PendingMedia, error)
+	CreateChunkedUpload(name string, length int64, altText string) (models.ChunkedUpload, error)
+	GetChunkedUpload(name string, id string) (models.ChunkedUpload, error)
+	WriteChunk(name string, id string, offset int64, chunk io.Reader) (models.ChunkedUpload, *models.PendingMedia, error)
+	DeleteChunkedUpload(name string, id string) error
 	CheckActivity(name string, activityType string, objectIRI string) string
+	ProxyMedia(signature string, iri string) (models.ProxiedMedia, error)
+	ProxyActivity(activityArb arb.Arb) arb.Arb
+	ProxyObject(objectArb arb.Arb) arb.Arb
 }
@@ -0,0 +1,11 @@
+package streams
+
+import "github.com/cheebz/go-pub/pkg/models"
+
+// HashtagTopic carries newly tagged public objects, which hashtag streams filter by tag
+const HashtagTopic = "#hashtags"
+
+type Broker interface {
+	Publish(event models.StreamEvent) error
+	Subscribe(topic string) (<-chan models.StreamEvent, func())
+}
@@ -0,0 +1,114 @@
+package streams
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/cheebz/go-pub/pkg/config"
+	"github.com/cheebz/go-pub/pkg/models"
+	"github.com/go-redis/redis/v7"
+)
+
+type PubSubBroker struct {
+	conf        config.Configuration
+	client      *redis.Client
+	mu          sync.RWMutex
+	subscribers map[string]map[chan models.StreamEvent]bool
+}
+
+// NewPubSubBroker delivers events to subscribers in this process, publishing them
+// through Redis first when STREAM_FAN_OUT is set so every instance receives them
+func NewPubSubBroker(_conf config.Configuration) Broker {
+	b := &PubSubBroker{
+		conf:        _conf,
+		subscribers: make(map[string]map[chan models.StreamEvent]bool),
+	}
+	if _conf.StreamFanOut {
+		b.client = redis.NewClient(&redis.Options{
+			Addr:     fmt.Sprintf("%s:%d", _conf.Redis.Host, _conf.Redis.Port),
+			Password: _conf.Redis.Password,
+			DB:       _conf.Redis.Db,
+		})
+		go b.listen()
+	}
+	return b
+}
+
+func (b *PubSubBroker) channel(topic string) string {
+	return fmt.Sprintf("%s-stream-%s", b.conf.ServerName, topic)
+}
+
+func (b *PubSubBroker) Publish(event models.StreamEvent) error {
+	if b.client == nil {
+		b.deliver(event)
+		return nil
+	}
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(b.channel(event.Topic), payload).Err()
+}
+
+func (b *PubSubBroker) listen() {
+	pubsub := b.client.PSubscribe(b.channel("*"))
+	defer pubsub.Close()
+	for msg := range pubsub.Channel() {
+		var event models.StreamEvent
+		err := json.Unmarshal([]byte(msg.Payload), &event)
+		if err != nil {
+			log.Println(fmt.Sprintf("invalid stream event on %s: %s", msg.Channel, err))
+			continue
+		}
+		b.deliver(event)
+	}
+}
+
+// deliver hands the event to every subscriber of its topic. Subscribers whose buffer
+// is full are dropped (their channel is closed) so clients reconnect and catch up from
+// their last event instead of silently missing some
+func (b *PubSubBroker) deliver(event models.StreamEvent) {
+	var slow []chan models.StreamEvent
+	b.mu.RLock()
+	for ch := range b.subscribers[event.Topic] {
+		select {
+		case ch <- event:
+		default:
+			slow = append(slow, ch)
+		}
+	}
+	b.mu.RUnlock()
+	for _, ch := range slow {
+		log.Println(fmt.Sprintf("dropping slow subscriber of %s", event.Topic))
+		b.remove(event.Topic, ch)
+	}
+}
+
+func (b *PubSubBroker) remove(topic string, ch chan models.StreamEvent) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.subscribers[topic][ch] {
+		return
+	}
+	delete(b.subscribers[topic], ch)
+	if len(b.subscribers[topic]) == 0 {
+		delete(b.subscribers, topic)
+	}
+	close(ch)
+}
+
+// Subscribe returns a channel of events for the topic and a function that closes it
+func (b *PubSubBroker) Subscribe(topic string) (<-chan models.StreamEvent, func()) {
+	ch := make(chan models.StreamEvent, b.conf.StreamBuffer)
+	b.mu.Lock()
+	if b.subscribers[topic] == nil {
+		b.subscribers[topic] = make(map[chan models.StreamEvent]bool)
+	}
+	b.subscribers[topic][ch] = true
+	b.mu.Unlock()
+	return ch, func() {
+		b.remove(topic, ch)
+	}
+}
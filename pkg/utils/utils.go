@@ -2,8 +2,15 @@ package utils
 
 import (
 	"bytes"
+	"context"
+	"fmt"
+	"html"
 	"io"
+	"net/http"
 	"net/url"
+	"regexp"
+	"strings"
+	"time"
 )
 
 func ParseLimitedPayload(r io.Reader, n int64) ([]byte, error) {
@@ -24,6 +31,64 @@ func IsFromHost(rawurl string, host string) bool {
 	return u.Host == host
 }
 
+var (
+	relMeTagRegexp  = regexp.MustCompile(`(?i)<(?:a|link)\s[^>]*>`)
+	relMeAttrRegexp = regexp.MustCompile(`(?i)\b(rel|href)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s>]+))`)
+)
+
+// LinksBack fetches an HTML page with client and checks for a rel="me" link to target. The page
+// is user supplied, so client should refuse to connect to non-public addresses.
+func LinksBack(client *http.Client, page string, target string) (bool, error) {
+	u, err := url.Parse(page)
+	if err != nil {
+		return false, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false, fmt.Errorf("invalid link %s", page)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", page, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Add("Accept", "text/html")
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("received status code %d from %s", resp.StatusCode, page)
+	}
+	body, err := ParseLimitedPayload(resp.Body, 1*1024*1024)
+	if err != nil {
+		return false, err
+	}
+	target = strings.TrimSuffix(target, "/")
+	for _, tag := range relMeTagRegexp.FindAll(body, -1) {
+		var isMe bool
+		var href string
+		for _, attr := range relMeAttrRegexp.FindAllSubmatch(tag, -1) {
+			value := string(attr[2]) + string(attr[3]) + string(attr[4])
+			switch strings.ToLower(string(attr[1])) {
+			case "rel":
+				for _, rel := range strings.Fields(value) {
+					if strings.EqualFold(rel, "me") {
+						isMe = true
+					}
+				}
+			case "href":
+				href = html.UnescapeString(value)
+			}
+		}
+		if isMe && strings.TrimSuffix(href, "/") == target {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
 // func MakeGenericArray(typed interface{}) ([]interface{}, error) {
 // 	if array, ok := typed.([]interface{}); ok {
 // 		generic := make([]interface{}, len(array))
@@ -6,19 +6,22 @@ import (
 	"time"
 
 	"github.com/cheebz/go-pub/pkg/config"
+	"github.com/cheebz/go-pub/pkg/media"
 	"github.com/cheebz/go-pub/pkg/repositories"
 )
 
 type FileWorker struct {
 	conf    config.Configuration
 	repo    repositories.Repository
+	storage media.Storage
 	channel chan interface{}
 }
 
-func NewFileWorker(_conf config.Configuration, _repo repositories.Repository) Worker {
+func NewFileWorker(_conf config.Configuration, _repo repositories.Repository, _storage media.Storage) Worker {
 	return &FileWorker{
 		conf:    _conf,
 		repo:    _repo,
+		storage: _storage,
 		channel: make(chan interface{}),
 	}
 }
@@ -26,13 +29,26 @@ func NewFileWorker(_conf config.Configuration, _repo repositories.Repository) Wo
 func (f *FileWorker) Start() {
 	go func() {
 		for {
-			err := f.repo.PurgeUnusedFiles()
+			purge, err := f.repo.PurgeUnusedFiles(f.storage, time.Duration(f.conf.FilePurgeGrace)*time.Hour, false)
 			if err != nil {
 				log.Println(fmt.Sprintf("Failed to purge unused files: %s", err.Error()))
+			} else {
+				log.Println(fmt.Sprintf("Purged %d unused files (%d bytes) and %d rows for missing files, kept %d recent files", purge.Files, purge.Bytes, purge.Rows, purge.Kept))
 			}
 			time.Sleep(24 * time.Hour)
 		}
 	}()
+	go func() {
+		for {
+			count, err := f.repo.PurgeExpiredMedia(f.storage)
+			if err != nil {
+				log.Println(fmt.Sprintf("Failed to purge expired media: %s", err.Error()))
+			} else if count > 0 {
+				log.Println(fmt.Sprintf("Purged %d expired media uploads", count))
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
 }
 
 func (f *FileWorker) GetChannel() chan interface{} {